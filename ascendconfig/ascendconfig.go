@@ -0,0 +1,288 @@
+/* Copyright(C) 2023. Huawei Technologies Co.,Ltd. All rights reserved.
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package ascendconfig node level configuration shared by the runtime, the hook and the install helper
+package ascendconfig
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"mindxcheckutils"
+)
+
+const (
+	// DefaultConfigPath the config file loaded by every ascend docker binary
+	DefaultConfigPath = "/etc/ascend-docker-runtime.d/config.json"
+	// DefaultLogDir directory of the run logs
+	DefaultLogDir = "/var/log/ascend-docker-runtime"
+	// DefaultLdLibraryPath driver libraries appended to LD_LIBRARY_PATH of the container
+	DefaultLdLibraryPath = "/usr/local/Ascend/driver/lib64/common:/usr/local/Ascend/driver/lib64/driver"
+	// DefaultLdconfigPath ldconfig of the host used to refresh ld.so.cache of the container
+	DefaultLdconfigPath = "/sbin/ldconfig"
+	// DefaultTopologyCachePath cache of the node topology, it lives in tmpfs and is dropped by a reboot
+	DefaultTopologyCachePath = "/run/ascend-docker-runtime/topology.json"
+	// DefaultRankTablePath path of the hccl rank table in the container
+	DefaultRankTablePath = "/run/ascend/hccl.json"
+	// DeviceMountsRoot the mounts under it request devices when accept-ascend-visible-devices-as-volume-mounts
+	// is set
+	DeviceMountsRoot = "/var/run/ascend-container-devices"
+	// HookOnlyOption the argument of ascend-docker-hook run by podman or cri-o through hooks.d, the spec was not
+	// modified by ascend-docker-runtime
+	HookOnlyOption = "--hook-only"
+
+	// UnhealthyPolicyIgnore devices are injected without checking the health
+	UnhealthyPolicyIgnore = "ignore"
+	// UnhealthyPolicyWarn unhealthy devices are injected with a warning
+	UnhealthyPolicyWarn = "warn"
+	// UnhealthyPolicyReject the container fails when any requested device is unhealthy
+	UnhealthyPolicyReject = "reject"
+
+	// log level of hwlog, -1 debug, 0 info, 1 warning, 2 error, 3 critical
+	minLogLevel = -1
+	maxLogLevel = 3
+	// DefaultLogLevel info
+	DefaultLogLevel = 0
+
+	maxRuntimeNames = 16
+	kvPairSize      = 2
+	maxRuntimeArgs  = 16
+)
+
+// DefaultRuntimeNames runc binaries looked up in PATH, the first found is used
+var DefaultRuntimeNames = []string{"docker-runc", "runc"}
+
+// Config content of config.json, fields absent from the file keep their default
+type Config struct {
+	// LogLevel log level of the run logs
+	LogLevel int `json:"log-level"`
+	// LogDir directory of the run logs
+	LogDir string `json:"log-dir"`
+	// RuntimePath absolute path of the low level runtime, it takes precedence over RuntimeNames
+	RuntimePath string `json:"runtime-path"`
+	// RuntimeNames names or absolute paths of the low level runtime, tried in order
+	RuntimeNames []string `json:"runtime-names"`
+	// RuntimeArgs global options passed to the low level runtime before the command
+	RuntimeArgs []string `json:"runtime-args"`
+	// LdLibraryPath driver libraries appended to LD_LIBRARY_PATH, empty means not to set it
+	LdLibraryPath string `json:"ld-library-path"`
+	// ExportLdLibraryPath append LdLibraryPath to LD_LIBRARY_PATH of the container
+	ExportLdLibraryPath bool `json:"export-ld-library-path"`
+	// LdconfigPath ldconfig registering LdLibraryPath in ld.so.cache of the container, empty means not to register
+	LdconfigPath string `json:"ldconfig-path"`
+	// DriverRoot root of the driver files on the host, empty means discovered from the install info
+	DriverRoot string `json:"driver-root"`
+	// DevRoot root of the device nodes on the host, empty means /
+	DevRoot string `json:"dev-root"`
+	// TopologyCache cache of chip name, product type and device ids, empty means to query dcmi every time
+	TopologyCache string `json:"topology-cache"`
+	// UnhealthyDevicePolicy what to do when a requested device is unhealthy, ignore, warn or reject
+	UnhealthyDevicePolicy string `json:"unhealthy-device-policy"`
+	// ExclusiveDevices lock the davinci devices of a container so that no other live container gets them
+	ExclusiveDevices bool `json:"exclusive-devices"`
+	// RankTablePath path in the container of the hccl rank table of a multi-device container, empty means none
+	RankTablePath string `json:"rank-table-path"`
+	// DeviceGID group in the container of the devices, it is added to the process, nil means the group of the host
+	DeviceGID *uint32 `json:"device-gid"`
+	// HookPath path of ascend-docker-hook, empty means next to ascend-docker-runtime
+	HookPath string `json:"hook-path"`
+	// CliPath path of ascend-docker-cli, empty means next to ascend-docker-hook
+	CliPath string `json:"cli-path"`
+	// AcceptEnvvar accept device requests through ASCEND_VISIBLE_DEVICES and its annotation
+	AcceptEnvvar bool `json:"accept-ascend-visible-devices-envvar"`
+	// AcceptVolumeMounts accept device requests through mounts under /var/run/ascend-container-devices
+	AcceptVolumeMounts bool `json:"accept-ascend-visible-devices-as-volume-mounts"`
+}
+
+// Default get the config used when config.json does not exist
+func Default() *Config {
+	return &Config{
+		LogLevel:              DefaultLogLevel,
+		LogDir:                DefaultLogDir,
+		RuntimeNames:          append([]string{}, DefaultRuntimeNames...),
+		LdLibraryPath:         DefaultLdLibraryPath,
+		ExportLdLibraryPath:   true,
+		LdconfigPath:          DefaultLdconfigPath,
+		TopologyCache:         DefaultTopologyCachePath,
+		UnhealthyDevicePolicy: UnhealthyPolicyIgnore,
+		RankTablePath:         DefaultRankTablePath,
+		AcceptEnvvar:          true,
+		AcceptVolumeMounts:    false,
+	}
+}
+
+// Load read and validate the config file, the defaults are used when it does not exist
+func Load(configPath string) (*Config, error) {
+	if _, err := os.Stat(configPath); os.IsNotExist(err) {
+		cfg := Default()
+		cfg.DriverRoot = discoverDriverRoot(installInfoPath)
+		return cfg, nil
+	}
+	if _, err := mindxcheckutils.RealHostFileChecker(configPath, true, false, mindxcheckutils.DefaultSize); err != nil {
+		return nil, err
+	}
+	content, err := ioutil.ReadFile(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file %s: %v", configPath, err)
+	}
+	cfg, err := Parse(content)
+	if err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %v", configPath, err)
+	}
+	if cfg.DriverRoot == "" {
+		cfg.DriverRoot = discoverDriverRoot(installInfoPath)
+	}
+	return cfg, nil
+}
+
+// Parse parse the content of config.json on top of the defaults
+func Parse(content []byte) (*Config, error) {
+	cfg := Default()
+	if err := json.Unmarshal(content, cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse config: %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func checkAbsPath(name, value string) error {
+	if !filepath.IsAbs(value) || !mindxcheckutils.StringChecker(value, 0, mindxcheckutils.DefaultPathSize,
+		mindxcheckutils.DefaultWhiteList) {
+		return fmt.Errorf("%s should be a valid absolute path: %s", name, value)
+	}
+	return nil
+}
+
+// Validate check every field of the config
+func (c *Config) Validate() error {
+	if c.LogLevel < minLogLevel || c.LogLevel > maxLogLevel {
+		return fmt.Errorf("log-level should be in [%d, %d]: %d", minLogLevel, maxLogLevel, c.LogLevel)
+	}
+	if err := checkAbsPath("log-dir", c.LogDir); err != nil {
+		return err
+	}
+	if len(c.RuntimeNames) == 0 || len(c.RuntimeNames) > maxRuntimeNames {
+		return fmt.Errorf("runtime-names should have 1 to %d items", maxRuntimeNames)
+	}
+	for _, name := range c.RuntimeNames {
+		if !mindxcheckutils.StringChecker(name, 0, mindxcheckutils.DefaultPathSize, mindxcheckutils.DefaultWhiteList) {
+			return fmt.Errorf("invalid runtime name: %s", name)
+		}
+		if strings.Contains(name, "/") && !filepath.IsAbs(name) {
+			return fmt.Errorf("runtime name should be a name or an absolute path: %s", name)
+		}
+	}
+	if c.RuntimePath != "" {
+		if err := checkAbsPath("runtime-path", c.RuntimePath); err != nil {
+			return err
+		}
+	}
+	if len(c.RuntimeArgs) > maxRuntimeArgs {
+		return fmt.Errorf("runtime-args should have at most %d items", maxRuntimeArgs)
+	}
+	for _, arg := range c.RuntimeArgs {
+		if !mindxcheckutils.StringChecker(arg, 0, mindxcheckutils.DefaultStringSize,
+			mindxcheckutils.DefaultWhiteList+"=") {
+			return fmt.Errorf("invalid runtime arg: %s", arg)
+		}
+	}
+	if c.LdLibraryPath != "" {
+		for _, libPath := range strings.Split(c.LdLibraryPath, ":") {
+			if err := checkAbsPath("ld-library-path", libPath); err != nil {
+				return err
+			}
+		}
+	}
+	if c.LdconfigPath != "" {
+		if err := checkAbsPath("ldconfig-path", c.LdconfigPath); err != nil {
+			return err
+		}
+	}
+	if c.DriverRoot != "" {
+		if err := checkAbsPath("driver-root", c.DriverRoot); err != nil {
+			return err
+		}
+	}
+	if c.DevRoot != "" {
+		if err := checkAbsPath("dev-root", c.DevRoot); err != nil {
+			return err
+		}
+	}
+	if c.RankTablePath != "" {
+		if err := checkAbsPath("rank-table-path", c.RankTablePath); err != nil {
+			return err
+		}
+	}
+	if c.TopologyCache != "" {
+		if err := checkAbsPath("topology-cache", c.TopologyCache); err != nil {
+			return err
+		}
+	}
+	switch c.UnhealthyDevicePolicy {
+	case UnhealthyPolicyIgnore, UnhealthyPolicyWarn, UnhealthyPolicyReject:
+	default:
+		return fmt.Errorf("unhealthy-device-policy should be %s, %s or %s: %s", UnhealthyPolicyIgnore,
+			UnhealthyPolicyWarn, UnhealthyPolicyReject, c.UnhealthyDevicePolicy)
+	}
+	if c.HookPath != "" {
+		if err := checkAbsPath("hook-path", c.HookPath); err != nil {
+			return err
+		}
+	}
+	if c.CliPath != "" {
+		if err := checkAbsPath("cli-path", c.CliPath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LogFile get the path of a run log, like runtime-run.log
+func (c *Config) LogFile(name string) string {
+	return filepath.Join(c.LogDir, name)
+}
+
+// GetMountedDeviceRequest get the device request of the mounts, every mount under DeviceMountsRoot requests the
+// device named by its base name
+func GetMountedDeviceRequest(destinations []string) string {
+	devices := make([]string, 0)
+	for _, destination := range destinations {
+		destination = filepath.Clean(destination)
+		if filepath.Dir(destination) != DeviceMountsRoot {
+			continue
+		}
+		devices = append(devices, filepath.Base(destination))
+	}
+	return strings.Join(devices, ",")
+}
+
+// GetDeviceRequest get the device request from the sources accepted by the config, the mounts come first and
+// envRequest getting ASCEND_VISIBLE_DEVICES or its annotation is called only when envvar requests are accepted
+func (c *Config) GetDeviceRequest(destinations []string, envRequest func() string) string {
+	if c.AcceptVolumeMounts {
+		if res := GetMountedDeviceRequest(destinations); res != "" {
+			return res
+		}
+	}
+	if !c.AcceptEnvvar {
+		return ""
+	}
+	return envRequest()
+}
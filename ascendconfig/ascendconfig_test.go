@@ -0,0 +1,141 @@
+/* Copyright(C) 2023. Huawei Technologies Co.,Ltd. All rights reserved.
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package ascendconfig
+package ascendconfig
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLoadNotExist(t *testing.T) {
+	cfg, err := Load(filepath.Join(t.TempDir(), "config.json"))
+	if err != nil || !reflect.DeepEqual(cfg, Default()) {
+		t.Fatalf("%v %v", cfg, err)
+	}
+}
+
+func TestParse(t *testing.T) {
+	cfg, err := Parse([]byte(`{"log-level": 1, "runtime-names": ["/usr/local/sbin/runc"],
+		"runtime-path": "/usr/bin/crun", "runtime-args": ["--cgroup-manager=systemd"],
+		"ld-library-path": "/opt/driver/lib64", "accept-ascend-visible-devices-envvar": false,
+		"accept-ascend-visible-devices-as-volume-mounts": true,
+		"export-ld-library-path": false, "ldconfig-path": ""}`))
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	if cfg.LogLevel != 1 || cfg.RuntimeNames[0] != "/usr/local/sbin/runc" || cfg.LdLibraryPath != "/opt/driver/lib64" ||
+		cfg.AcceptEnvvar || !cfg.AcceptVolumeMounts || cfg.RuntimePath != "/usr/bin/crun" ||
+		cfg.RuntimeArgs[0] != "--cgroup-manager=systemd" || cfg.ExportLdLibraryPath || cfg.LdconfigPath != "" {
+		t.Fatalf("unexpected config %v", cfg)
+	}
+	if cfg.TopologyCache != DefaultTopologyCachePath || cfg.UnhealthyDevicePolicy != UnhealthyPolicyIgnore ||
+		cfg.RankTablePath != DefaultRankTablePath || cfg.DeviceGID != nil {
+		t.Fatalf("default topology cache is lost %v", cfg)
+	}
+	if cfg.LogDir != DefaultLogDir || cfg.LogFile("hook-run.log") != DefaultLogDir+"/hook-run.log" {
+		t.Fatalf("default log dir is lost %v", cfg)
+	}
+}
+
+func TestParseDeviceGID(t *testing.T) {
+	cfg, err := Parse([]byte(`{"device-gid": 44}`))
+	if err != nil || cfg.DeviceGID == nil || *cfg.DeviceGID != 44 {
+		t.Fatalf("%v %v", cfg, err)
+	}
+	if _, err = Parse([]byte(`{"device-gid": -1}`)); err == nil {
+		t.Fatalf("negative device-gid should be refused")
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, content := range []string{
+		`{"accept-ascend-visible-devices-envvar": "no"}`,
+		`{"accept-ascend-visible-devices-as-volume-mounts": 1}`,
+		`{"log-level": 5}`,
+		`{"log-dir": "var/log"}`,
+		`{"rank-table-path": "hccl.json"}`,
+		`{"runtime-names": []}`,
+		`{"runtime-names": ["bin/runc"]}`,
+		`{"runtime-path": "crun"}`,
+		`{"runtime-args": ["--log=/tmp/a b"]}`,
+		`{"ld-library-path": "/usr/lib64:lib"}`,
+		`{"hook-path": "ascend-docker-hook"}`,
+		`{"cli-path": "/usr/local/bin/ascend docker cli"}`,
+		`{"driver-root": "run/ascend/driver"}`,
+		`{"ldconfig-path": "ldconfig"}`,
+		`{"dev-root": "/run/ascend driver"}`,
+		`{"topology-cache": "topology.json"}`,
+		`{"unhealthy-device-policy": "fail"}`,
+	} {
+		if cfg, err := Parse([]byte(content)); err == nil {
+			t.Fatalf("%s should be invalid: %v", content, cfg)
+		}
+	}
+}
+
+func TestParseDriverRoot(t *testing.T) {
+	for content, root := range map[string]string{
+		"UserName=HwHiAiUser\nDriver_Install_Path_Param=/usr/local/Ascend\n":                  "",
+		"Driver_Install_Path_Param=/run/ascend/driver/usr/local/Ascend\n":                     "/run/ascend/driver",
+		"Driver_Install_Type=full\nDriver_Install_Path_Param = /run/driver/usr/local/Ascend/": "/run/driver",
+		"Driver_Install_Path_Param=/opt/Ascend\n":                                             "",
+		"Driver_Install_Path_Param=run/usr/local/Ascend\n":                                    "",
+		"UserName=HwHiAiUser\n": "",
+	} {
+		if got := parseDriverRoot([]byte(content)); got != root {
+			t.Fatalf("driver root of %q should be %q, got %q", content, root, got)
+		}
+	}
+}
+
+func TestHostPath(t *testing.T) {
+	cfg := Default()
+	if HostPath(cfg.GetDriverRoot(), "/usr/local/dcmi") != "/usr/local/dcmi" ||
+		HostPath(cfg.GetDevRoot(), "/dev/davinci0") != "/dev/davinci0" {
+		t.Fatalf("default roots should not change the path")
+	}
+	cfg, err := Parse([]byte(`{"driver-root": "/run/ascend/driver", "dev-root": "/run/ascend/driver"}`))
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	if HostPath(cfg.GetDriverRoot(), "/usr/local/dcmi") != "/run/ascend/driver/usr/local/dcmi" ||
+		HostPath(cfg.GetDevRoot(), "/dev/davinci0") != "/run/ascend/driver/dev/davinci0" {
+		t.Fatalf("unexpected host path of config %v", cfg)
+	}
+}
+
+func TestGetDeviceRequest(t *testing.T) {
+	destinations := []string{"/var/run/ascend-container-devices/0", "/tmp", "/var/run/ascend-container-devices/3/"}
+	if res := GetMountedDeviceRequest(destinations); res != "0,3" {
+		t.Fatalf("unexpected request of mounts: %s", res)
+	}
+	envRequest := func() string {
+		return "1"
+	}
+	cfg := Default()
+	if res := cfg.GetDeviceRequest(destinations, envRequest); res != "1" {
+		t.Fatalf("mounts should be ignored by default: %s", res)
+	}
+	cfg.AcceptVolumeMounts = true
+	if res := cfg.GetDeviceRequest(destinations, envRequest); res != "0,3" {
+		t.Fatalf("mounts should take precedence: %s", res)
+	}
+	cfg.AcceptEnvvar = false
+	if res := cfg.GetDeviceRequest(nil, envRequest); res != "" {
+		t.Fatalf("envvar should be ignored: %s", res)
+	}
+}
@@ -0,0 +1,7 @@
+module ascendconfig
+
+go 1.17
+
+require mindxcheckutils v1.0.0
+
+replace mindxcheckutils => ../mindxcheckutils
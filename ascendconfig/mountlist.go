@@ -0,0 +1,140 @@
+/* Copyright(C) 2023. Huawei Technologies Co.,Ltd. All rights reserved.
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package ascendconfig
+package ascendconfig
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"mindxcheckutils"
+)
+
+const (
+	// MountConfigDir directory of the mount lists like base.list
+	MountConfigDir = "/etc/ascend-docker-runtime.d"
+	// BaseMountConfig name of the default mount list
+	BaseMountConfig   = "base"
+	mountConfigSuffix = "list"
+)
+
+// ParseMounts get the mount list names from ASCEND_RUNTIME_MOUNTS, base is used when it is empty
+func ParseMounts(mounts string) []string {
+	if mounts == "" {
+		return []string{BaseMountConfig}
+	}
+	const maxMountLength = 128
+	if len(mounts) > maxMountLength {
+		return []string{BaseMountConfig}
+	}
+
+	mountConfigs := make([]string, 0)
+	for _, m := range strings.Split(mounts, ",") {
+		m = strings.TrimSpace(m)
+		m = strings.ToLower(m)
+		mountConfigs = append(mountConfigs, m)
+	}
+
+	return mountConfigs
+}
+
+// ReadMountConfig read <dir>/<name>.list, the files and dirs in it existing under root are returned.
+// the returned paths are canonical, the source on the host is HostPath(root, path)
+func ReadMountConfig(dir string, name string, root string) ([]string, []string, error) {
+	configFileName := fmt.Sprintf("%s.%s", name, mountConfigSuffix)
+	baseConfigFilePath, err := filepath.Abs(filepath.Join(dir, configFileName))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to assemble base config file path: %v", err)
+	}
+
+	fileInfo, err := os.Stat(baseConfigFilePath)
+	if _, err := mindxcheckutils.RealHostFileChecker(baseConfigFilePath, true, false,
+		mindxcheckutils.DefaultSize); err != nil {
+		return nil, nil, err
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot stat base configuration file %s : %v", baseConfigFilePath, err)
+	}
+
+	if !fileInfo.Mode().IsRegular() {
+		return nil, nil, fmt.Errorf("base configuration file damaged because is not a regular file")
+	}
+
+	f, err := os.Open(baseConfigFilePath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open base configuration file %s: %v", baseConfigFilePath, err)
+	}
+	defer f.Close()
+
+	fileMountList, dirMountList := make([]string, 0), make([]string, 0)
+	const maxEntryNumber = 128
+	entryCount := 0
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		mountPath := scanner.Text()
+		entryCount = entryCount + 1
+		if entryCount > maxEntryNumber {
+			return nil, nil, fmt.Errorf("mount list too long")
+		}
+		absMountPath, err := filepath.Abs(mountPath)
+		if err != nil {
+			continue // skipping files/dirs with any problems
+		}
+		mountPath = absMountPath
+
+		stat, err := os.Stat(HostPath(root, mountPath))
+		if err != nil {
+			continue // skipping files/dirs with any problems
+		}
+
+		if stat.Mode().IsRegular() {
+			fileMountList = append(fileMountList, mountPath)
+		} else if stat.Mode().IsDir() {
+			dirMountList = append(dirMountList, mountPath)
+		}
+	}
+
+	return fileMountList, dirMountList, nil
+}
+
+// ReadMountConfigs read every mount list of configs in dir
+func ReadMountConfigs(dir string, configs []string, root string) ([]string, []string, error) {
+	fileInfo, err := os.Stat(dir)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot stat configuration directory %s : %v", dir, err)
+	}
+
+	if !fileInfo.Mode().IsDir() {
+		return nil, nil, fmt.Errorf("%s should be a dir for ascend docker runtime, but now it is not", dir)
+	}
+
+	fileMountList := make([]string, 0)
+	dirMountList := make([]string, 0)
+
+	for _, config := range configs {
+		fileList, dirList, err := ReadMountConfig(dir, config, root)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to process config %s: %v", config, err)
+		}
+
+		fileMountList = append(fileMountList, fileList...)
+		dirMountList = append(dirMountList, dirList...)
+	}
+
+	return fileMountList, dirMountList, nil
+}
@@ -0,0 +1,99 @@
+/* Copyright(C) 2023. Huawei Technologies Co.,Ltd. All rights reserved.
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package ascendconfig
+package ascendconfig
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"mindxcheckutils"
+)
+
+const (
+	// DefaultInstallInfoPath file written by the driver package, it records where the driver is installed
+	DefaultInstallInfoPath = "/etc/ascend_install.info"
+	// DriverInstallDir canonical install dir of the driver, the mount lists and the container use it
+	DriverInstallDir = "/usr/local/Ascend"
+	// DriverVersionInfo file written by the driver package, it records the driver version
+	DriverVersionInfo = DriverInstallDir + "/driver/version.info"
+
+	driverInstallPathKey = "Driver_Install_Path_Param"
+	rootDir              = "/"
+)
+
+var installInfoPath = DefaultInstallInfoPath
+
+// HostPath get the path on the host of a canonical path under root, like /run/ascend/driver/usr/local/dcmi
+func HostPath(root, canonicalPath string) string {
+	if root == "" {
+		root = rootDir
+	}
+	return filepath.Join(root, canonicalPath)
+}
+
+// GetDriverRoot get the root of the driver files on the host, / means the driver is installed on the host
+func (c *Config) GetDriverRoot() string {
+	if c.DriverRoot == "" {
+		return rootDir
+	}
+	return c.DriverRoot
+}
+
+// GetDevRoot get the root of the device nodes on the host, / means the device nodes are under /dev
+func (c *Config) GetDevRoot() string {
+	if c.DevRoot == "" {
+		return rootDir
+	}
+	return c.DevRoot
+}
+
+// discoverDriverRoot get the driver root from the install info file, empty means it is not found
+func discoverDriverRoot(infoPath string) string {
+	if _, err := os.Stat(infoPath); err != nil {
+		return ""
+	}
+	if _, err := mindxcheckutils.RealHostFileChecker(infoPath, true, false, mindxcheckutils.DefaultSize); err != nil {
+		return ""
+	}
+	content, err := ioutil.ReadFile(infoPath)
+	if err != nil {
+		return ""
+	}
+	return parseDriverRoot(content)
+}
+
+// parseDriverRoot get the driver root from the install path in the install info.
+// the install path should be <driver root>/usr/local/Ascend, otherwise the driver root is not changed
+func parseDriverRoot(content []byte) string {
+	for _, line := range strings.Split(string(content), "\n") {
+		words := strings.SplitN(strings.TrimSpace(line), "=", kvPairSize)
+		if len(words) != kvPairSize || strings.TrimSpace(words[0]) != driverInstallPathKey {
+			continue
+		}
+		installPath := filepath.Clean(strings.TrimSpace(words[1]))
+		if !filepath.IsAbs(installPath) || !strings.HasSuffix(installPath, DriverInstallDir) {
+			return ""
+		}
+		root := strings.TrimSuffix(installPath, DriverInstallDir)
+		if root == "" || checkAbsPath("driver-root", root) != nil {
+			return ""
+		}
+		return root
+	}
+	return ""
+}
@@ -224,12 +224,14 @@ function install()
     cp -f ./ascend-docker-cli ${INSTALL_PATH}/ascend-docker-cli
     cp -f ./ascend-docker-plugin-install-helper ${INSTALL_PATH}/ascend-docker-plugin-install-helper
     cp -f ./ascend-docker-destroy ${INSTALL_PATH}/ascend-docker-destroy
+    cp -f ./ascend-docker-nri-plugin ${INSTALL_PATH}/ascend-docker-nri-plugin
     cp -f ./README.md ${INSTALL_PATH}/README.md
     chmod 550 ${INSTALL_PATH}/ascend-docker-runtime
     chmod 550 ${INSTALL_PATH}/ascend-docker-hook
     chmod 550 ${INSTALL_PATH}/ascend-docker-cli
     chmod 550 ${INSTALL_PATH}/ascend-docker-plugin-install-helper
     chmod 550 ${INSTALL_PATH}/ascend-docker-destroy
+    chmod 550 ${INSTALL_PATH}/ascend-docker-nri-plugin
     chmod 640 ${INSTALL_PATH}/README.md
 
     cp -f ./assets/20230118566.png ${INSTALL_PATH}/assets/20230118566.png
@@ -354,12 +356,14 @@ function upgrade()
     cp -f ./ascend-docker-cli ${INSTALL_PATH}/ascend-docker-cli
     cp -f ./ascend-docker-plugin-install-helper ${INSTALL_PATH}/ascend-docker-plugin-install-helper
     cp -f ./ascend-docker-destroy ${INSTALL_PATH}/ascend-docker-destroy
+    cp -f ./ascend-docker-nri-plugin ${INSTALL_PATH}/ascend-docker-nri-plugin
     cp -f ./uninstall.sh ${INSTALL_PATH}/script/uninstall.sh
     chmod 550 ${INSTALL_PATH}/ascend-docker-runtime
     chmod 550 ${INSTALL_PATH}/ascend-docker-hook
     chmod 550 ${INSTALL_PATH}/ascend-docker-cli
     chmod 550 ${INSTALL_PATH}/ascend-docker-plugin-install-helper
     chmod 550 ${INSTALL_PATH}/ascend-docker-destroy
+    chmod 550 ${INSTALL_PATH}/ascend-docker-nri-plugin
     chmod 500 ${INSTALL_PATH}/script/uninstall.sh
 
     check_path ${ASCEND_RUNTIME_CONFIG_DIR}/base.list
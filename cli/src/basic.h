@@ -72,11 +72,14 @@ struct MountList {
 
 struct ParsedConfig {
     char rootfs[BUF_SIZE];
+    char driverRoot[BUF_SIZE];
     char containerNsPath[BUF_SIZE];
     char cgroupPath[BUF_SIZE];
     int  originNsFd;
     const struct MountList *files;
     const struct MountList *dirs;
+    char ldconfigPath[BUF_SIZE];
+    const struct MountList *ldDirs;
 };
 
 #endif
\ No newline at end of file
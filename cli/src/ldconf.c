@@ -0,0 +1,162 @@
+/*
+ * Copyright (c) Huawei Technologies Co., Ltd. 2020-2022. All rights reserved.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+#include "ldconf.h"
+
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+#include <errno.h>
+#include <fcntl.h>
+#include <limits.h>
+#include <unistd.h>
+#include <sys/stat.h>
+#include <sys/wait.h>
+#include "securec.h"
+
+#include "utils.h"
+#include "options.h"
+#include "logger.h"
+
+#define LD_CONF_DIR     "/etc/ld.so.conf.d"
+#define LD_CONF_FILE    "ascend-driver.conf"
+#define LD_CONF_MODE    0644
+
+// the path under rootfs should be resolved to the same path, otherwise a link of the image leads it out of rootfs
+static bool IsPathInRootfs(const char *rootfs, const char *subPath)
+{
+    char resolvedRootfs[PATH_MAX] = {0};
+    char resolvedPath[PATH_MAX] = {0};
+    char expectedPath[PATH_MAX] = {0};
+    char path[PATH_MAX] = {0};
+
+    if (sprintf_s(path, PATH_MAX, "%s%s", rootfs, subPath) < 0) {
+        return false;
+    }
+    if (realpath(rootfs, resolvedRootfs) == NULL) {
+        return false;
+    }
+    if (realpath(path, resolvedPath) == NULL) {
+        return errno == ENOENT;
+    }
+    if (sprintf_s(expectedPath, PATH_MAX, "%s%s", resolvedRootfs, subPath) < 0) {
+        return false;
+    }
+    return strcmp(resolvedPath, expectedPath) == 0;
+}
+
+static int WriteLdConf(const char *rootfs, const struct MountList *ldDirs)
+{
+    char confDir[BUF_SIZE] = {0};
+    char confPath[BUF_SIZE] = {0};
+
+    if (!IsPathInRootfs(rootfs, "/etc") || !IsPathInRootfs(rootfs, LD_CONF_DIR)) {
+        Logger("ld.so.conf.d of the container is a link, refuse to write it.", LEVEL_ERROR, SCREEN_YES);
+        return -1;
+    }
+    if (sprintf_s(confDir, BUF_SIZE, "%s%s", rootfs, LD_CONF_DIR) < 0 ||
+        sprintf_s(confPath, BUF_SIZE, "%s/%s", confDir, LD_CONF_FILE) < 0) {
+        Logger("failed to assemble ld conf path.", LEVEL_ERROR, SCREEN_YES);
+        return -1;
+    }
+    if (MakeDirWithParent(confDir, DEFAULT_DIR_MODE) < 0) {
+        Logger("failed to make ld.so.conf.d of the container.", LEVEL_ERROR, SCREEN_YES);
+        return -1;
+    }
+
+    int fd = open(confPath, O_WRONLY | O_CREAT | O_TRUNC | O_NOFOLLOW | O_CLOEXEC, LD_CONF_MODE);
+    if (fd < 0) {
+        char* str = FormatLogMessage("failed to open %s.", confPath);
+        Logger(str, LEVEL_ERROR, SCREEN_YES);
+        free(str);
+        return -1;
+    }
+    FILE *fp = fdopen(fd, "w");
+    if (fp == NULL) {
+        close(fd);
+        Logger("failed to open ld conf stream.", LEVEL_ERROR, SCREEN_YES);
+        return -1;
+    }
+    for (unsigned int i = 0; i < ldDirs->count; i++) {
+        if (fprintf(fp, "%s\n", (const char *)&ldDirs->list[i][0]) < 0) {
+            (void)fclose(fp);
+            Logger("failed to write ld conf.", LEVEL_ERROR, SCREEN_YES);
+            return -1;
+        }
+    }
+    if (fclose(fp) != 0) {
+        Logger("failed to close ld conf.", LEVEL_ERROR, SCREEN_YES);
+        return -1;
+    }
+    return 0;
+}
+
+static int RunLdconfig(const char *ldconfigPath, const char *rootfs)
+{
+    const size_t maxFileSzieMb = 50; // max 50MB
+    if (!CheckHostFile(ldconfigPath, strlen(ldconfigPath), maxFileSzieMb)) {
+        char* str = FormatLogMessage("failed to check ldconfig: %s.", ldconfigPath);
+        Logger(str, LEVEL_ERROR, SCREEN_YES);
+        free(str);
+        return -1;
+    }
+
+    pid_t pid = fork();
+    if (pid < 0) {
+        Logger("failed to fork ldconfig.", LEVEL_ERROR, SCREEN_YES);
+        return -1;
+    }
+    if (pid == 0) {
+        char *const envp[] = {NULL};
+        // ldconfig chroots into rootfs, so ld.so.conf and ld.so.cache of the container are used
+        (void)execle(ldconfigPath, ldconfigPath, "-r", rootfs, (char *)NULL, envp);
+        _exit(EXIT_FAILURE);
+    }
+
+    int status = 0;
+    while (waitpid(pid, &status, 0) < 0) {
+        if (errno != EINTR) {
+            Logger("failed to wait ldconfig.", LEVEL_ERROR, SCREEN_YES);
+            return -1;
+        }
+    }
+    if (!WIFEXITED(status) || WEXITSTATUS(status) != 0) {
+        Logger("ldconfig exited abnormally.", LEVEL_ERROR, SCREEN_YES);
+        return -1;
+    }
+    return 0;
+}
+
+int DoLdconfig(const struct ParsedConfig *config)
+{
+    if (config == NULL) {
+        Logger("config pointer is null!", LEVEL_ERROR, SCREEN_YES);
+        return -1;
+    }
+
+    if (IsOptionNoDrvSet() || config->ldDirs == NULL || config->ldDirs->count == 0 ||
+        strlen(config->ldconfigPath) == 0) {
+        return 0;
+    }
+
+    if (WriteLdConf(config->rootfs, config->ldDirs) < 0) {
+        Logger("failed to write ld conf of driver.", LEVEL_ERROR, SCREEN_YES);
+        return -1;
+    }
+    if (RunLdconfig(config->ldconfigPath, config->rootfs) < 0) {
+        Logger("failed to refresh ld.so.cache of the container.", LEVEL_ERROR, SCREEN_YES);
+        return -1;
+    }
+    return 0;
+}
@@ -28,6 +28,7 @@
 #include "basic.h"
 #include "ns.h"
 #include "u_mount.h"
+#include "ldconf.h"
 #include "cgrp.h"
 #include "options.h"
 #include "utils.h"
@@ -43,8 +44,11 @@ struct CmdArgs {
     char     rootfs[BUF_SIZE];
     long      pid;
     char     options[BUF_SIZE];
+    char     driverRoot[BUF_SIZE];
+    char     ldconfigPath[BUF_SIZE];
     struct MountList files;
     struct MountList dirs;
+    struct MountList ldDirs;
 };
 
 static struct option g_cmdOpts[] = {
@@ -55,6 +59,9 @@ static struct option g_cmdOpts[] = {
     {"options", required_argument, 0, 'o'},
     {"mount-file", required_argument, 0, 'f'},
     {"mount-dir", required_argument, 0, 'i'},
+    {"driver-root", required_argument, 0, 't'},
+    {"ldconfig", required_argument, 0, 'c'},
+    {"ld-conf-dir", required_argument, 0, 'b'},
     {0, 0, 0, 0}
 };
 
@@ -71,7 +78,7 @@ static bool PidCmdArgParser(struct CmdArgs *args, const char *arg)
     args->pid = strtol(arg, NULL, DECIMAL);
     const char* pidMax = "/proc/sys/kernel/pid_max";
     const size_t maxFileSzieMb = 10; // max 10MB
-    if (!CheckExternalFile(pidMax, strlen(pidMax), maxFileSzieMb, true)) {
+    if (!CheckHostFile(pidMax, strlen(pidMax), maxFileSzieMb)) {
         Logger("failed to check pid_max path.", LEVEL_ERROR, SCREEN_YES);
         return false;
     }
@@ -155,6 +162,90 @@ static bool RootfsCmdArgParser(struct CmdArgs *args, const char *arg)
     return true;
 }
 
+static bool DriverRootCmdArgParser(struct CmdArgs *args, const char *arg)
+{
+    if (args == NULL || arg == NULL) {
+        Logger("args, arg pointer is null!", LEVEL_ERROR, SCREEN_YES);
+        return false;
+    }
+
+    errno_t err = strcpy_s(args->driverRoot, BUF_SIZE, arg);
+    if (err != EOK) {
+        Logger("failed to get driver root from cmd args", LEVEL_ERROR, SCREEN_YES);
+        return false;
+    }
+    if (args->driverRoot[0] != '/') {
+        Logger("driver root should be an absolute path.", LEVEL_ERROR, SCREEN_YES);
+        return false;
+    }
+    const size_t maxFileSzieMb = 50; // max 50MB
+    if (!CheckFileLegality(args->driverRoot, strlen(args->driverRoot), maxFileSzieMb)) {
+        Logger("failed to check driver root.", LEVEL_ERROR, SCREEN_YES);
+        return false;
+    }
+
+    return true;
+}
+
+static bool LdconfigCmdArgParser(struct CmdArgs *args, const char *arg)
+{
+    if (args == NULL || arg == NULL) {
+        Logger("args, arg pointer is null!", LEVEL_ERROR, SCREEN_YES);
+        return false;
+    }
+
+    errno_t err = strcpy_s(args->ldconfigPath, BUF_SIZE, arg);
+    if (err != EOK) {
+        Logger("failed to get ldconfig path from cmd args", LEVEL_ERROR, SCREEN_YES);
+        return false;
+    }
+    if (args->ldconfigPath[0] != '/') {
+        Logger("ldconfig should be an absolute path.", LEVEL_ERROR, SCREEN_YES);
+        return false;
+    }
+    const size_t maxFileSzieMb = 50; // max 50MB
+    if (!CheckFileLegality(args->ldconfigPath, strlen(args->ldconfigPath), maxFileSzieMb)) {
+        Logger("failed to check ldconfig.", LEVEL_ERROR, SCREEN_YES);
+        return false;
+    }
+
+    return true;
+}
+
+static bool LdConfDirCmdArgParser(struct CmdArgs *args, const char *arg)
+{
+    if (args == NULL || arg == NULL) {
+        Logger("args, arg pointer is null!", LEVEL_ERROR, SCREEN_YES);
+        return false;
+    }
+
+    if (args->ldDirs.count >= MAX_MOUNT_NR) {
+        char* str = FormatLogMessage("too many ld conf directories, max number is %u", MAX_MOUNT_NR);
+        Logger(str, LEVEL_ERROR, SCREEN_YES);
+        free(str);
+        return false;
+    }
+
+    char *dst = &args->ldDirs.list[args->ldDirs.count++][0];
+    errno_t err = strcpy_s(dst, PATH_MAX, arg);
+    if (err != EOK) {
+        Logger("failed to copy ld conf directory from cmd args", LEVEL_ERROR, SCREEN_YES);
+        return false;
+    }
+    if (dst[0] != '/') {
+        Logger("ld conf directory should be an absolute path.", LEVEL_ERROR, SCREEN_YES);
+        return false;
+    }
+    for (size_t iLoop = 0; iLoop < strlen(dst); iLoop++) {
+        if (!IsValidChar(dst[iLoop])) {
+            Logger("ld conf directory has an illegal character!", LEVEL_ERROR, SCREEN_YES);
+            return false;
+        }
+    }
+
+    return true;
+}
+
 static bool OptionsCmdArgParser(struct CmdArgs *args, const char *arg)
 {
     if (args == NULL || arg == NULL) {
@@ -297,7 +388,7 @@ static bool LinkCheckCmdArgParser(const char *argv)
     return false;
 }
 
-#define NUM_OF_CMD_ARGS 6
+#define NUM_OF_CMD_ARGS 9
 
 static struct {
     const char c;
@@ -308,7 +399,10 @@ static struct {
     {'r', RootfsCmdArgParser},
     {'o', OptionsCmdArgParser},
     {'f', MountFileCmdArgParser},
-    {'i', MountDirCmdArgParser}
+    {'i', MountDirCmdArgParser},
+    {'t', DriverRootCmdArgParser},
+    {'c', LdconfigCmdArgParser},
+    {'b', LdConfDirCmdArgParser}
 };
 
 static int ParseOneCmdArg(struct CmdArgs *args, char indicator, const char *value)
@@ -366,6 +460,18 @@ int DoPrepare(const struct CmdArgs *args, struct ParsedConfig *config)
         return -1;
     }
 
+    err = strcpy_s(config->driverRoot, BUF_SIZE, args->driverRoot);
+    if (err != EOK) {
+        Logger("failed to copy driver root to parsed config.", LEVEL_ERROR, SCREEN_YES);
+        return -1;
+    }
+
+    err = strcpy_s(config->ldconfigPath, BUF_SIZE, args->ldconfigPath);
+    if (err != EOK) {
+        Logger("failed to copy ldconfig path to parsed config.", LEVEL_ERROR, SCREEN_YES);
+        return -1;
+    }
+
     ret = GetNsPath(args->pid, "mnt", config->containerNsPath, BUF_SIZE);
     if (ret < 0) {
         char* str = FormatLogMessage("failed to get container mnt ns path: pid(%d).", args->pid);
@@ -391,6 +497,7 @@ int DoPrepare(const struct CmdArgs *args, struct ParsedConfig *config)
 
     config->files = (const struct MountList *)&args->files;
     config->dirs  = (const struct MountList *)&args->dirs;
+    config->ldDirs = (const struct MountList *)&args->ldDirs;
 
     return 0;
 }
@@ -430,6 +537,13 @@ int SetupContainer(struct CmdArgs *args)
         return -1;
     }
 
+    // a read-only rootfs can not be written, the driver libraries are still found through LD_LIBRARY_PATH if exported
+    Logger("register driver libraries", LEVEL_INFO, SCREEN_YES);
+    ret = DoLdconfig(&config);
+    if (ret < 0) {
+        Logger("failed to register driver libraries by ldconfig.", LEVEL_WARN, SCREEN_YES);
+    }
+
     // back to original namespace
     Logger("back to original namespace", LEVEL_INFO, SCREEN_YES);
     ret = EnterNsByFd(config.originNsFd, CLONE_NEWNS);
@@ -458,7 +572,7 @@ int Process(int argc, char **argv)
     struct CmdArgs args = {0};
 
     Logger("runc start prestart-hook ...", LEVEL_INFO, SCREEN_YES);
-    while ((c = getopt_long(argc, argv, "l:p:r:o:f:i", g_cmdOpts, NULL)) != -1) {
+    while ((c = getopt_long(argc, argv, "l:p:r:o:f:i:t:c:b:", g_cmdOpts, NULL)) != -1) {
         ret = ParseOneCmdArg(&args, (char)c, optarg);
         if (ret < 0) {
             Logger("failed to parse cmd args.", LEVEL_ERROR, SCREEN_YES);
@@ -19,6 +19,7 @@
 #include <limits.h>
 #include <sys/stat.h>
 #include <sys/mount.h>
+#include <sys/statvfs.h>
 #include "securec.h"
 
 #include "basic.h"
@@ -51,6 +52,32 @@ static bool checkSrcFile(const char *src)
     return true;
 }
 
+// a bind mount in a user namespace keeps the locked flags of its source, a remount dropping them is refused
+static unsigned long GetLockedMountFlags(const char *path)
+{
+    struct statvfs fsStat;
+    if (statvfs(path, &fsStat) != 0) {
+        return 0;
+    }
+    static const struct {
+        unsigned long statFlag;
+        unsigned long mountFlag;
+    } flagPairs[] = {
+        {ST_NODEV, MS_NODEV},
+        {ST_NOEXEC, MS_NOEXEC},
+        {ST_NOATIME, MS_NOATIME},
+        {ST_NODIRATIME, MS_NODIRATIME},
+        {ST_RELATIME, MS_RELATIME},
+    };
+    unsigned long flags = 0;
+    for (size_t i = 0; i < sizeof(flagPairs) / sizeof(flagPairs[0]); i++) {
+        if ((fsStat.f_flag & flagPairs[i].statFlag) != 0) {
+            flags |= flagPairs[i].mountFlag;
+        }
+    }
+    return flags;
+}
+
 int Mount(const char *src, const char *dst)
 {
     if (src == NULL || dst == NULL) {
@@ -69,7 +96,7 @@ int Mount(const char *src, const char *dst)
         return -1;
     }
 
-    ret = mount(NULL, dst, NULL, remountFlags, NULL);
+    ret = mount(NULL, dst, NULL, remountFlags | GetLockedMountFlags(dst), NULL);
     if (ret < 0) {
         Logger("failed to re-mount. dst.", LEVEL_ERROR, SCREEN_YES);
         return -1;
@@ -78,14 +105,26 @@ int Mount(const char *src, const char *dst)
     return 0;
 }
 
-int MountFile(const char *rootfs, const char *filepath)
+static int GetMountSrc(const char *driverRoot, const char *path, char *src, size_t srcLen)
+{
+    // the mount lists keep the canonical paths, the sources are under the driver root on the host
+    int ret = sprintf_s(src, srcLen, "%s%s", driverRoot, path);
+    if (ret < 0) {
+        Logger("failed to assemble mounting src path.", LEVEL_ERROR, SCREEN_YES);
+        return -1;
+    }
+    return 0;
+}
+
+int MountFile(const char *rootfs, const char *driverRoot, const char *filepath)
 {
-    if (rootfs == NULL || filepath == NULL) {
-        Logger("rootfs, filepath pointer is null!", LEVEL_ERROR, SCREEN_YES);
+    if (rootfs == NULL || driverRoot == NULL || filepath == NULL) {
+        Logger("rootfs, driverRoot, filepath pointer is null!", LEVEL_ERROR, SCREEN_YES);
         return -1;
     }
 
     int ret;
+    char src[BUF_SIZE] = {0};
     char dst[BUF_SIZE] = {0};
 
     ret = sprintf_s(dst, BUF_SIZE, "%s%s", rootfs, filepath);
@@ -93,9 +132,12 @@ int MountFile(const char *rootfs, const char *filepath)
         Logger("failed to assemble file mounting path.", LEVEL_ERROR, SCREEN_YES);
         return -1;
     }
+    if (GetMountSrc(driverRoot, filepath, src, BUF_SIZE) < 0) {
+        return -1;
+    }
 
     struct stat srcStat;
-    ret = stat(filepath, &srcStat);
+    ret = stat(src, &srcStat);
     if (ret < 0) {
         return 0;
     }
@@ -106,7 +148,7 @@ int MountFile(const char *rootfs, const char *filepath)
         return -1;
     }
 
-    ret = Mount(filepath, dst);
+    ret = Mount(src, dst);
     if (ret < 0) {
         Logger("failed to mount dev.", LEVEL_ERROR, SCREEN_YES);
         return -1;
@@ -115,20 +157,24 @@ int MountFile(const char *rootfs, const char *filepath)
     return 0;
 }
 
-int MountDir(const char *rootfs, const char *src)
+int MountDir(const char *rootfs, const char *driverRoot, const char *dirpath)
 {
-    if (rootfs == NULL || src == NULL) {
-        Logger("rootfs, src pointer is null!", LEVEL_ERROR, SCREEN_YES);
+    if (rootfs == NULL || driverRoot == NULL || dirpath == NULL) {
+        Logger("rootfs, driverRoot, dirpath pointer is null!", LEVEL_ERROR, SCREEN_YES);
         return -1;
     }
 
     int ret;
+    char src[BUF_SIZE] = {0};
     char dst[BUF_SIZE] = {0};
 
-    ret = sprintf_s(dst, BUF_SIZE, "%s%s", rootfs, src);
+    ret = sprintf_s(dst, BUF_SIZE, "%s%s", rootfs, dirpath);
     if (ret < 0) {
         return -1;
     }
+    if (GetMountSrc(driverRoot, dirpath, src, BUF_SIZE) < 0) {
+        return -1;
+    }
 
     struct stat srcStat;
     ret = stat(src, &srcStat);
@@ -151,16 +197,16 @@ int MountDir(const char *rootfs, const char *src)
     return 0;
 }
 
-int DoDirectoryMounting(const char *rootfs, const struct MountList *list)
+int DoDirectoryMounting(const char *rootfs, const char *driverRoot, const struct MountList *list)
 {
-    if (rootfs == NULL || list == NULL) {
-        Logger("rootfs, list pointer is null!", LEVEL_ERROR, SCREEN_YES);
+    if (rootfs == NULL || driverRoot == NULL || list == NULL) {
+        Logger("rootfs, driverRoot, list pointer is null!", LEVEL_ERROR, SCREEN_YES);
         return -1;
     }
 
     int ret;
     for (unsigned int i = 0; i < list->count; i++) {
-        ret = MountDir(rootfs, (const char *)&list->list[i][0]);
+        ret = MountDir(rootfs, driverRoot, (const char *)&list->list[i][0]);
         if (ret < 0) {
             Logger("failed to do directory mounting", LEVEL_ERROR, SCREEN_YES);
             return -1;
@@ -170,16 +216,16 @@ int DoDirectoryMounting(const char *rootfs, const struct MountList *list)
     return 0;
 }
 
-int DoFileMounting(const char *rootfs, const struct MountList *list)
+int DoFileMounting(const char *rootfs, const char *driverRoot, const struct MountList *list)
 {
-    if (rootfs == NULL || list == NULL) {
-        Logger("rootfs, list pointer is null!", LEVEL_ERROR, SCREEN_YES);
+    if (rootfs == NULL || driverRoot == NULL || list == NULL) {
+        Logger("rootfs, driverRoot, list pointer is null!", LEVEL_ERROR, SCREEN_YES);
         return -1;
     }
 
     int ret;
     for (unsigned int i = 0; i < list->count; i++) {
-        ret = MountFile(rootfs, (const char *)&list->list[i][0]);
+        ret = MountFile(rootfs, driverRoot, (const char *)&list->list[i][0]);
         if (ret < 0) {
             Logger("failed to do file mounting for.", LEVEL_ERROR, SCREEN_YES);
             return -1;
@@ -202,13 +248,13 @@ int DoMounting(const struct ParsedConfig *config)
         return 0;
     }
 
-    ret = DoFileMounting(config->rootfs, config->files);
+    ret = DoFileMounting(config->rootfs, config->driverRoot, config->files);
     if (ret < 0) {
         Logger("failed to mount files.", LEVEL_ERROR, SCREEN_YES);
         return -1;
     }
 
-    ret = DoDirectoryMounting(config->rootfs, config->dirs);
+    ret = DoDirectoryMounting(config->rootfs, config->driverRoot, config->dirs);
     if (ret < 0) {
         Logger("failed to do mount directories.", LEVEL_ERROR, SCREEN_YES);
         return -1;
@@ -28,8 +28,12 @@
 #include "logger.h"
 
 #define LOG_LENGTH 1024
+#define UID_MAP_PATH "/proc/self/uid_map"
+#define OVERFLOW_UID_PATH "/proc/sys/kernel/overflowuid"
+#define FULL_UID_RANGE 4294967295UL
 
 static bool g_checkWgroup = true;
+static bool g_allowOverflowOwner = false;
 bool g_allowLink;
 
 char *FormatLogMessage(char *format, ...)
@@ -200,10 +204,66 @@ static bool ShowExceptionInfo(const char* exceptionInfo)
     return false;
 }
 
-static bool CheckFileOwner(const struct stat fileStat, const bool checkOwner)
+static unsigned long ReadFirstNumbers(const char *path, unsigned long *numbers, int count)
+{
+    FILE *fp = fopen(path, "r"); // proc接口，非外部输入
+    if (fp == NULL) {
+        return 0;
+    }
+    int got = 0;
+    while (got < count && fscanf(fp, "%lu", &numbers[got]) == 1) {
+        got++;
+    }
+    (void)fclose(fp);
+    return (unsigned long)got;
+}
+
+// 在user namespace中（如rootless容器引擎），未映射的宿主机用户（包括root）的文件显示为overflow uid
+static bool IsOverflowUid(uid_t uid)
+{
+    static bool initialized = false;
+    static bool inUserNs = false;
+    static unsigned long overflowUid = 0;
+    if (!initialized) {
+        initialized = true;
+        unsigned long uidMap[3] = {0}; // inside id, outside id, count
+        // the initial user namespace maps the full uid range
+        if (ReadFirstNumbers(UID_MAP_PATH, uidMap, 3) == 3) {
+            inUserNs = !(uidMap[0] == 0 && uidMap[1] == 0 && uidMap[2] == FULL_UID_RANGE);
+        }
+        (void)ReadFirstNumbers(OVERFLOW_UID_PATH, &overflowUid, 1);
+    }
+    return inUserNs && overflowUid != 0 && (unsigned long)uid == overflowUid;
+}
+
+// 未映射的普通用户的文件同样显示为overflow uid，仅宿主机上只有root可写的目录及其上级目录允许属主为overflow uid
+static bool IsHostPath(const char* path)
+{
+    static const char* hostRoots[] = {"/etc", "/usr", "/bin", "/sbin", "/lib", "/lib64", "/opt", "/proc/sys"};
+    if (strcmp(path, "/") == 0) {
+        return true;
+    }
+    size_t pathLen = strlen(path);
+    for (size_t iLoop = 0; iLoop < sizeof(hostRoots) / sizeof(hostRoots[0]); iLoop++) {
+        size_t rootLen = strlen(hostRoots[iLoop]);
+        // 位于root之下
+        if ((strncmp(path, hostRoots[iLoop], rootLen) == 0) && ((path[rootLen] == '\0') || (path[rootLen] == '/'))) {
+            return true;
+        }
+        // root的上级目录
+        if ((pathLen < rootLen) && (strncmp(hostRoots[iLoop], path, pathLen) == 0) &&
+            (hostRoots[iLoop][pathLen] == '/')) {
+            return true;
+        }
+    }
+    return false;
+}
+
+static bool CheckFileOwner(const struct stat fileStat, const bool checkOwner, const char* path)
 {
     if (checkOwner) {
-        if ((fileStat.st_uid != ROOT_UID) && (fileStat.st_uid != geteuid())) { // 操作文件owner非root/自己
+        if ((fileStat.st_uid != ROOT_UID) && (fileStat.st_uid != geteuid()) &&
+            !(g_allowOverflowOwner && IsOverflowUid(fileStat.st_uid) && IsHostPath(path))) { // 操作文件owner非root/自己
             return ShowExceptionInfo("Please check the folder owner!");
         }
     }
@@ -218,7 +278,7 @@ static bool CheckParentDir(const char* filePath, const size_t filePathLen,
         return false;
     }
     for (int iLoop = 0; iLoop < PATH_MAX; iLoop++) {
-        if (!CheckFileOwner(fileStat, checkOwner)) {
+        if (!CheckFileOwner(fileStat, checkOwner, buf)) {
             return false;
         }
         if ((fileStat.st_mode & S_IWOTH) != 0) { // 操作文件对other用户可写
@@ -290,6 +350,15 @@ bool CheckExternalFile(const char* filePath, const size_t filePathLen,
     return CheckLegality(filePath, filePathLen, maxFileSzieMb, checkOwner);
 }
 
+// 校验宿主机提供的系统文件，仅此类文件在user namespace中允许属主为overflow uid
+bool CheckHostFile(const char* filePath, const size_t filePathLen, const size_t maxFileSzieMb)
+{
+    g_allowOverflowOwner = true;
+    bool ret = CheckExternalFile(filePath, filePathLen, maxFileSzieMb, true);
+    g_allowOverflowOwner = false;
+    return ret;
+}
+
 bool CheckExistsFile(const char* filePath, const size_t filePathLen,
     const size_t maxFileSzieMb, const bool checkWgroup)
 {
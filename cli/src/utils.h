@@ -34,6 +34,7 @@ int MakeMountPoints(const char *path, mode_t mode);
 bool IsValidChar(const char c);
 bool CheckExternalFile(const char* filePath, const size_t filePathLen,
     const size_t maxFileSzieMb, const bool checkOwner);
+bool CheckHostFile(const char* filePath, const size_t filePathLen, const size_t maxFileSzieMb);
 bool GetFileSubsetAndCheck(const char *basePath, const size_t basePathLen);
 bool CheckExistsFile(const char* filePath, const size_t filePathLen,
     const size_t maxFileSzieMb, const bool checkWgroup);
@@ -3,6 +3,7 @@ module main
 go 1.18
 
 require (
+	ascendconfig v1.0.0
 	github.com/opencontainers/runtime-spec v1.0.3-0.20220718201635-a8106e99982b
 	github.com/prashantv/gostub v1.1.0
 	huawei.com/npu-exporter/v5 v5.0.0-RC1
@@ -15,6 +16,7 @@ require (
 )
 
 replace (
+	ascendconfig => ../ascendconfig
 	huawei.com/npu-exporter/v5 => gitee.com/ascend/ascend-npu-exporter/v5 v5.0.0-RC4.b002
 	mindxcheckutils => ../mindxcheckutils
 )
@@ -16,12 +16,12 @@
 package main
 
 import (
-	"bufio"
 	"context"
 	"encoding/json"
 	"fmt"
 	"log"
 	"os"
+	"os/exec"
 	"path"
 	"path/filepath"
 	"strings"
@@ -30,21 +30,27 @@ import (
 	"github.com/opencontainers/runtime-spec/specs-go"
 	"huawei.com/npu-exporter/v5/common-utils/hwlog"
 
+	"ascendconfig"
 	"mindxcheckutils"
 )
 
 const (
 	loggingPrefix          = "ascend-docker-hook"
-	runLogPath             = "/var/log/ascend-docker-runtime/hook-run.log"
+	runLogName             = "hook-run.log"
+	runLogPrefix           = "hook-run-"
 	ascendRuntimeOptions   = "ASCEND_RUNTIME_OPTIONS"
 	ascendRuntimeMounts    = "ASCEND_RUNTIME_MOUNTS"
 	ascendVisibleDevices   = "ASCEND_VISIBLE_DEVICES"
 	ascendAllowLink        = "ASCEND_ALLOW_LINK"
 	ascendDockerCli        = "ascend-docker-cli"
 	defaultAscendDockerCli = "/usr/local/bin/ascend-docker-cli"
-	configDir              = "/etc/ascend-docker-runtime.d"
-	baseConfig             = "base"
-	configFileSuffix       = "list"
+	visibleDevicesVoid     = "void"
+	ascendDockerRuntime    = "ascend-docker-runtime"
+
+	// annotations equivalent to the ENV above, they take precedence over ENV
+	ascendVisibleDevicesAnnotation = "huawei.com/ascend.visible-devices"
+	ascendRuntimeOptionsAnnotation = "huawei.com/ascend.runtime-options"
+	ascendRuntimeMountsAnnotation  = "huawei.com/ascend.runtime-mounts"
 
 	kvPairSize       = 2
 	maxCommandLength = 65535
@@ -55,25 +61,41 @@ var (
 	doExec                     = syscall.Exec
 	ascendDockerCliName        = ascendDockerCli
 	defaultAscendDockerCliName = defaultAscendDockerCli
+	hookConfigFile             = ascendconfig.DefaultConfigPath
+	hookCfg                    = ascendconfig.Default()
+	hookOnly                   = false
+	runCommand                 = func(name string, args ...string) ([]byte, error) {
+		return exec.Command(name, args...).CombinedOutput()
+	}
 )
 
+var envAnnotations = map[string]string{
+	ascendVisibleDevices: ascendVisibleDevicesAnnotation,
+	ascendRuntimeOptions: ascendRuntimeOptionsAnnotation,
+	ascendRuntimeMounts:  ascendRuntimeMountsAnnotation,
+}
+
 var validRuntimeOptions = [...]string{
 	"NODRV",
 	"VIRTUAL",
 }
 
 type containerConfig struct {
-	Pid    int
-	Rootfs string
-	Env    []string
+	Pid         int
+	Bundle      string
+	Rootfs      string
+	Env         []string
+	Annotations map[string]string
+	// Mounts the destinations of the mounts of the container
+	Mounts []string
 }
 
 func initLogModule(ctx context.Context) error {
 	const backups = 2
 	const logMaxAge = 365
 	runLogConfig := hwlog.LogConfig{
-		LogFileName: runLogPath,
-		LogLevel:    0,
+		LogFileName: hookCfg.LogFile(runLogName),
+		LogLevel:    hookCfg.LogLevel,
 		MaxBackups:  backups,
 		MaxAge:      logMaxAge,
 		OnlyToFile:  true,
@@ -86,25 +108,6 @@ func initLogModule(ctx context.Context) error {
 	return nil
 }
 
-func parseMounts(mounts string) []string {
-	if mounts == "" {
-		return []string{baseConfig}
-	}
-	const maxMountLength = 128
-	if len(mounts) > maxMountLength {
-		return []string{baseConfig}
-	}
-
-	mountConfigs := make([]string, 0)
-	for _, m := range strings.Split(mounts, ",") {
-		m = strings.TrimSpace(m)
-		m = strings.ToLower(m)
-		mountConfigs = append(mountConfigs, m)
-	}
-
-	return mountConfigs
-}
-
 func isRuntimeOptionValid(option string) bool {
 	for _, validOption := range validRuntimeOptions {
 		if option == validOption {
@@ -181,7 +184,7 @@ var getContainerConfig = func() (*containerConfig, error) {
 	}
 
 	configPath := path.Join(state.Bundle, "config.json")
-	if _, err := mindxcheckutils.RealFileChecker(configPath, true, true, mindxcheckutils.DefaultSize); err != nil {
+	if _, err := mindxcheckutils.RealHostFileChecker(configPath, true, true, mindxcheckutils.DefaultSize); err != nil {
 		return nil, err
 	}
 
@@ -199,9 +202,15 @@ var getContainerConfig = func() (*containerConfig, error) {
 	}
 
 	ret := &containerConfig{
-		Pid:    state.Pid,
-		Rootfs: rfs,
-		Env:    ociSpec.Process.Env,
+		Pid:         state.Pid,
+		Bundle:      state.Bundle,
+		Rootfs:      rfs,
+		Env:         ociSpec.Process.Env,
+		Annotations: ociSpec.Annotations,
+		Mounts:      make([]string, 0, len(ociSpec.Mounts)),
+	}
+	for _, mount := range ociSpec.Mounts {
+		ret.Mounts = append(ret.Mounts, mount.Destination)
 	}
 
 	return ret, nil
@@ -222,87 +231,20 @@ func getValueByKey(data []string, name string) string {
 	return ""
 }
 
-func readMountConfig(dir string, name string) ([]string, []string, error) {
-	configFileName := fmt.Sprintf("%s.%s", name, configFileSuffix)
-	baseConfigFilePath, err := filepath.Abs(filepath.Join(dir, configFileName))
-	if err != nil {
-		return nil, nil, fmt.Errorf("failed to assemble base config file path: %v", err)
-	}
-
-	fileInfo, err := os.Stat(baseConfigFilePath)
-	if _, err := mindxcheckutils.RealFileChecker(baseConfigFilePath, true, false,
-		mindxcheckutils.DefaultSize); err != nil {
-		return nil, nil, err
-	}
-	if err != nil {
-		return nil, nil, fmt.Errorf("cannot stat base configuration file %s : %v", baseConfigFilePath, err)
+// getConfigValue get the value of an ascend ENV, the equivalent annotation takes precedence over ENV
+func getConfigValue(containerConfig *containerConfig, name string) string {
+	if value, ok := containerConfig.Annotations[envAnnotations[name]]; ok {
+		return value
 	}
-
-	if !fileInfo.Mode().IsRegular() {
-		return nil, nil, fmt.Errorf("base configuration file damaged because is not a regular file")
-	}
-
-	f, err := os.Open(baseConfigFilePath)
-	if err != nil {
-		return nil, nil, fmt.Errorf("failed to open base configuration file %s: %v", baseConfigFilePath, err)
-	}
-	defer f.Close()
-
-	fileMountList, dirMountList := make([]string, 0), make([]string, 0)
-	const maxEntryNumber = 128
-	entryCount := 0
-	scanner := bufio.NewScanner(f)
-	for scanner.Scan() {
-		mountPath := scanner.Text()
-		entryCount = entryCount + 1
-		if entryCount > maxEntryNumber {
-			return nil, nil, fmt.Errorf("mount list too long")
-		}
-		absMountPath, err := filepath.Abs(mountPath)
-		if err != nil {
-			continue // skipping files/dirs with any problems
-		}
-		mountPath = absMountPath
-
-		stat, err := os.Stat(mountPath)
-		if err != nil {
-			continue // skipping files/dirs with any problems
-		}
-
-		if stat.Mode().IsRegular() {
-			fileMountList = append(fileMountList, mountPath)
-		} else if stat.Mode().IsDir() {
-			dirMountList = append(dirMountList, mountPath)
-		}
-	}
-
-	return fileMountList, dirMountList, nil
+	return getValueByKey(containerConfig.Env, name)
 }
 
-func readConfigsOfDir(dir string, configs []string) ([]string, []string, error) {
-	fileInfo, err := os.Stat(dir)
-	if err != nil {
-		return nil, nil, fmt.Errorf("cannot stat configuration directory %s : %v", dir, err)
-	}
-
-	if !fileInfo.Mode().IsDir() {
-		return nil, nil, fmt.Errorf("%s should be a dir for ascend docker runtime, but now it is not", dir)
-	}
-
-	fileMountList := make([]string, 0)
-	dirMountList := make([]string, 0)
-
-	for _, config := range configs {
-		fileList, dirList, err := readMountConfig(dir, config)
-		if err != nil {
-			return nil, nil, fmt.Errorf("failed to process config %s: %v", config, err)
-		}
-
-		fileMountList = append(fileMountList, fileList...)
-		dirMountList = append(dirMountList, dirList...)
-	}
-
-	return fileMountList, dirMountList, nil
+// getDeviceRequest get the device request by the rules of ascend-docker-runtime, the sources not accepted by the
+// config are ignored, as the hook of hook-only mode reads the spec not modified by ascend-docker-runtime
+func getDeviceRequest(containerConfig *containerConfig) string {
+	return hookCfg.GetDeviceRequest(containerConfig.Mounts, func() string {
+		return getConfigValue(containerConfig, ascendVisibleDevices)
+	})
 }
 
 func getArgs(cliPath string, containerConfig *containerConfig, fileMountList []string,
@@ -310,33 +252,73 @@ func getArgs(cliPath string, containerConfig *containerConfig, fileMountList []s
 	args := append([]string{cliPath},
 		"--allow-link", allowLink, "--pid", fmt.Sprintf("%d", containerConfig.Pid),
 		"--rootfs", containerConfig.Rootfs)
+	// the mount lists keep the canonical paths, ascend-docker-cli mounts them from the driver root
+	if driverRoot := hookCfg.GetDriverRoot(); driverRoot != "/" {
+		args = append(args, "--driver-root", driverRoot)
+	}
 	for _, filePath := range fileMountList {
 		args = append(args, "--mount-file", filePath)
 	}
 	for _, dirPath := range dirMountList {
 		args = append(args, "--mount-dir", dirPath)
 	}
+	return append(args, getLdconfigArgs()...)
+}
+
+// getLdconfigArgs get the args registering the driver libraries in ld.so.cache of the container
+func getLdconfigArgs() []string {
+	if hookCfg.LdconfigPath == "" || hookCfg.LdLibraryPath == "" {
+		return nil
+	}
+	// ascend-docker-cli refuses links, and /sbin is a link to /usr/sbin on many distributions
+	ldconfigPath, err := filepath.EvalSymlinks(hookCfg.LdconfigPath)
+	if err != nil {
+		hwlog.RunLog.Warnf("cannot find ldconfig %s, the driver libraries are not registered: %v",
+			hookCfg.LdconfigPath, err)
+		return nil
+	}
+	args := []string{"--ldconfig", ldconfigPath}
+	for _, libPath := range strings.Split(hookCfg.LdLibraryPath, ":") {
+		args = append(args, "--ld-conf-dir", libPath)
+	}
 	return args
 }
 
+// injectDevices let ascend-docker-runtime next to the hook create the device nodes and allow them in the device
+// cgroup, which it would have added to the spec if the container were created by it
+func injectDevices(hookDir string, containerConfig *containerConfig) error {
+	runtimePath := path.Join(hookDir, ascendDockerRuntime)
+	if _, err := mindxcheckutils.RealHostFileChecker(runtimePath, true, false, mindxcheckutils.DefaultSize); err != nil {
+		return err
+	}
+	output, err := runCommand(runtimePath, "hooks", "inject", "--pid", fmt.Sprintf("%d", containerConfig.Pid),
+		"--bundle", containerConfig.Bundle)
+	if err != nil {
+		return fmt.Errorf("failed to inject devices by %s: %v %s", runtimePath, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
 func doPrestartHook() error {
 	containerConfig, err := getContainerConfig()
 	if err != nil {
 		return fmt.Errorf("failed to get container config: %#v", err)
 	}
 
-	if visibleDevices := getValueByKey(containerConfig.Env, ascendVisibleDevices); visibleDevices == "" {
+	visibleDevices := strings.TrimSpace(getDeviceRequest(containerConfig))
+	if visibleDevices == "" || visibleDevices == visibleDevicesVoid {
 		return nil
 	}
 
-	mountConfigs := parseMounts(getValueByKey(containerConfig.Env, ascendRuntimeMounts))
+	mountConfigs := ascendconfig.ParseMounts(getConfigValue(containerConfig, ascendRuntimeMounts))
 
-	fileMountList, dirMountList, err := readConfigsOfDir(configDir, mountConfigs)
+	fileMountList, dirMountList, err := ascendconfig.ReadMountConfigs(ascendconfig.MountConfigDir, mountConfigs,
+		hookCfg.GetDriverRoot())
 	if err != nil {
 		return fmt.Errorf("failed to read configuration from config directory: %#v", err)
 	}
 
-	parsedOptions, err := parseRuntimeOptions(getValueByKey(containerConfig.Env, ascendRuntimeOptions))
+	parsedOptions, err := parseRuntimeOptions(getConfigValue(containerConfig, ascendRuntimeOptions))
 	if err != nil {
 		return fmt.Errorf("failed to parse runtime options: %#v", err)
 	}
@@ -350,12 +332,23 @@ func doPrestartHook() error {
 	if err != nil {
 		return fmt.Errorf("cannot get the path of ascend-docker-hook: %#v", err)
 	}
+	// the programs next to the hook are installed with it by root
+	mindxcheckutils.AddHostRoot(path.Dir(currentExecPath))
+
+	if hookOnly {
+		if err = injectDevices(path.Dir(currentExecPath), containerConfig); err != nil {
+			return err
+		}
+	}
 
 	cliPath := path.Join(path.Dir(currentExecPath), ascendDockerCliName)
+	if hookCfg.CliPath != "" {
+		cliPath = hookCfg.CliPath
+	}
 	if _, err = os.Stat(cliPath); err != nil {
 		return fmt.Errorf("cannot find ascend-docker-cli executable file at %s: %#v", cliPath, err)
 	}
-	if _, err := mindxcheckutils.RealFileChecker(cliPath, true, false, mindxcheckutils.DefaultSize); err != nil {
+	if _, err := mindxcheckutils.RealHostFileChecker(cliPath, true, false, mindxcheckutils.DefaultSize); err != nil {
 		return err
 	}
 	args := getArgs(cliPath, containerConfig, fileMountList, dirMountList, allowLink)
@@ -363,7 +356,7 @@ func doPrestartHook() error {
 		args = append(args, "--options", strings.Join(parsedOptions, ","))
 	}
 	hwlog.RunLog.Info("ascend docker hook success, will start cli")
-	if err := mindxcheckutils.ChangeRuntimeLogMode("hook-run-"); err != nil {
+	if err := mindxcheckutils.ChangeLogModeInDir(hookCfg.LogDir, runLogPrefix); err != nil {
 		return err
 	}
 	if err := doExec(cliPath, args, os.Environ()); err != nil {
@@ -380,6 +373,11 @@ func main() {
 	}()
 	log.SetPrefix(loggingPrefix)
 
+	cfg, err := ascendconfig.Load(hookConfigFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	hookCfg = cfg
 	ctx, _ := context.WithCancel(context.Background())
 	if err := initLogModule(ctx); err != nil {
 		log.Fatal(err)
@@ -389,7 +387,7 @@ func main() {
 		log.Fatal(err)
 	}
 	defer func() {
-		if err := mindxcheckutils.ChangeRuntimeLogMode("hook-run-"); err != nil {
+		if err := mindxcheckutils.ChangeLogModeInDir(hookCfg.LogDir, runLogPrefix); err != nil {
 			fmt.Println("defer changeFileMode function failed")
 		}
 	}()
@@ -399,6 +397,7 @@ func main() {
 		hwlog.RunLog.Errorf("%v ascend docker hook failed", logPrefixWords)
 		log.Fatal("command error")
 	}
+	hookOnly = len(os.Args) > 1 && os.Args[1] == ascendconfig.HookOnlyOption
 	if err := doPrestartHook(); err != nil {
 		hwlog.RunLog.Errorf("%v ascend docker hook failed: %#v", logPrefixWords, err)
 		log.Fatal(fmt.Errorf("failed in runtime.doProcess: %#v", err))
@@ -16,10 +16,15 @@
 package main
 
 import (
+	"fmt"
 	"github.com/prashantv/gostub"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"strings"
 	"testing"
+
+	"ascendconfig"
 )
 
 const (
@@ -190,3 +195,152 @@ func TestGetContainerConfig(t *testing.T) {
 
 	getContainerConfig()
 }
+
+func TestGetConfigValue(t *testing.T) {
+	conCfg := containerConfig{
+		Env: []string{"ASCEND_VISIBLE_DEVICES=0-3", "ASCEND_RUNTIME_MOUNTS=base"},
+		Annotations: map[string]string{
+			"huawei.com/ascend.visible-devices": "1",
+			"huawei.com/ascend.runtime-options": "NODRV",
+		},
+	}
+	if getConfigValue(&conCfg, ascendVisibleDevices) != "1" {
+		t.Fail()
+	}
+	if getConfigValue(&conCfg, ascendRuntimeOptions) != "NODRV" {
+		t.Fail()
+	}
+	if getConfigValue(&conCfg, ascendRuntimeMounts) != "base" {
+		t.Fail()
+	}
+}
+
+func TestDoPrestartHookVoid(t *testing.T) {
+	conCfg := containerConfig{
+		Pid:    pidSample,
+		Rootfs: ".",
+		Env:    []string{"ASCEND_VISIBLE_DEVICES=void"},
+	}
+	stub := gostub.StubFunc(&getContainerConfig, &conCfg, nil)
+	defer stub.Reset()
+	execCalled := false
+	stub.Stub(&doExec, func(string, []string, []string) error {
+		execCalled = true
+		return nil
+	})
+	if err := doPrestartHook(); err != nil || execCalled {
+		t.Fail()
+	}
+}
+
+func TestDoPrestartHookAcceptEnvvar(t *testing.T) {
+	conCfg := containerConfig{
+		Pid:         pidSample,
+		Rootfs:      ".",
+		Env:         []string{"ASCEND_VISIBLE_DEVICES=0"},
+		Annotations: map[string]string{"huawei.com/ascend.visible-devices": "1"},
+	}
+	cfg := ascendconfig.Default()
+	cfg.AcceptEnvvar = false
+	stub := gostub.StubFunc(&getContainerConfig, &conCfg, nil)
+	defer stub.Reset()
+	stub.Stub(&hookCfg, cfg)
+	stub.Stub(&hookOnly, true)
+	injected, execCalled := false, false
+	stub.Stub(&runCommand, func(name string, args ...string) ([]byte, error) {
+		injected = true
+		return nil, nil
+	})
+	stub.Stub(&doExec, func(string, []string, []string) error {
+		execCalled = true
+		return nil
+	})
+	// the request through ENV and annotation is ignored
+	if err := doPrestartHook(); err != nil || injected || execCalled {
+		t.Fatalf("the request should be ignored: %v", err)
+	}
+
+	// the request through volume mounts is still accepted
+	cfg.AcceptVolumeMounts = true
+	conCfg.Mounts = []string{"/var/run/ascend-container-devices/2"}
+	if getDeviceRequest(&conCfg) != "2" {
+		t.Fatalf("unexpected request: %s", getDeviceRequest(&conCfg))
+	}
+	cfg.AcceptEnvvar = true
+	conCfg.Mounts = nil
+	if getDeviceRequest(&conCfg) != "1" {
+		t.Fatalf("unexpected request: %s", getDeviceRequest(&conCfg))
+	}
+}
+
+func TestGetArgsDriverRoot(t *testing.T) {
+	cfg := ascendconfig.Default()
+	cfg.LdconfigPath = ""
+	stub := gostub.Stub(&hookCfg, cfg)
+	defer stub.Reset()
+	conCfg := containerConfig{Pid: pidSample, Rootfs: "/rootfs"}
+	args := getArgs("cli", &conCfg, []string{"/usr/local/bin/npu-smi"}, nil, "False")
+	if strings.Contains(strings.Join(args, " "), "--driver-root") {
+		t.Fatalf("driver root should not be passed by default: %v", args)
+	}
+
+	cfg.DriverRoot = "/run/ascend/driver"
+	args = getArgs("cli", &conCfg, []string{"/usr/local/bin/npu-smi"}, nil, "False")
+	if !strings.Contains(strings.Join(args, " "), "--driver-root /run/ascend/driver --mount-file /usr/local/bin/npu-smi") {
+		t.Fatalf("unexpected args: %v", args)
+	}
+}
+
+func TestGetLdconfigArgs(t *testing.T) {
+	ldconfigPath := filepath.Join(t.TempDir(), "ldconfig")
+	if err := os.WriteFile(ldconfigPath, []byte{}, 0500); err != nil {
+		t.Fatalf("failed to create ldconfig: %v", err)
+	}
+	cfg := ascendconfig.Default()
+	cfg.LdconfigPath = ldconfigPath
+	cfg.LdLibraryPath = "/usr/local/Ascend/driver/lib64/common:/usr/local/Ascend/driver/lib64/driver"
+	stub := gostub.Stub(&hookCfg, cfg)
+	defer stub.Reset()
+	args := strings.Join(getLdconfigArgs(), " ")
+	if args != "--ldconfig "+ldconfigPath+" --ld-conf-dir /usr/local/Ascend/driver/lib64/common "+
+		"--ld-conf-dir /usr/local/Ascend/driver/lib64/driver" {
+		t.Fatalf("unexpected args: %v", args)
+	}
+
+	cfg.LdconfigPath = ""
+	if len(getLdconfigArgs()) != 0 {
+		t.Fatalf("ldconfig should not be passed when it is disabled")
+	}
+}
+
+func TestInjectDevices(t *testing.T) {
+	hookDir := t.TempDir()
+	runtimePath := filepath.Join(hookDir, ascendDockerRuntime)
+	if err := os.WriteFile(runtimePath, []byte{}, 0500); err != nil {
+		t.Fatalf("failed to create runtime: %v", err)
+	}
+	var gotArgs []string
+	stub := gostub.Stub(&runCommand, func(name string, args ...string) ([]byte, error) {
+		gotArgs = append([]string{name}, args...)
+		return nil, nil
+	})
+	defer stub.Reset()
+	conCfg := containerConfig{Pid: pidSample, Bundle: "/run/containers/abc"}
+	if err := injectDevices(t.TempDir(), &conCfg); err == nil || gotArgs != nil {
+		t.Fatal("a missing runtime should be an error")
+	}
+	if err := injectDevices(hookDir, &conCfg); err != nil {
+		t.Skipf("runtime is refused by the file checker: %v", err)
+	}
+	want := fmt.Sprintf("%s hooks inject --pid %d --bundle /run/containers/abc", runtimePath, pidSample)
+	if strings.Join(gotArgs, " ") != want {
+		t.Fatalf("unexpected args: %v", gotArgs)
+	}
+
+	stub.Stub(&runCommand, func(name string, args ...string) ([]byte, error) {
+		return []byte("creating vnpu is not supported"), fmt.Errorf("exit status 1")
+	})
+	if err := injectDevices(hookDir, &conCfg); err == nil || !strings.Contains(err.Error(), "vnpu") {
+		t.Fatalf("the error of the runtime should be returned: %v", err)
+	}
+}
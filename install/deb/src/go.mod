@@ -3,6 +3,7 @@ module main
 go 1.18
 
 require (
+	ascendconfig v1.0.0
 	huawei.com/npu-exporter/v5 v5.0.0-RC1
 	mindxcheckutils v1.0.0
 )
@@ -13,6 +14,7 @@ require (
 )
 
 replace (
+	ascendconfig => ../../../ascendconfig
 	huawei.com/npu-exporter/v5 => gitee.com/ascend/ascend-npu-exporter/v5 v5.0.0-RC4.b002
 	mindxcheckutils => ../../../mindxcheckutils
 )
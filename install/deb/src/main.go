@@ -28,6 +28,7 @@ import (
 
 	"huawei.com/npu-exporter/v5/common-utils/hwlog"
 
+	"ascendconfig"
 	"mindxcheckutils"
 )
 
@@ -59,16 +60,25 @@ const (
 	addCommandLength    = 5
 	addCommand          = "add"
 	maxCommandLength    = 65535
-	logPath             = "/var/log/ascend-docker-runtime/install-helper-run.log"
+	logName             = "install-helper-run.log"
 	rmCommand           = "rm"
 	maxFileSize         = 1024 * 1024 * 10
 )
 
-var reserveDefaultRuntime = false
+var (
+	reserveDefaultRuntime = false
+	helperConfigFile      = ascendconfig.DefaultConfigPath
+	helperCfg             = ascendconfig.Default()
+)
 
 func main() {
+	cfg, err := ascendconfig.Load(helperConfigFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	helperCfg = cfg
 	ctx, _ := context.WithCancel(context.Background())
-	if err := initLogModule(ctx); err != nil {
+	if err = initLogModule(ctx); err != nil {
 		log.Fatal(err)
 	}
 	logPrefixWords, err := mindxcheckutils.GetLogPrefix()
@@ -80,7 +90,7 @@ func main() {
 	if !mindxcheckutils.StringChecker(strings.Join(os.Args, " "), 0,
 		maxCommandLength, mindxcheckutils.DefaultWhiteList+" ") {
 		hwlog.RunLog.Errorf("%v check command failed, maybe command contains illegal char", logPrefixWords)
-		log.Fatalf("command error, please check %s for detail", logPath)
+		log.Fatalf("command error, please check %s for detail", helperCfg.LogFile(logName))
 	}
 
 	err, behavior := process()
@@ -95,8 +105,8 @@ func initLogModule(ctx context.Context) error {
 	const backups = 2
 	const logMaxAge = 365
 	logConfig := hwlog.LogConfig{
-		LogFileName: logPath,
-		LogLevel:    0,
+		LogFileName: helperCfg.LogFile(logName),
+		LogLevel:    helperCfg.LogLevel,
 		MaxBackups:  backups,
 		MaxAge:      logMaxAge,
 		OnlyToFile:  true,
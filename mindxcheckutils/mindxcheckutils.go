@@ -17,9 +17,12 @@ package mindxcheckutils
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 )
 
@@ -46,10 +49,22 @@ var logPrefix = ""
 
 // RealFileChecker check if a file is safe to use
 func RealFileChecker(path string, checkParent, allowLink bool, size int) (string, error) {
+	return realFileChecker(path, checkParent, allowLink, false, size)
+}
+
+// RealHostFileChecker check if a file provided by the host is safe to use, like the files installed by root
+// or the bundle of the container engine. A rootless container engine runs its hooks in a user namespace, where
+// the files of root on the host are owned by the overflow uid, which is accepted by this check only, and only for
+// the paths of the host that only root writes
+func RealHostFileChecker(path string, checkParent, allowLink bool, size int) (string, error) {
+	return realFileChecker(path, checkParent, allowLink, true, size)
+}
+
+func realFileChecker(path string, checkParent, allowLink, overflowOwner bool, size int) (string, error) {
 	if !StringChecker(path, 0, DefaultPathSize, DefaultWhiteList) {
 		return notValidPath, fmt.Errorf("invalid path")
 	}
-	_, err := FileChecker(path, false, checkParent, allowLink, 0)
+	_, err := fileChecker(path, false, checkParent, allowLink, overflowOwner, 0)
 	if err != nil {
 		return notValidPath, err
 	}
@@ -106,6 +121,10 @@ func RealDirChecker(path string, checkParent, allowLink bool) (string, error) {
 
 // FileChecker check if a file/dir is safe to use
 func FileChecker(path string, allowDir, checkParent, allowLink bool, deep int) (bool, error) {
+	return fileChecker(path, allowDir, checkParent, allowLink, false, deep)
+}
+
+func fileChecker(path string, allowDir, checkParent, allowLink, overflowOwner bool, deep int) (bool, error) {
 	const maxDepth, groupWriteIndex, otherWriteIndex, permLength int = 99, 5, 8, 10
 	if deep > maxDepth {
 		return false, fmt.Errorf("over maxDepth %v", maxDepth)
@@ -142,15 +161,82 @@ func FileChecker(path string, allowDir, checkParent, allowLink bool, deep int) (
 		return false, fmt.Errorf("can not get stat %v", filePath)
 	}
 	uid := int(stat.Uid)
-	if !(uid == 0 || uid == os.Getuid()) {
+	if !(uid == 0 || uid == os.Getuid() || (overflowOwner && isOverflowUID(uid) && isHostPath(filePath))) {
 		return false, fmt.Errorf("owner not right %v %v", filePath, uid)
 	}
 	if filePath != "/" && checkParent {
-		return FileChecker(filepath.Dir(filePath), true, true, allowLink, deep+1)
+		return fileChecker(filepath.Dir(filePath), true, true, allowLink, overflowOwner, deep+1)
 	}
 	return true, nil
 }
 
+var (
+	// hostRoots the trees of the host that only root writes, a file owned by the overflow uid is accepted only
+	// under them, as every unmapped host user owns the files through the overflow uid
+	hostRoots = []string{"/etc", "/usr", "/bin", "/sbin", "/lib", "/lib64", "/opt", "/proc/sys"}
+	// hostDirs the system dirs above the bundles of the rootless container engines, their content is not trusted
+	hostDirs = []string{"/", "/home", "/run", "/run/user", "/var", "/var/lib", "/var/run"}
+)
+
+// AddHostRoot accept the overflow owner under root as well, like the install dir of ascend-docker-runtime
+func AddHostRoot(root string) {
+	if realRoot, err := filepath.EvalSymlinks(root); err == nil {
+		root = realRoot
+	}
+	hostRoots = append(hostRoots, filepath.Clean(root))
+}
+
+// isHostPath whether the real path is in a tree of the host that only root writes, or is a dir above such a tree
+// or above the bundles of the container engines
+func isHostPath(path string) bool {
+	realPath, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return false
+	}
+	for _, dir := range hostDirs {
+		if realPath == dir {
+			return true
+		}
+	}
+	for _, root := range hostRoots {
+		if realPath == root || strings.HasPrefix(realPath, root+"/") || strings.HasPrefix(root, realPath+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	uidMapPath      = "/proc/self/uid_map"
+	overflowUIDPath = "/proc/sys/kernel/overflowuid"
+	userNsOnce      sync.Once
+	inUserNs        bool
+	overflowUID     = -1
+)
+
+// fullUIDMap uid_map of the initial user namespace
+const fullUIDMap = "0 0 4294967295"
+
+// isOverflowUID whether the uid is the overflow uid of a user namespace, which owns the files of every host
+// user not mapped into the namespace
+func isOverflowUID(uid int) bool {
+	userNsOnce.Do(func() {
+		uidMap, err := ioutil.ReadFile(uidMapPath)
+		if err != nil {
+			return
+		}
+		inUserNs = strings.Join(strings.Fields(string(uidMap)), " ") != fullUIDMap
+		content, err := ioutil.ReadFile(overflowUIDPath)
+		if err != nil {
+			return
+		}
+		if id, err := strconv.Atoi(strings.TrimSpace(string(content))); err == nil {
+			overflowUID = id
+		}
+	})
+	return inUserNs && overflowUID > 0 && uid == overflowUID
+}
+
 func normalFileCheck(filePath string, allowDir bool, allowLink bool) (os.FileInfo, bool, error) {
 	realPath, err := filepath.EvalSymlinks(filePath)
 	if err != nil || (realPath != filePath && !allowLink) {
@@ -224,10 +310,16 @@ func StringChecker(text string, minLength, maxLength int, whiteList string) bool
 
 // ChangeRuntimeLogMode change log mode
 func ChangeRuntimeLogMode(runLog string) error {
-	runLogDirLen := len(runLogDir)
+	return ChangeLogModeInDir(runLogDir, runLog)
+}
+
+// ChangeLogModeInDir change log mode of the logs in logDir
+func ChangeLogModeInDir(logDir, runLog string) error {
+	logDir = filepath.Clean(logDir) + string(filepath.Separator)
+	runLogDirLen := len(logDir)
 	var logMode os.FileMode
 	counter := 0
-	err := filepath.Walk(runLogDir, func(fileOrPath string, fileInfo os.FileInfo, err error) error {
+	err := filepath.Walk(logDir, func(fileOrPath string, fileInfo os.FileInfo, err error) error {
 		counter += 1
 		if counter > maxFileNum {
 			return fmt.Errorf("the counter file is over maxFileNum")
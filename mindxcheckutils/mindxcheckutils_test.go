@@ -18,6 +18,7 @@ package mindxcheckutils
 import (
 	"os"
 	"strings"
+	"sync"
 	"testing"
 )
 
@@ -167,3 +168,102 @@ func removeTmpDir(t *testing.T, tmpDir string) {
 		t.Logf("removeall %v", tmpDir)
 	}
 }
+
+// fakeUserNs point the user namespace of the process to fake proc files, the values are read again
+func fakeUserNs(t *testing.T, uidMap string) {
+	dir := t.TempDir()
+	oldUIDMapPath, oldOverflowUIDPath := uidMapPath, overflowUIDPath
+	uidMapPath, overflowUIDPath = dir+"/uid_map", dir+"/overflowuid"
+	userNsOnce, inUserNs, overflowUID = sync.Once{}, false, -1
+	t.Cleanup(func() {
+		uidMapPath, overflowUIDPath = oldUIDMapPath, oldOverflowUIDPath
+		userNsOnce, inUserNs, overflowUID = sync.Once{}, false, -1
+	})
+	if err := os.WriteFile(overflowUIDPath, []byte("65534\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(uidMapPath, []byte(uidMap), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIsOverflowUID(t *testing.T) {
+	const nobody = 65534
+	fakeUserNs(t, "         0          0 4294967295\n")
+	if isOverflowUID(nobody) {
+		t.Fatalf("the initial user namespace has no overflow owner")
+	}
+	fakeUserNs(t, "         0       1000          1\n")
+	if !isOverflowUID(nobody) || isOverflowUID(1) {
+		t.Fatalf("the overflow uid should be found in a user namespace")
+	}
+	// the values are cached
+	if err := os.Remove(uidMapPath); err != nil || !isOverflowUID(nobody) {
+		t.Fatalf("the user namespace should be read once: %v", err)
+	}
+}
+
+// fakeHostRoot trust dir as a tree of the host that only root writes
+func fakeHostRoot(t *testing.T, dir string) {
+	oldHostRoots := hostRoots
+	t.Cleanup(func() {
+		hostRoots = oldHostRoots
+	})
+	AddHostRoot(dir)
+}
+
+// createNobodyFile create a file owned by the overflow uid
+func createNobodyFile(t *testing.T, dir string) string {
+	const nobody = 65534
+	filePath := dir + "/file"
+	if err := os.WriteFile(filePath, []byte("content"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chown(filePath, nobody, nobody); err != nil {
+		t.Skipf("chown is not permitted: %v", err)
+	}
+	return filePath
+}
+
+func TestIsHostPath(t *testing.T) {
+	for _, path := range []string{"/", "/usr", "/etc", "/run/user"} {
+		if !isHostPath(path) {
+			t.Fatalf("%s should be a host path", path)
+		}
+	}
+	dir := t.TempDir()
+	if isHostPath(dir) {
+		t.Fatalf("%s should not be a host path", dir)
+	}
+	fakeHostRoot(t, dir+"/root")
+	if !isHostPath(dir) {
+		t.Fatalf("the dirs above a host root should be host paths")
+	}
+}
+
+func TestRealHostFileChecker(t *testing.T) {
+	fakeUserNs(t, "         0       1000          1\n")
+	dir := t.TempDir()
+	fakeHostRoot(t, dir)
+	filePath := createNobodyFile(t, dir)
+	// only the files provided by the host may be owned by the overflow uid
+	if _, err := RealFileChecker(filePath, false, false, DefaultSize); err == nil {
+		t.Fatalf("the overflow owner should be refused")
+	}
+	if _, err := RealHostFileChecker(filePath, false, false, DefaultSize); err != nil {
+		t.Fatalf("the overflow owner should be accepted for host files: %v", err)
+	}
+	fakeUserNs(t, "         0          0 4294967295\n")
+	if _, err := RealHostFileChecker(filePath, false, false, DefaultSize); err == nil {
+		t.Fatalf("the overflow owner should be refused out of a user namespace")
+	}
+}
+
+func TestRealHostFileCheckerUntrustedPath(t *testing.T) {
+	fakeUserNs(t, "         0       1000          1\n")
+	// an unprivileged host user owns the file through the overflow uid as well
+	filePath := createNobodyFile(t, t.TempDir())
+	if _, err := RealHostFileChecker(filePath, false, false, DefaultSize); err == nil {
+		t.Fatalf("the overflow owner should be refused out of the host roots")
+	}
+}
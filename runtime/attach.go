@@ -0,0 +1,506 @@
+/* Copyright(C) 2023. Huawei Technologies Co.,Ltd. All rights reserved.
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package main
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/containerd/containerd/oci"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"golang.org/x/sys/unix"
+	"huawei.com/npu-exporter/v5/common-utils/hwlog"
+
+	"ascendconfig"
+	"main/injector"
+	"mindxcheckutils"
+)
+
+const (
+	deviceCommand       = "device"
+	deviceAttachCommand = "attach"
+	deviceDetachCommand = "detach"
+	deviceListCommand   = "list"
+	deviceUsage         = "usage: ascend-docker-runtime device attach|detach [--root <dir>] <container-id> <device-id>" +
+		"\n       ascend-docker-runtime device list [--root <dir>] <container-id>"
+	rootOption = "--root"
+
+	stateDirMode      = 0750
+	stateFileMode     = 0640
+	containerStopped  = "stopped"
+	containerNotExist = "not exist"
+	maxDeviceID       = 128
+	// startedRootfs the rootfs seen by the process of a started container, after pivot_root
+	startedRootfs = "/"
+)
+
+var (
+	deviceOutput    io.Writer = os.Stdout
+	containerIDRe             = regexp.MustCompile(`^[\w+.-]+$`)
+	attachRecordDir           = "/run/ascend-docker-runtime/attachments"
+)
+
+type deviceArgs struct {
+	cmd         string
+	globalArgs  []string
+	containerID string
+	deviceID    int
+}
+
+// containerState the fields of runc state used to reach the container
+type containerState struct {
+	ID     string `json:"id"`
+	Pid    int    `json:"pid"`
+	Status string `json:"status"`
+	Bundle string `json:"bundle"`
+}
+
+// attachedDevice a davinci device attached to or detached from a running container
+type attachedDevice struct {
+	ID    int   `json:"id"`
+	Major int64 `json:"major"`
+	Minor int64 `json:"minor"`
+}
+
+// attachRecord the devices changed after the container was created, the spec in the bundle does not know them
+type attachRecord struct {
+	ContainerID string           `json:"container_id"`
+	Attached    []attachedDevice `json:"attached"`
+	Detached    []attachedDevice `json:"detached"`
+}
+
+func getDeviceArgs(cmdArgs []string) (*deviceArgs, error) {
+	if len(cmdArgs) == 0 {
+		return nil, fmt.Errorf("%s", deviceUsage)
+	}
+	args := &deviceArgs{cmd: cmdArgs[0]}
+	positional := make([]string, 0)
+	for i := 1; i < len(cmdArgs); i++ {
+		if cmdArgs[i] == rootOption {
+			if len(cmdArgs)-i <= 1 {
+				return nil, fmt.Errorf("root option needs an argument")
+			}
+			args.globalArgs = append(args.globalArgs, rootOption, cmdArgs[i+1])
+			i++
+			continue
+		}
+		positional = append(positional, cmdArgs[i])
+	}
+	wantArgs := 2
+	switch args.cmd {
+	case deviceAttachCommand, deviceDetachCommand:
+	case deviceListCommand:
+		wantArgs = 1
+	default:
+		return nil, fmt.Errorf("%s", deviceUsage)
+	}
+	if len(positional) != wantArgs {
+		return nil, fmt.Errorf("%s", deviceUsage)
+	}
+	if !containerIDRe.MatchString(positional[0]) {
+		return nil, fmt.Errorf("invalid container id: %s", positional[0])
+	}
+	args.containerID = positional[0]
+	if wantArgs == 1 {
+		return args, nil
+	}
+	deviceID, err := strconv.Atoi(strings.TrimPrefix(positional[1], injector.DavinciName))
+	if err != nil || deviceID < 0 || deviceID >= maxDeviceID {
+		return nil, fmt.Errorf("invalid device id: %s", positional[1])
+	}
+	args.deviceID = deviceID
+	return args, nil
+}
+
+// queryContainerState ask the low level runtime where the container is, nil means it does not exist
+var queryContainerState = func(globalArgs []string, containerID string) (*containerState, error) {
+	tempRuncPath, err := lookRuncPath()
+	if err != nil {
+		return nil, err
+	}
+	runcPath, err := filepath.EvalSymlinks(tempRuncPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find realpath of runc %v", err)
+	}
+	if _, err = mindxcheckutils.RealFileChecker(runcPath, true, false, mindxcheckutils.DefaultSize); err != nil {
+		return nil, err
+	}
+	runcArgs := getRuncArgs(runcPath, append(append([]string{}, globalArgs...), "state", containerID))
+	var stderr bytes.Buffer
+	cmd := exec.Command(runcPath, runcArgs[1:]...)
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		if strings.Contains(strings.ToLower(stderr.String()), containerNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get state of container %s: %v %s", containerID, err, stderr.String())
+	}
+	var state containerState
+	if err = json.Unmarshal(output, &state); err != nil {
+		return nil, fmt.Errorf("failed to parse state of container %s: %v", containerID, err)
+	}
+	return &state, nil
+}
+
+// getContainerState get the state of a running container
+var getContainerState = func(globalArgs []string, containerID string) (*containerState, error) {
+	state, err := queryContainerState(globalArgs, containerID)
+	if err != nil {
+		return nil, err
+	}
+	if state == nil || state.Pid <= 0 || state.Status == containerStopped {
+		return nil, fmt.Errorf("container %s is not running", containerID)
+	}
+	return state, nil
+}
+
+func getAttachRecordPath(containerID string) string {
+	return filepath.Join(attachRecordDir, containerID+".json")
+}
+
+func loadAttachRecord(containerID string) (*attachRecord, error) {
+	record := &attachRecord{ContainerID: containerID}
+	recordPath := getAttachRecordPath(containerID)
+	if _, err := os.Stat(recordPath); os.IsNotExist(err) {
+		return record, nil
+	}
+	if _, err := mindxcheckutils.RealFileChecker(recordPath, true, false, mindxcheckutils.DefaultSize); err != nil {
+		return nil, err
+	}
+	content, err := ioutil.ReadFile(recordPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read attach record %s: %v", recordPath, err)
+	}
+	if err = json.Unmarshal(content, record); err != nil {
+		return nil, fmt.Errorf("failed to parse attach record %s: %v", recordPath, err)
+	}
+	return record, nil
+}
+
+// writeStateFile write a state file under /run through a temp file, so that a reader never sees a partial one
+func writeStateFile(path string, content []byte) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, stateDirMode); err != nil {
+		return fmt.Errorf("failed to create dir %s: %v", dir, err)
+	}
+	if _, err := mindxcheckutils.RealDirChecker(dir, true, false); err != nil {
+		return err
+	}
+	tmpFile, err := ioutil.TempFile(dir, "."+filepath.Base(path)+".")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file of %s: %v", path, err)
+	}
+	defer os.Remove(tmpFile.Name())
+	_, err = tmpFile.Write(content)
+	if err == nil {
+		err = tmpFile.Chmod(stateFileMode)
+	}
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write temp file of %s: %v", path, err)
+	}
+	if err = os.Rename(tmpFile.Name(), path); err != nil {
+		return fmt.Errorf("failed to rename temp file to %s: %v", path, err)
+	}
+	return nil
+}
+
+func saveAttachRecord(record *attachRecord) error {
+	content, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to marshal attach record: %v", err)
+	}
+	return writeStateFile(getAttachRecordPath(record.ContainerID), content)
+}
+
+func removeAttachedDevice(devices []attachedDevice, deviceID int) []attachedDevice {
+	kept := make([]attachedDevice, 0, len(devices))
+	for _, device := range devices {
+		if device.ID != deviceID {
+			kept = append(kept, device)
+		}
+	}
+	return kept
+}
+
+func hasAttachedDevice(devices []attachedDevice, deviceID int) bool {
+	return len(removeAttachedDevice(devices, deviceID)) != len(devices)
+}
+
+// newDeviceRule the rule of an attached or detached device, a detached device keeps mknod that every container is
+// allowed by the default rules, as a hole cannot be punched in a wildcard rule
+func newDeviceRule(device attachedDevice, allow bool) specs.LinuxDeviceCgroup {
+	major, minor := device.Major, device.Minor
+	access := deviceCgroupAccessAll
+	if !allow {
+		access = deviceCgroupAccessRW
+	}
+	return specs.LinuxDeviceCgroup{Allow: allow, Type: "c", Major: &major, Minor: &minor, Access: access}
+}
+
+// getDeviceRules the device rules of the container, the rules of the spec and the default rules of the runtime
+// followed by the changes of record
+func getDeviceRules(spec *specs.Spec, record *attachRecord) []specs.LinuxDeviceCgroup {
+	rules := make([]specs.LinuxDeviceCgroup, 0)
+	if spec.Linux != nil && spec.Linux.Resources != nil {
+		rules = append(rules, spec.Linux.Resources.Devices...)
+	}
+	rules = append(rules, defaultDeviceRules()...)
+	for _, device := range record.Attached {
+		rules = append(rules, newDeviceRule(device, true))
+	}
+	for _, device := range record.Detached {
+		rules = append(rules, newDeviceRule(device, false))
+	}
+	return rules
+}
+
+// hasSpecDevice whether the device node was added to the container when it was created
+func hasSpecDevice(spec *specs.Spec, dPath string) bool {
+	if spec.Linux == nil {
+		return false
+	}
+	for _, device := range spec.Linux.Devices {
+		if device.Path == dPath {
+			return true
+		}
+	}
+	return false
+}
+
+// openContainerDevDir open /dev under the rootfs through the root of the container process, so that /dev mounted
+// in the mount namespace of the container is used. /dev must not be a symlink so that every operation stays
+// inside the container
+func openContainerDevDir(pid int, rootfs string) (int, error) {
+	devDir := filepath.Join(procRoot, strconv.Itoa(pid), "root", rootfs, injector.DevicePath)
+	fd, err := unix.Open(devDir, unix.O_DIRECTORY|unix.O_NOFOLLOW|unix.O_RDONLY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return -1, fmt.Errorf("failed to open %s: %v", devDir, err)
+	}
+	return fd, nil
+}
+
+// getDeviceNodeState whether the node exists in the container, an existing node must be the device
+func getDeviceNodeState(dirFd int, device *specs.LinuxDevice) (bool, error) {
+	var stat unix.Stat_t
+	err := unix.Fstatat(dirFd, filepath.Base(device.Path), &stat, unix.AT_SYMLINK_NOFOLLOW)
+	if err == unix.ENOENT {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to stat %s in container: %v", device.Path, err)
+	}
+	if stat.Mode&unix.S_IFMT != unix.S_IFCHR || stat.Rdev != unix.Mkdev(uint32(device.Major), uint32(device.Minor)) {
+		return false, fmt.Errorf("%s in container is not the device %d:%d", device.Path, device.Major, device.Minor)
+	}
+	return true, nil
+}
+
+// createDeviceNode create the node under the rootfs of the container, which is startedRootfs once the container
+// is started
+func createDeviceNode(pid int, rootfs string, device *specs.LinuxDevice) error {
+	dirFd, err := openContainerDevDir(pid, rootfs)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(dirFd)
+	if exist, err := getDeviceNodeState(dirFd, device); err != nil || exist {
+		return err
+	}
+	name := filepath.Base(device.Path)
+	mode := uint32(unix.S_IFCHR)
+	if device.FileMode != nil {
+		mode |= uint32(device.FileMode.Perm())
+	}
+	// the mode is given by mknod, chmod would follow a symlink swapped in by the container
+	oldMask := unix.Umask(0)
+	err = unix.Mknodat(dirFd, name, mode, int(unix.Mkdev(uint32(device.Major), uint32(device.Minor))))
+	unix.Umask(oldMask)
+	if err != nil {
+		return fmt.Errorf("failed to create %s in container: %v", device.Path, err)
+	}
+	if device.UID != nil && device.GID != nil {
+		if err = unix.Fchownat(dirFd, name, int(*device.UID), int(*device.GID), unix.AT_SYMLINK_NOFOLLOW); err != nil {
+			return fmt.Errorf("failed to set owner of %s in container: %v", device.Path, err)
+		}
+	}
+	return nil
+}
+
+func removeDeviceNode(pid int, device *specs.LinuxDevice) error {
+	dirFd, err := openContainerDevDir(pid, startedRootfs)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(dirFd)
+	if exist, err := getDeviceNodeState(dirFd, device); err != nil || !exist {
+		return err
+	}
+	if err = unix.Unlinkat(dirFd, filepath.Base(device.Path), 0); err != nil {
+		return fmt.Errorf("failed to remove %s in container: %v", device.Path, err)
+	}
+	return nil
+}
+
+// getDeviceInfo get the device node on the host, its path is the one in the container
+func getDeviceInfo(deviceID int) (*specs.LinuxDevice, error) {
+	dPath := injector.DevicePath + injector.DavinciName + strconv.Itoa(deviceID)
+	device, err := oci.DeviceFromPath(ascendconfig.HostPath(runtimeCfg.GetDevRoot(), dPath))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s info : %v", dPath, err)
+	}
+	device.Path = dPath
+	return device, nil
+}
+
+func attachDevice(state *containerState, spec *specs.Spec, record *attachRecord, deviceID int) error {
+	device, err := getDeviceInfo(deviceID)
+	if err != nil {
+		return err
+	}
+	session := injector.NewSession(runtimeCfg)
+	defer session.Close()
+	if err = injector.CheckDeviceHealth(deviceID, runtimeCfg.UnhealthyDevicePolicy, session); err != nil {
+		return err
+	}
+	if hasAttachedDevice(record.Attached, deviceID) ||
+		(hasSpecDevice(spec, device.Path) && !hasAttachedDevice(record.Detached, deviceID)) {
+		return fmt.Errorf("%s is already in container %s", device.Path, state.ID)
+	}
+	cgroup, err := getDeviceCgroup(state.Pid)
+	if err != nil {
+		return err
+	}
+	// the node is created before the device is allowed, so that no failure leaves an allowed device unrecorded
+	if err = createDeviceNode(state.Pid, startedRootfs, device); err != nil {
+		return err
+	}
+	attached := attachedDevice{ID: deviceID, Major: device.Major, Minor: device.Minor}
+	record.Detached = removeAttachedDevice(record.Detached, deviceID)
+	record.Attached = append(record.Attached, attached)
+	// the rule allowing the device is the last one as cgroup v1 takes only the last rule
+	if err = cgroup.apply(append(getDeviceRules(spec, record), newDeviceRule(attached, true))); err != nil {
+		if removeErr := removeDeviceNode(state.Pid, device); removeErr != nil {
+			hwlog.RunLog.Warnf("failed to remove %s: %v", device.Path, removeErr)
+		}
+		return err
+	}
+	return nil
+}
+
+func detachDevice(state *containerState, spec *specs.Spec, record *attachRecord, deviceID int) error {
+	device, err := getDeviceInfo(deviceID)
+	if err != nil {
+		return err
+	}
+	if !hasAttachedDevice(record.Attached, deviceID) &&
+		(!hasSpecDevice(spec, device.Path) || hasAttachedDevice(record.Detached, deviceID)) {
+		return fmt.Errorf("%s is not in container %s", device.Path, state.ID)
+	}
+	cgroup, err := getDeviceCgroup(state.Pid)
+	if err != nil {
+		return err
+	}
+	if err = removeDeviceNode(state.Pid, device); err != nil {
+		return err
+	}
+	detached := attachedDevice{ID: deviceID, Major: device.Major, Minor: device.Minor}
+	record.Attached = removeAttachedDevice(record.Attached, deviceID)
+	if hasSpecDevice(spec, device.Path) {
+		record.Detached = append(record.Detached, detached)
+	}
+	return cgroup.apply(append(getDeviceRules(spec, record), newDeviceRule(detached, false)))
+}
+
+func writeAttachRecord(record *attachRecord) error {
+	content, err := json.MarshalIndent(record, "", previewIndent)
+	if err != nil {
+		return fmt.Errorf("failed to marshal attach record: %v", err)
+	}
+	_, err = fmt.Fprintf(deviceOutput, "%s\n", content)
+	return err
+}
+
+// attachExclusiveDevice attach the device, holding its lock first when exclusive-devices is set
+func attachExclusiveDevice(state *containerState, spec *specs.Spec, record *attachRecord, args *deviceArgs) error {
+	if !runtimeCfg.ExclusiveDevices {
+		return attachDevice(state, spec, record, args.deviceID)
+	}
+	if _, err := lockDevices([]int{args.deviceID}, args.containerID, args.globalArgs); err != nil {
+		return err
+	}
+	err := attachDevice(state, spec, record, args.deviceID)
+	if err != nil {
+		if releaseErr := releaseDeviceLocks(args.containerID, []int{args.deviceID}); releaseErr != nil {
+			hwlog.RunLog.Warnf("failed to release lock of device %d: %v", args.deviceID, releaseErr)
+		}
+	}
+	return err
+}
+
+// doDeviceProcess attach a davinci device to a running container or detach it, or list the changes
+func doDeviceProcess(cmdArgs []string) error {
+	args, err := getDeviceArgs(cmdArgs)
+	if err != nil {
+		return err
+	}
+	record, err := loadAttachRecord(args.containerID)
+	if err != nil {
+		return err
+	}
+	if args.cmd == deviceListCommand {
+		return writeAttachRecord(record)
+	}
+	state, err := getContainerState(args.globalArgs, args.containerID)
+	if err != nil {
+		return err
+	}
+	spec, err := readSpecFile(filepath.Join(state.Bundle, "config.json"))
+	if err != nil {
+		return err
+	}
+
+	if args.cmd == deviceAttachCommand {
+		err = attachExclusiveDevice(state, spec, record, args)
+	} else {
+		err = detachDevice(state, spec, record, args.deviceID)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to %s device %d: %v", args.cmd, args.deviceID, err)
+	}
+	if args.cmd == deviceDetachCommand && runtimeCfg.ExclusiveDevices {
+		if err = releaseDeviceLocks(args.containerID, []int{args.deviceID}); err != nil {
+			hwlog.RunLog.Warnf("failed to release lock of device %d: %v", args.deviceID, err)
+		}
+	}
+	if err = saveAttachRecord(record); err != nil {
+		return err
+	}
+	hwlog.RunLog.Infof("device %d %sed, container %s", args.deviceID, args.cmd, args.containerID)
+	return writeAttachRecord(record)
+}
@@ -0,0 +1,350 @@
+/* Copyright(C) 2023. Huawei Technologies Co.,Ltd. All rights reserved.
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package main
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/agiledragon/gomonkey/v2"
+	"github.com/cilium/ebpf/asm"
+	"github.com/containerd/containerd/oci"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
+
+	"main/dcmi"
+	"main/injector"
+	"mindxcheckutils"
+)
+
+const (
+	testDavinciMajor = 236
+	testManagerMajor = 237
+	testFileMode     = 0600
+
+	// struct bpf_cgroup_dev_ctx is three u32, the access is the upper half of the first
+	devCtxFieldSize   = 4
+	devCtxAccessShift = 16
+)
+
+func TestGetDeviceArgs(t *testing.T) {
+	args, err := getDeviceArgs([]string{"attach", "--root", "/run/docker/runtime-runc/moby", "abc", "davinci3"})
+	assert.Nil(t, err)
+	assert.EqualValues(t, []string{"--root", "/run/docker/runtime-runc/moby"}, args.globalArgs)
+	assert.EqualValues(t, "abc", args.containerID)
+	assert.EqualValues(t, 3, args.deviceID)
+
+	args, err = getDeviceArgs([]string{"list", "abc"})
+	assert.Nil(t, err)
+	assert.EqualValues(t, "list", args.cmd)
+
+	for _, cmdArgs := range [][]string{{}, {"move", "abc", "1"}, {"attach", "abc"}, {"attach", "../abc", "1"},
+		{"detach", "abc", "-1"}, {"list", "abc", "--root"}} {
+		_, err = getDeviceArgs(cmdArgs)
+		assert.NotNil(t, err, cmdArgs)
+	}
+}
+
+func TestParseDeviceCgroup(t *testing.T) {
+	cgroup, err := parseDeviceCgroup("12:devices:/docker/abc\n11:cpu,cpuacct:/docker/abc\n0::/\n")
+	assert.Nil(t, err)
+	assert.False(t, cgroup.v2)
+	assert.EqualValues(t, "/sys/fs/cgroup/devices/docker/abc", cgroup.path)
+
+	cgroup, err = parseDeviceCgroup("0::/system.slice/docker-abc.scope\n")
+	assert.Nil(t, err)
+	assert.True(t, cgroup.v2)
+	assert.EqualValues(t, "/sys/fs/cgroup/system.slice/docker-abc.scope", cgroup.path)
+
+	_, err = parseDeviceCgroup("")
+	assert.NotNil(t, err)
+}
+
+func newTestRule(allow bool, deviceType string, major, minor int64, access string) specs.LinuxDeviceCgroup {
+	return specs.LinuxDeviceCgroup{Allow: allow, Type: deviceType, Major: &major, Minor: &minor, Access: access}
+}
+
+// runDeviceFilter decode the program and run it on struct bpf_cgroup_dev_ctx, the jumps are resolved by symbol
+func runDeviceFilter(t *testing.T, insns asm.Instructions, deviceType, access, major, minor uint32) int32 {
+	ctx := []uint32{deviceType | access<<devCtxAccessShift, major, minor}
+	symbols, err := insns.SymbolOffsets()
+	assert.Nil(t, err)
+	var regs [asm.R10 + 1]uint32
+	for pc := 0; pc < len(insns); pc++ {
+		insn := insns[pc]
+		src := uint32(insn.Constant)
+		if insn.OpCode.Source() == asm.RegSource {
+			src = regs[insn.Src]
+		}
+		switch insn.OpCode.Class() {
+		case asm.LdXClass:
+			if insn.OpCode.Mode() != asm.MemMode || insn.OpCode.Size() != asm.Word || insn.Src != asm.R1 {
+				t.Fatalf("unexpected load %v", insn)
+			}
+			regs[insn.Dst] = ctx[insn.Offset/devCtxFieldSize]
+		case asm.ALUClass:
+			switch insn.OpCode.ALUOp() {
+			case asm.Mov:
+				regs[insn.Dst] = src
+			case asm.And:
+				regs[insn.Dst] &= src
+			case asm.RSh:
+				regs[insn.Dst] >>= src
+			default:
+				t.Fatalf("unexpected alu %v", insn)
+			}
+		case asm.JumpClass:
+			switch insn.OpCode.JumpOp() {
+			case asm.Exit:
+				return int32(regs[asm.R0])
+			case asm.JNE:
+				if regs[insn.Dst] == src {
+					continue
+				}
+			case asm.Ja:
+			default:
+				t.Fatalf("unexpected jump %v", insn)
+			}
+			target, ok := symbols[insn.Reference]
+			if !ok {
+				target = pc + 1 + int(insn.Offset)
+			}
+			pc = target - 1
+		default:
+			t.Fatalf("unexpected instruction %v", insn)
+		}
+	}
+	t.Fatalf("program does not exit")
+	return 0
+}
+
+func TestBuildDeviceFilter(t *testing.T) {
+	insns, license, err := buildDeviceFilter([]specs.LinuxDeviceCgroup{
+		{Allow: false, Access: "rwm"},
+		newTestRule(true, "c", 1, 3, "rwm"),
+		newTestRule(true, "c", testDavinciMajor, wildcardID, "rw"),
+		newTestRule(true, "c", testManagerMajor, 0, ""),
+		newTestRule(false, "c", testManagerMajor, 0, "w"),
+	})
+	assert.Nil(t, err)
+	assert.NotEmpty(t, license)
+	for _, c := range []struct {
+		deviceType, access, major, minor uint32
+		verdict                          int32
+	}{
+		{unix.BPF_DEVCG_DEV_CHAR, unix.BPF_DEVCG_ACC_READ, 1, 3, 1},
+		{unix.BPF_DEVCG_DEV_BLOCK, unix.BPF_DEVCG_ACC_READ, 1, 3, 0},
+		{unix.BPF_DEVCG_DEV_CHAR, unix.BPF_DEVCG_ACC_READ | unix.BPF_DEVCG_ACC_WRITE, testDavinciMajor, 0, 1},
+		{unix.BPF_DEVCG_DEV_CHAR, unix.BPF_DEVCG_ACC_MKNOD, testDavinciMajor, 0, 0},
+		{unix.BPF_DEVCG_DEV_CHAR, unix.BPF_DEVCG_ACC_READ | unix.BPF_DEVCG_ACC_MKNOD, testManagerMajor, 0, 1},
+		{unix.BPF_DEVCG_DEV_CHAR, unix.BPF_DEVCG_ACC_WRITE, testManagerMajor, 0, 0},
+		{unix.BPF_DEVCG_DEV_CHAR, unix.BPF_DEVCG_ACC_READ, testManagerMajor, 1, 0},
+		{unix.BPF_DEVCG_DEV_CHAR, unix.BPF_DEVCG_ACC_READ, 5, 1, 0},
+	} {
+		assert.EqualValues(t, c.verdict, runDeviceFilter(t, insns, c.deviceType, c.access, c.major, c.minor), c)
+	}
+
+	// the devices matched by no deny rule are allowed when the rules start with allowing all
+	insns, _, err = buildDeviceFilter([]specs.LinuxDeviceCgroup{{Allow: true, Access: "rwm"},
+		newTestRule(false, "c", testDavinciMajor, 3, "rwm")})
+	assert.Nil(t, err)
+	assert.EqualValues(t, 0, runDeviceFilter(t, insns, unix.BPF_DEVCG_DEV_CHAR, unix.BPF_DEVCG_ACC_READ,
+		testDavinciMajor, 3))
+	assert.EqualValues(t, 1, runDeviceFilter(t, insns, unix.BPF_DEVCG_DEV_CHAR, unix.BPF_DEVCG_ACC_READ,
+		testDavinciMajor, 2))
+
+	for _, rules := range [][]specs.LinuxDeviceCgroup{
+		{newTestRule(true, "p", 1, 3, "rwm")},
+		{newTestRule(true, "c", 1, 3, "rx")},
+		{newTestRule(true, "cc", 1, 3, "rwm")},
+		// a hole cannot be punched in a wildcard rule
+		{{Allow: false, Access: "rwm"}, newTestRule(true, "c", testDavinciMajor, wildcardID, "rw"),
+			newTestRule(false, "c", testDavinciMajor, 2, "rwm")},
+	} {
+		_, _, err = buildDeviceFilter(rules)
+		assert.NotNil(t, err, rules)
+	}
+}
+
+func TestDeviceRulesKeepDefaults(t *testing.T) {
+	spec := &specs.Spec{Linux: &specs.Linux{Resources: &specs.LinuxResources{
+		Devices: []specs.LinuxDeviceCgroup{{Allow: false, Access: "rwm"}}}}}
+	record := &attachRecord{Attached: []attachedDevice{{ID: 1, Major: testDavinciMajor, Minor: 1}},
+		Detached: []attachedDevice{{ID: 2, Major: testDavinciMajor, Minor: 2}}}
+	insns, _, err := buildDeviceFilter(append(getDeviceRules(spec, record),
+		newDeviceRule(attachedDevice{ID: 1, Major: testDavinciMajor, Minor: 1}, false)))
+	assert.Nil(t, err)
+	for _, c := range []struct {
+		deviceType, access, major, minor uint32
+		verdict                          int32
+	}{
+		{unix.BPF_DEVCG_DEV_CHAR, unix.BPF_DEVCG_ACC_READ | unix.BPF_DEVCG_ACC_WRITE, 1, 3, 1},
+		{unix.BPF_DEVCG_DEV_CHAR, unix.BPF_DEVCG_ACC_READ | unix.BPF_DEVCG_ACC_WRITE, 5, 2, 1},
+		{unix.BPF_DEVCG_DEV_CHAR, unix.BPF_DEVCG_ACC_READ, 136, 4, 1},
+		{unix.BPF_DEVCG_DEV_CHAR, unix.BPF_DEVCG_ACC_MKNOD, 10, 200, 1},
+		{unix.BPF_DEVCG_DEV_CHAR, unix.BPF_DEVCG_ACC_READ, 10, 200, 0},
+		{unix.BPF_DEVCG_DEV_BLOCK, unix.BPF_DEVCG_ACC_READ, 8, 0, 0},
+		{unix.BPF_DEVCG_DEV_CHAR, unix.BPF_DEVCG_ACC_READ | unix.BPF_DEVCG_ACC_WRITE, testDavinciMajor, 1, 0},
+		{unix.BPF_DEVCG_DEV_CHAR, unix.BPF_DEVCG_ACC_MKNOD, testDavinciMajor, 1, 1},
+		{unix.BPF_DEVCG_DEV_CHAR, unix.BPF_DEVCG_ACC_READ, testDavinciMajor, 2, 0},
+		{unix.BPF_DEVCG_DEV_CHAR, unix.BPF_DEVCG_ACC_READ, testDavinciMajor, 3, 0},
+	} {
+		assert.EqualValues(t, c.verdict, runDeviceFilter(t, insns, c.deviceType, c.access, c.major, c.minor), c)
+	}
+}
+
+func stubAttach(t *testing.T, spec *specs.Spec) (*gomonkey.Patches, string) {
+	stub, cgroupDir := stubAttachContainer(t, spec)
+	stub.ApplyFunc(createDeviceNode, func(pid int, rootfs string, device *specs.LinuxDevice) error {
+		return nil
+	})
+	stub.ApplyFunc(removeDeviceNode, func(pid int, device *specs.LinuxDevice) error {
+		return nil
+	})
+	return stub, cgroupDir
+}
+
+// stubAttachContainer stub the container and its device cgroup, leaving the device nodes to the test
+func stubAttachContainer(t *testing.T, spec *specs.Spec) (*gomonkey.Patches, string) {
+	dir := t.TempDir()
+	content, err := json.Marshal(spec)
+	assert.Nil(t, err)
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "config.json"), content, testFileMode))
+	cgroupDir := filepath.Join(dir, "cgroup")
+	assert.Nil(t, os.Mkdir(cgroupDir, stateDirMode))
+	for _, name := range []string{devicesAllowFile, devicesDenyFile} {
+		assert.Nil(t, ioutil.WriteFile(filepath.Join(cgroupDir, name), nil, testFileMode))
+	}
+
+	stub := gomonkey.ApplyGlobalVar(&attachRecordDir, filepath.Join(dir, "attachments"))
+	stub.ApplyGlobalVar(&deviceOutput, &bytes.Buffer{})
+	stub.ApplyGlobalVar(&getContainerState, func(globalArgs []string, containerID string) (*containerState, error) {
+		return &containerState{ID: containerID, Pid: 1, Status: "running", Bundle: dir}, nil
+	})
+	stub.ApplyFunc(mindxcheckutils.RealFileChecker, func(path string, checkParent, allowLink bool,
+		size int) (string, error) {
+		return path, nil
+	})
+	stub.ApplyFunc(mindxcheckutils.RealDirChecker, func(path string, checkParent, allowLink bool) (string, error) {
+		return path, nil
+	})
+	stub.ApplyFunc(oci.DeviceFromPath, func(path string) (*specs.LinuxDevice, error) {
+		return &specs.LinuxDevice{Path: path, Type: "c", Major: testDavinciMajor, Minor: 1}, nil
+	})
+	stub.ApplyFunc(injector.CheckDeviceHealth, func(deviceID int, policy string, session *dcmi.Session) error {
+		return nil
+	})
+	stub.ApplyFunc(getDeviceCgroup, func(pid int) (*deviceCgroup, error) {
+		return &deviceCgroup{path: cgroupDir}, nil
+	})
+	return stub, cgroupDir
+}
+
+func TestDoDeviceProcess(t *testing.T) {
+	spec := &specs.Spec{Linux: &specs.Linux{Devices: []specs.LinuxDevice{{Path: "/dev/davinci0"}}}}
+	stub, cgroupDir := stubAttach(t, spec)
+	defer stub.Reset()
+
+	assert.Nil(t, doDeviceProcess([]string{"attach", "abc", "1"}))
+	content, err := ioutil.ReadFile(filepath.Join(cgroupDir, devicesAllowFile))
+	assert.Nil(t, err)
+	assert.EqualValues(t, "c 236:1 rwm", string(content))
+	record, err := loadAttachRecord("abc")
+	assert.Nil(t, err)
+	assert.EqualValues(t, []attachedDevice{{ID: 1, Major: testDavinciMajor, Minor: 1}}, record.Attached)
+	assert.NotNil(t, doDeviceProcess([]string{"attach", "abc", "1"}))
+	assert.NotNil(t, doDeviceProcess([]string{"attach", "abc", "0"}))
+
+	// a device of the spec is recorded as detached
+	assert.Nil(t, doDeviceProcess([]string{"detach", "abc", "0"}))
+	assert.Nil(t, doDeviceProcess([]string{"detach", "abc", "1"}))
+	assert.NotNil(t, doDeviceProcess([]string{"detach", "abc", "1"}))
+	content, err = ioutil.ReadFile(filepath.Join(cgroupDir, devicesDenyFile))
+	assert.Nil(t, err)
+	assert.EqualValues(t, "c 236:1 rw", string(content))
+	record, err = loadAttachRecord("abc")
+	assert.Nil(t, err)
+	assert.Empty(t, record.Attached)
+	assert.EqualValues(t, []attachedDevice{{ID: 0, Major: testDavinciMajor, Minor: 1}}, record.Detached)
+}
+
+func TestAttachDeviceFailure(t *testing.T) {
+	spec := &specs.Spec{Linux: &specs.Linux{}}
+	stub, cgroupDir := stubAttachContainer(t, spec)
+	defer stub.Reset()
+	var created, removed int
+	stub.ApplyFunc(createDeviceNode, func(pid int, rootfs string, device *specs.LinuxDevice) error {
+		created++
+		if created == 1 {
+			return fmt.Errorf("no /dev")
+		}
+		return nil
+	})
+	stub.ApplyFunc(removeDeviceNode, func(pid int, device *specs.LinuxDevice) error {
+		removed++
+		return nil
+	})
+
+	// the device is not allowed when its node cannot be created
+	assert.NotNil(t, doDeviceProcess([]string{"attach", "abc", "1"}))
+	content, err := ioutil.ReadFile(filepath.Join(cgroupDir, devicesAllowFile))
+	assert.Nil(t, err)
+	assert.Empty(t, content)
+
+	// the node is removed when the device cannot be allowed
+	assert.Nil(t, os.Remove(filepath.Join(cgroupDir, devicesAllowFile)))
+	assert.NotNil(t, doDeviceProcess([]string{"attach", "abc", "1"}))
+	assert.EqualValues(t, 2, created)
+	assert.EqualValues(t, 1, removed)
+	record, err := loadAttachRecord("abc")
+	assert.Nil(t, err)
+	assert.Empty(t, record.Attached)
+}
+
+func TestCreateDeviceNode(t *testing.T) {
+	dir := t.TempDir()
+	stub := gomonkey.ApplyGlobalVar(&procRoot, dir)
+	defer stub.Reset()
+	devDir := filepath.Join(dir, "1", "root", "dev")
+	assert.Nil(t, os.MkdirAll(devDir, stateDirMode))
+	mode := os.FileMode(testFileMode)
+	device := &specs.LinuxDevice{Path: "/dev/davinci1", Type: "c", Major: 1, Minor: 3, FileMode: &mode}
+
+	if err := createDeviceNode(1, startedRootfs, device); err != nil {
+		t.Skipf("mknod is not permitted: %v", err)
+	}
+	stat, err := os.Lstat(filepath.Join(devDir, "davinci1"))
+	assert.Nil(t, err)
+	assert.EqualValues(t, os.ModeDevice|os.ModeCharDevice|mode, stat.Mode())
+	assert.Nil(t, createDeviceNode(1, startedRootfs, device))
+	device.Minor = 5
+	assert.NotNil(t, createDeviceNode(1, startedRootfs, device))
+	assert.NotNil(t, removeDeviceNode(1, device))
+	device.Minor = 3
+	assert.Nil(t, removeDeviceNode(1, device))
+	assert.Nil(t, removeDeviceNode(1, device))
+
+	// /dev of the container must not lead out of it
+	assert.Nil(t, os.RemoveAll(devDir))
+	assert.Nil(t, os.Symlink(dir, devDir))
+	assert.NotNil(t, createDeviceNode(1, startedRootfs, device))
+}
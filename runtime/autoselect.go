@@ -0,0 +1,226 @@
+/* Copyright(C) 2023. Huawei Technologies Co.,Ltd. All rights reserved.
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package main
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"huawei.com/npu-exporter/v5/common-utils/hwlog"
+
+	"main/dcmi"
+	"main/injector"
+)
+
+// maxWholeDomains the most domains combined when a request is larger than any domain
+const maxWholeDomains = 16
+
+// getDeviceLoads count the other containers holding each device by the allocation records alone, the records of
+// the containers gone are left to be pruned by ps and lock gc
+func getDeviceLoads(id string) (map[int]int, error) {
+	records, err := loadAllocations()
+	if err != nil {
+		return nil, err
+	}
+	loads := make(map[int]int)
+	for _, record := range records {
+		if record.ContainerID == id {
+			continue
+		}
+		for _, id := range record.Devices {
+			loads[id]++
+		}
+	}
+	return loads, nil
+}
+
+// getLockedDevices get the devices exclusively held by the other live containers, the caller holds the lock of
+// the lock dir
+func getLockedDevices(id string) (map[int]bool, error) {
+	locked := make(map[int]bool)
+	if !runtimeCfg.ExclusiveDevices {
+		return locked, nil
+	}
+	locks, err := listDeviceLocks()
+	if err != nil {
+		return nil, err
+	}
+	for _, lock := range locks {
+		if lock.ContainerID != id && isLockHolderAlive(lock) {
+			locked[lock.DeviceID] = true
+		}
+	}
+	return locked, nil
+}
+
+func isDeviceHealthy(deviceID int, session *dcmi.Session) bool {
+	health, err := session.GetDeviceHealth(int32(deviceID))
+	if err != nil {
+		hwlog.RunLog.Warnf("%s%d is skipped, its health is unknown: %v", injector.DavinciName, deviceID, err)
+		return false
+	}
+	if !health.IsHealthy() {
+		hwlog.RunLog.Warnf("%s%d is skipped, it is unhealthy, %s", injector.DavinciName, deviceID, health)
+		return false
+	}
+	return true
+}
+
+// resolveAutoDevices pick the healthy devices held by the fewest containers for an auto:N request and write their
+// ids back into the spec, devices exclusively held by other containers are never picked, and the devices stay
+// inside one hccs domain unless the topology policy is none. The loads are counted and the picked devices are
+// recorded holding the lock of the lock dir, so that the concurrent requests never pick by the same loads
+func resolveAutoDevices(spec *specs.Spec, id string, session *dcmi.Session) error {
+	count, isAuto, err := injector.GetAutoDeviceCount(spec, runtimeCfg)
+	if err != nil || !isAuto {
+		return err
+	}
+	devices, err := injector.GetAllDevices(session)
+	if err != nil {
+		return err
+	}
+	healthy := make([]int, 0, len(devices))
+	for _, deviceID := range devices {
+		if isDeviceHealthy(deviceID, session) {
+			healthy = append(healthy, deviceID)
+		}
+	}
+	return withLockDir(func() error {
+		loads, err := getDeviceLoads(id)
+		if err != nil {
+			return err
+		}
+		locked, err := getLockedDevices(id)
+		if err != nil {
+			return err
+		}
+		candidates := make([]int, 0, len(healthy))
+		for _, deviceID := range healthy {
+			if !locked[deviceID] {
+				candidates = append(candidates, deviceID)
+			}
+		}
+		if len(candidates) < count {
+			return fmt.Errorf("only %d devices are available, %d are requested", len(candidates), count)
+		}
+		sort.SliceStable(candidates, func(i, j int) bool { return loads[candidates[i]] < loads[candidates[j]] })
+		chosen, err := pickDevices(spec, candidates, loads, count, session)
+		if err != nil {
+			return err
+		}
+		sort.Ints(chosen)
+		if err = saveProvisionalAllocation(id, chosen); err != nil {
+			return err
+		}
+		injector.SetVisibleDevices(spec, chosen)
+		hwlog.RunLog.Infof("devices %v are picked for %s%d, loads: %v", chosen, injector.VisibleDevicesAuto, count,
+			loads)
+		return nil
+	})
+}
+
+// pickDevices pick count devices from the candidates sorted by load, following the hccs domains of the node
+func pickDevices(spec *specs.Spec, candidates []int, loads map[int]int, count int,
+	session *dcmi.Session) ([]int, error) {
+	policy, err := injector.GetTopologyPolicy(spec)
+	if err != nil {
+		return nil, err
+	}
+	if policy == injector.TopologyPolicyNone || count == 1 {
+		return candidates[:count], nil
+	}
+	domains, err := session.GetHCCSDomains()
+	if err != nil {
+		if policy == injector.TopologyPolicyStrict {
+			return nil, fmt.Errorf("hccs topology is unknown: %v", err)
+		}
+		hwlog.RunLog.Warnf("devices are picked regardless of hccs, the topology is unknown: %v", err)
+		return candidates[:count], nil
+	}
+	if !injector.HasHCCS(domains) && policy != injector.TopologyPolicyStrict {
+		return candidates[:count], nil
+	}
+	return pickInDomains(candidates, loads, domains, count)
+}
+
+func sumLoads(devices []int, loads map[int]int) int {
+	sum := 0
+	for _, id := range devices {
+		sum += loads[id]
+	}
+	return sum
+}
+
+// pickInDomains pick the devices of one domain with the least load, the domain with fewer available devices is
+// preferred so that larger domains are kept for larger requests, whole domains are picked when no domain is enough
+func pickInDomains(candidates []int, loads map[int]int, domains [][]int32, count int) ([]int, error) {
+	domainOf := make(map[int]int)
+	for i, domain := range domains {
+		for _, phyID := range domain {
+			domainOf[int(phyID)] = i
+		}
+	}
+	available := make([][]int, len(domains))
+	for _, id := range candidates {
+		if i, ok := domainOf[id]; ok {
+			available[i] = append(available[i], id)
+		}
+	}
+
+	var best []int
+	bestLoad, bestSize := 0, 0
+	for _, devices := range available {
+		if len(devices) < count {
+			continue
+		}
+		load := sumLoads(devices[:count], loads)
+		if best == nil || load < bestLoad || (load == bestLoad && len(devices) < bestSize) {
+			best, bestLoad, bestSize = devices[:count], load, len(devices)
+		}
+	}
+	if best != nil {
+		return best, nil
+	}
+
+	whole := make([][]int, 0, len(domains))
+	for i, domain := range domains {
+		if len(available[i]) == len(domain) {
+			whole = append(whole, available[i])
+		}
+	}
+	if len(whole) <= maxWholeDomains {
+		for mask := 1; mask < 1<<len(whole); mask++ {
+			devices := make([]int, 0, count)
+			for i := range whole {
+				if mask&(1<<i) != 0 {
+					devices = append(devices, whole[i]...)
+				}
+			}
+			if len(devices) != count {
+				continue
+			}
+			if load := sumLoads(devices, loads); best == nil || load < bestLoad {
+				best, bestLoad = devices, load
+			}
+		}
+	}
+	if best == nil {
+		return nil, fmt.Errorf("no %d available devices are inside one hccs domain or make whole domains, "+
+			"the domains are %v", count, domains)
+	}
+	return best, nil
+}
@@ -0,0 +1,146 @@
+/* Copyright(C) 2023. Huawei Technologies Co.,Ltd. All rights reserved.
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package main
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/agiledragon/gomonkey/v2"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/stretchr/testify/assert"
+
+	"main/dcmi"
+	"main/injector"
+)
+
+func TestResolveAutoDevices(t *testing.T) {
+	alive := map[string]bool{"abc": true, "def": true}
+	stub := stubLock(t, alive)
+	defer stub.Reset()
+	stub.ApplyGlobalVar(&allocationDir, filepath.Join(t.TempDir(), "allocations"))
+	stub.ApplyFunc(injector.GetAllDevices, func(session *dcmi.Session) ([]int, error) {
+		return []int{0, 1, 2, 3, 4}, nil
+	})
+	stub.ApplyMethod(reflect.TypeOf(&dcmi.Session{}), "GetDeviceHealth",
+		func(_ *dcmi.Session, phyID int32) (*dcmi.DeviceHealth, error) {
+			if phyID == 4 {
+				return nil, fmt.Errorf("device not found")
+			}
+			if phyID == 1 {
+				return &dcmi.DeviceHealth{PhyID: phyID, State: dcmi.HealthCriticalAlarm}, nil
+			}
+			return &dcmi.DeviceHealth{PhyID: phyID}, nil
+		})
+	stub.ApplyGlobalVar(&containerID, "abc")
+	stub.ApplyGlobalVar(&deviceIdList, []int{0})
+	stub.ApplyGlobalVar(&physicalIdList, []int{0})
+	saveAllocation()
+	session := dcmi.NewSession(&dcmi.NpuWorker{})
+
+	containerID = "def"
+	spec := &specs.Spec{Process: &specs.Process{Env: []string{"ASCEND_VISIBLE_DEVICES=auto:2"}}}
+	assert.Nil(t, resolveAutoDevices(spec, containerID, session))
+	assert.EqualValues(t, []string{"ASCEND_VISIBLE_DEVICES=2,3"}, spec.Process.Env)
+	// the device held by another container is picked only when no free device is left
+	spec.Process.Env = []string{"ASCEND_VISIBLE_DEVICES=auto:3"}
+	assert.Nil(t, resolveAutoDevices(spec, containerID, session))
+	assert.EqualValues(t, []string{"ASCEND_VISIBLE_DEVICES=0,2,3"}, spec.Process.Env)
+	spec.Process.Env = []string{"ASCEND_VISIBLE_DEVICES=auto:4"}
+	assert.NotNil(t, resolveAutoDevices(spec, containerID, session))
+
+	// devices exclusively held are never picked
+	cfg := *runtimeCfg
+	cfg.ExclusiveDevices = true
+	stub.ApplyGlobalVar(&runtimeCfg, &cfg)
+	_, err := lockDevices([]int{0}, "abc", nil)
+	assert.Nil(t, err)
+	spec.Process.Env = []string{"ASCEND_VISIBLE_DEVICES=auto:3"}
+	assert.NotNil(t, resolveAutoDevices(spec, containerID, session))
+
+	spec.Process.Env = []string{"ASCEND_VISIBLE_DEVICES=0"}
+	assert.Nil(t, resolveAutoDevices(spec, containerID, session))
+	assert.EqualValues(t, []string{"ASCEND_VISIBLE_DEVICES=0"}, spec.Process.Env)
+}
+
+func TestResolveAutoDevicesConcurrently(t *testing.T) {
+	stub := stubLock(t, nil)
+	defer stub.Reset()
+	stub.ApplyGlobalVar(&allocationDir, filepath.Join(t.TempDir(), "allocations"))
+	stub.ApplyFunc(injector.GetAllDevices, func(session *dcmi.Session) ([]int, error) {
+		return []int{0, 1, 2, 3}, nil
+	})
+	stub.ApplyMethod(reflect.TypeOf(&dcmi.Session{}), "GetDeviceHealth",
+		func(_ *dcmi.Session, phyID int32) (*dcmi.DeviceHealth, error) {
+			return &dcmi.DeviceHealth{PhyID: phyID}, nil
+		})
+	// the loads are counted by the records alone, the state of the containers is never queried
+	queried := false
+	queryStub := gomonkey.ApplyGlobalVar(&queryContainerState, func(globalArgs []string,
+		containerID string) (*containerState, error) {
+		queried = true
+		return nil, nil
+	})
+	defer queryStub.Reset()
+	session := dcmi.NewSession(&dcmi.NpuWorker{})
+
+	const requests = 4
+	requested := make([]*specs.Spec, requests)
+	var wg sync.WaitGroup
+	for i := range requested {
+		requested[i] = &specs.Spec{Process: &specs.Process{Env: []string{"ASCEND_VISIBLE_DEVICES=auto:1"}}}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			assert.Nil(t, resolveAutoDevices(requested[i], "c"+strconv.Itoa(i), session))
+		}(i)
+	}
+	wg.Wait()
+	picked := make(map[string]bool)
+	for _, spec := range requested {
+		picked[spec.Process.Env[0]] = true
+	}
+	assert.Len(t, picked, requests)
+	assert.False(t, queried)
+	records, err := loadAllocations()
+	assert.Nil(t, err)
+	assert.Len(t, records, requests)
+}
+
+func TestPickInDomains(t *testing.T) {
+	domains := [][]int32{{0, 1, 2, 3}, {4, 5, 6, 7}}
+	loads := map[int]int{0: 1}
+	all := []int{1, 2, 3, 4, 5, 6, 7, 0}
+
+	// the domain with fewer available devices is preferred
+	devices, err := pickInDomains([]int{1, 2, 4, 5, 6, 7}, loads, domains, 2)
+	assert.Nil(t, err)
+	assert.EqualValues(t, []int{1, 2}, devices)
+	devices, err = pickInDomains(all, loads, domains, 4)
+	assert.Nil(t, err)
+	assert.EqualValues(t, []int{4, 5, 6, 7}, devices)
+	devices, err = pickInDomains(all, loads, domains, 8)
+	assert.Nil(t, err)
+	assert.Len(t, devices, 8)
+	_, err = pickInDomains([]int{2, 3, 4, 5}, loads, domains, 4)
+	assert.NotNil(t, err)
+	_, err = pickInDomains(all, loads, domains, 6)
+	assert.NotNil(t, err)
+}
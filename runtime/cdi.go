@@ -0,0 +1,217 @@
+/* Copyright(C) 2023. Huawei Technologies Co.,Ltd. All rights reserved.
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package main
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"gopkg.in/yaml.v3"
+	"huawei.com/npu-exporter/v5/common-utils/hwlog"
+
+	"ascendconfig"
+	"main/injector"
+	"mindxcheckutils"
+)
+
+const (
+	cdiCommand         = "cdi"
+	cdiGenerateCommand = "generate"
+	cdiVersion         = "0.5.0"
+	cdiSpecFilePath    = "/etc/cdi/ascend.yaml"
+	generatedDirMode   = 0755
+	generatedFileMode  = 0644
+)
+
+// cdiSpec is the subset of the CDI specification written for Ascend devices
+type cdiSpec struct {
+	Version        string            `yaml:"cdiVersion"`
+	Kind           string            `yaml:"kind"`
+	Devices        []cdiDevice       `yaml:"devices"`
+	ContainerEdits cdiContainerEdits `yaml:"containerEdits,omitempty"`
+}
+
+type cdiDevice struct {
+	Name           string            `yaml:"name"`
+	ContainerEdits cdiContainerEdits `yaml:"containerEdits"`
+}
+
+type cdiContainerEdits struct {
+	DeviceNodes []cdiDeviceNode `yaml:"deviceNodes,omitempty"`
+	Mounts      []cdiMount      `yaml:"mounts,omitempty"`
+}
+
+type cdiDeviceNode struct {
+	Path        string `yaml:"path"`
+	HostPath    string `yaml:"hostPath,omitempty"`
+	Type        string `yaml:"type,omitempty"`
+	Major       int64  `yaml:"major,omitempty"`
+	Minor       int64  `yaml:"minor,omitempty"`
+	Permissions string `yaml:"permissions,omitempty"`
+}
+
+type cdiMount struct {
+	HostPath      string   `yaml:"hostPath"`
+	ContainerPath string   `yaml:"containerPath"`
+	Options       []string `yaml:"options,omitempty"`
+}
+
+func newScratchSpec() *specs.Spec {
+	return &specs.Spec{
+		Process: &specs.Process{},
+		Linux: &specs.Linux{
+			Resources: &specs.LinuxResources{},
+		},
+	}
+}
+
+func toCDIDeviceNodes(devices []specs.LinuxDevice) []cdiDeviceNode {
+	nodes := make([]cdiDeviceNode, 0, len(devices))
+	for _, device := range devices {
+		hostPath := ascendconfig.HostPath(runtimeCfg.GetDevRoot(), device.Path)
+		if hostPath == device.Path {
+			hostPath = ""
+		}
+		nodes = append(nodes, cdiDeviceNode{
+			Path:        device.Path,
+			HostPath:    hostPath,
+			Type:        device.Type,
+			Major:       device.Major,
+			Minor:       device.Minor,
+			Permissions: "rw",
+		})
+	}
+	return nodes
+}
+
+func getCDIMounts() ([]cdiMount, error) {
+	fileMountList, dirMountList, err := ascendconfig.ReadMountConfig(ascendconfig.MountConfigDir,
+		ascendconfig.BaseMountConfig, runtimeCfg.GetDriverRoot())
+	if err != nil {
+		return nil, err
+	}
+	mounts := make([]cdiMount, 0, len(fileMountList)+len(dirMountList))
+	for _, mountPath := range append(fileMountList, dirMountList...) {
+		mounts = append(mounts, cdiMount{
+			HostPath:      ascendconfig.HostPath(runtimeCfg.GetDriverRoot(), mountPath),
+			ContainerPath: mountPath,
+			Options:       []string{"ro", "nosuid", "nodev", "bind"},
+		})
+	}
+	return mounts, nil
+}
+
+func generateCDISpec() (*cdiSpec, error) {
+	session := injector.NewSession(runtimeCfg)
+	defer session.Close()
+	npuDevices, err := session.GetNpuDevices()
+	if err != nil {
+		return nil, fmt.Errorf("failed to enumerate npu devices: %v", err)
+	}
+	sort.Slice(npuDevices, func(i, j int) bool { return npuDevices[i].PhyID < npuDevices[j].PhyID })
+
+	cdi := &cdiSpec{
+		Version: cdiVersion,
+		Kind:    injector.CDIKind,
+		Devices: make([]cdiDevice, 0, len(npuDevices)+1),
+	}
+	allSpec := newScratchSpec()
+	for _, npuDevice := range npuDevices {
+		deviceSpec := newScratchSpec()
+		dPath := injector.DevicePath + injector.DavinciName + strconv.Itoa(int(npuDevice.PhyID))
+		if err = injector.AddDeviceToSpec(deviceSpec, runtimeCfg.GetDevRoot(), dPath, injector.DavinciName); err != nil {
+			return nil, fmt.Errorf("failed to add davinci device: %v", err)
+		}
+		allSpec.Linux.Devices = append(allSpec.Linux.Devices, deviceSpec.Linux.Devices...)
+		cdi.Devices = append(cdi.Devices, cdiDevice{
+			Name:           strconv.Itoa(int(npuDevice.PhyID)),
+			ContainerEdits: cdiContainerEdits{DeviceNodes: toCDIDeviceNodes(deviceSpec.Linux.Devices)},
+		})
+	}
+	cdi.Devices = append(cdi.Devices, cdiDevice{
+		Name:           injector.CDIAllDevice,
+		ContainerEdits: cdiContainerEdits{DeviceNodes: toCDIDeviceNodes(allSpec.Linux.Devices)},
+	})
+
+	managerSpec := newScratchSpec()
+	if err = injector.AddManagerDevice(managerSpec, nil, runtimeCfg.GetDevRoot(), session); err != nil {
+		return nil, fmt.Errorf("failed to add manager device: %v", err)
+	}
+	cdi.ContainerEdits.DeviceNodes = toCDIDeviceNodes(managerSpec.Linux.Devices)
+	// LD_LIBRARY_PATH is not part of the spec, CDI would replace the one set by the image
+	if cdi.ContainerEdits.Mounts, err = getCDIMounts(); err != nil {
+		return nil, fmt.Errorf("failed to read driver mounts: %v", err)
+	}
+
+	return cdi, nil
+}
+
+func writeCDISpec(cdi *cdiSpec, specPath string) error {
+	content, err := yaml.Marshal(cdi)
+	if err != nil {
+		return fmt.Errorf("failed to marshal cdi spec: %v", err)
+	}
+	return writeGeneratedFile(specPath, content)
+}
+
+// writeGeneratedFile write a file generated for other container tools, it is readable by everyone
+func writeGeneratedFile(filePath string, content []byte) error {
+	if err := os.MkdirAll(filepath.Dir(filePath), generatedDirMode); err != nil {
+		return fmt.Errorf("failed to create dir of %s: %v", filePath, err)
+	}
+	if _, err := mindxcheckutils.RealDirChecker(filepath.Dir(filePath), true, false); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filePath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, generatedFileMode)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %v", filePath, err)
+	}
+	defer f.Close()
+	if _, err = f.Write(content); err != nil {
+		return fmt.Errorf("failed to write %s: %v", filePath, err)
+	}
+	return nil
+}
+
+func doCDIProcess(cdiArgs []string) error {
+	if len(cdiArgs) == 0 || cdiArgs[0] != cdiGenerateCommand {
+		return fmt.Errorf("usage: ascend-docker-runtime cdi generate [--output <file>]")
+	}
+	specPath := cdiSpecFilePath
+	for i, param := range cdiArgs {
+		if param == "--output" || param == "-o" {
+			if len(cdiArgs)-i <= 1 {
+				return fmt.Errorf("output option needs an argument")
+			}
+			specPath = cdiArgs[i+1]
+		}
+	}
+
+	cdi, err := generateCDISpec()
+	if err != nil {
+		return err
+	}
+	if err = writeCDISpec(cdi, specPath); err != nil {
+		return err
+	}
+	hwlog.RunLog.Infof("cdi spec with %d devices written to %s", len(cdi.Devices), specPath)
+	fmt.Printf("cdi spec written to %s\n", specPath)
+	return nil
+}
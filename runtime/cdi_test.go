@@ -0,0 +1,94 @@
+/* Copyright(C) 2023. Huawei Technologies Co.,Ltd. All rights reserved.
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package main
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/agiledragon/gomonkey/v2"
+	"github.com/containerd/containerd/oci"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+
+	"main/dcmi"
+	"main/injector"
+	"mindxcheckutils"
+)
+
+func stubCDITopology() *gomonkey.Patches {
+	stub := gomonkey.ApplyMethod(reflect.TypeOf(&dcmi.Session{}), "GetNpuDevices",
+		func(_ *dcmi.Session) ([]dcmi.NpuDevice, error) {
+			return []dcmi.NpuDevice{{PhyID: 1, LogicID: 1, CardID: 0, DeviceID: 1},
+				{PhyID: 0, LogicID: 0, CardID: 0, DeviceID: 0}}, nil
+		})
+	stub.ApplyFunc(oci.DeviceFromPath, func(dPath string) (*specs.LinuxDevice, error) {
+		return &specs.LinuxDevice{Path: dPath, Type: "c"}, nil
+	})
+	stub.ApplyFunc(injector.AddManagerDevice, func(spec *specs.Spec, deviceIDs []int, devRoot string,
+		session *dcmi.Session) error {
+		spec.Linux.Devices = append(spec.Linux.Devices, specs.LinuxDevice{Path: injector.DevicePath + injector.DavinciManager})
+		return nil
+	})
+	stub.ApplyFunc(getCDIMounts, func() ([]cdiMount, error) {
+		return []cdiMount{{HostPath: "/usr/local/dcmi", ContainerPath: "/usr/local/dcmi"}}, nil
+	})
+	return stub
+}
+
+func TestGenerateCDISpec(t *testing.T) {
+	stub := stubCDITopology()
+	defer stub.Reset()
+
+	cdi, err := generateCDISpec()
+	assert.Nil(t, err)
+	assert.EqualValues(t, injector.CDIKind, cdi.Kind)
+	assert.Len(t, cdi.Devices, 3)
+	assert.EqualValues(t, "0", cdi.Devices[0].Name)
+	assert.EqualValues(t, "/dev/davinci0", cdi.Devices[0].ContainerEdits.DeviceNodes[0].Path)
+	assert.EqualValues(t, injector.CDIAllDevice, cdi.Devices[2].Name)
+	assert.Len(t, cdi.Devices[2].ContainerEdits.DeviceNodes, 2)
+	assert.EqualValues(t, "/dev/davinci_manager", cdi.ContainerEdits.DeviceNodes[0].Path)
+	assert.Len(t, cdi.ContainerEdits.Mounts, 1)
+}
+
+func TestWriteCDISpec(t *testing.T) {
+	stub := stubCDITopology()
+	defer stub.Reset()
+	stub.ApplyFunc(mindxcheckutils.RealDirChecker, func(path string, checkParent, allowLink bool) (string, error) {
+		return path, nil
+	})
+
+	cdi, err := generateCDISpec()
+	assert.Nil(t, err)
+	specPath := filepath.Join(t.TempDir(), "cdi", "ascend.yaml")
+	assert.Nil(t, writeCDISpec(cdi, specPath))
+
+	content, err := ioutil.ReadFile(specPath)
+	assert.Nil(t, err)
+	var written cdiSpec
+	assert.Nil(t, yaml.Unmarshal(content, &written))
+	assert.EqualValues(t, *cdi, written)
+}
+
+func TestDoCDIProcess(t *testing.T) {
+	assert.NotNil(t, doCDIProcess([]string{}))
+	assert.NotNil(t, doCDIProcess([]string{"list"}))
+	assert.NotNil(t, doCDIProcess([]string{cdiGenerateCommand, "--output"}))
+}
@@ -0,0 +1,144 @@
+/* Copyright(C) 2023. Huawei Technologies Co.,Ltd. All rights reserved.
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package main
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
+
+const (
+	cgroupFieldNum        = 3
+	devicesController     = "devices"
+	devicesAllowFile      = "devices.allow"
+	devicesDenyFile       = "devices.deny"
+	deviceCgroupAccessAll = "rwm"
+	deviceCgroupAccessRW  = "rw"
+	wildcardID            = -1
+)
+
+var (
+	cgroupRoot = "/sys/fs/cgroup"
+	procRoot   = "/proc"
+)
+
+// deviceCgroup the device controller of a container, through devices.allow of cgroup v1 or the eBPF program
+// of cgroup v2
+type deviceCgroup struct {
+	path string
+	v2   bool
+}
+
+// getDeviceCgroup find the device controller of the process from /proc/<pid>/cgroup
+func getDeviceCgroup(pid int) (*deviceCgroup, error) {
+	content, err := ioutil.ReadFile(filepath.Join(procRoot, strconv.Itoa(pid), "cgroup"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cgroup of process %d: %v", pid, err)
+	}
+	return parseDeviceCgroup(string(content))
+}
+
+func parseDeviceCgroup(content string) (*deviceCgroup, error) {
+	unifiedPath := ""
+	for _, line := range strings.Split(content, "\n") {
+		// hierarchy-id:controller-list:cgroup-path
+		fields := strings.SplitN(line, ":", cgroupFieldNum)
+		if len(fields) != cgroupFieldNum {
+			continue
+		}
+		if fields[0] == "0" && fields[1] == "" {
+			unifiedPath = fields[2]
+			continue
+		}
+		for _, controller := range strings.Split(fields[1], ",") {
+			if controller == devicesController {
+				return &deviceCgroup{path: filepath.Join(cgroupRoot, devicesController, fields[2])}, nil
+			}
+		}
+	}
+	if unifiedPath == "" {
+		return nil, fmt.Errorf("no device cgroup is found")
+	}
+	return &deviceCgroup{path: filepath.Join(cgroupRoot, unifiedPath), v2: true}, nil
+}
+
+func writeCgroupFile(path, content string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %v", path, err)
+	}
+	defer f.Close()
+	if _, err = f.WriteString(content); err != nil {
+		return fmt.Errorf("failed to write %s to %s: %v", content, path, err)
+	}
+	return nil
+}
+
+// toCgroupRule the rule of devices.allow like c 236:1 rwm
+func toCgroupRule(rule specs.LinuxDeviceCgroup) string {
+	id := func(n *int64) string {
+		if n == nil || *n == wildcardID {
+			return "*"
+		}
+		return strconv.FormatInt(*n, 10)
+	}
+	return fmt.Sprintf("%s %s:%s %s", rule.Type, id(rule.Major), id(rule.Minor), rule.Access)
+}
+
+// defaultDeviceRules the devices that runc and crun allow in every container after the rules of the spec, the
+// program of cgroup v2 replaces theirs and must keep allowing them
+func defaultDeviceRules() []specs.LinuxDeviceCgroup {
+	rule := func(deviceType string, major, minor int64, access string) specs.LinuxDeviceCgroup {
+		return specs.LinuxDeviceCgroup{Allow: true, Type: deviceType, Major: &major, Minor: &minor, Access: access}
+	}
+	return []specs.LinuxDeviceCgroup{
+		rule("c", wildcardID, wildcardID, "m"),
+		rule("b", wildcardID, wildcardID, "m"),
+		// /dev/null, /dev/random, /dev/full, /dev/tty, /dev/zero, /dev/urandom
+		rule("c", 1, 3, deviceCgroupAccessAll),
+		rule("c", 1, 8, deviceCgroupAccessAll),
+		rule("c", 1, 7, deviceCgroupAccessAll),
+		rule("c", 5, 0, deviceCgroupAccessAll),
+		rule("c", 1, 5, deviceCgroupAccessAll),
+		rule("c", 1, 9, deviceCgroupAccessAll),
+		// /dev/pts/* and /dev/ptmx
+		rule("c", 136, wildcardID, deviceCgroupAccessAll),
+		rule("c", 5, 2, deviceCgroupAccessAll),
+	}
+}
+
+// apply make the rules take effect, rules are the whole rule set of the container and the last one is the new
+// rule, cgroup v1 takes only the new rule
+func (c *deviceCgroup) apply(rules []specs.LinuxDeviceCgroup) error {
+	if len(rules) == 0 {
+		return nil
+	}
+	if c.v2 {
+		return replaceDeviceFilter(c.path, rules)
+	}
+	rule := rules[len(rules)-1]
+	file := devicesDenyFile
+	if rule.Allow {
+		file = devicesAllowFile
+	}
+	return writeCgroupFile(filepath.Join(c.path, file), toCgroupRule(rule))
+}
@@ -21,6 +21,8 @@ import "C"
 import (
 	"fmt"
 	"math"
+	"net"
+	"strings"
 	"unsafe"
 
 	"mindxcheckutils"
@@ -41,6 +43,9 @@ const (
 
 	coreNumLen = 32
 	vfgID      = 4294967295 // vfg_id表示指定虚拟设备所属的虚拟分组ID，默认自动分配，默认值为0xFFFFFFFF，转换成10进制为4294967295。
+
+	// maxErrorCodeCount max number of error codes reported by a device
+	maxErrorCodeCount = 128
 )
 
 // ChipInfo chip info
@@ -86,6 +91,15 @@ func convertUCharToCharArr(cgoArr [maxChipNameLen]C.uchar) []byte {
 	return charArr
 }
 
+// goStringN convert a char array filled by dcmi to string, the array is not always terminated by NUL
+func goStringN(buf *C.char, size int) string {
+	str := C.GoStringN(buf, C.int(size))
+	if end := strings.IndexByte(str, 0); end >= 0 {
+		str = str[:end]
+	}
+	return str
+}
+
 // Initialize dcmi lib init
 func (w *NpuWorker) Initialize() error {
 	cDlPath := C.CString(string(make([]byte, int32(C.PATH_MAX))))
@@ -167,6 +181,22 @@ func GetDeviceLogicID(cardID, deviceID int32) (int32, error) {
 	return int32(logicID), nil
 }
 
+// GetDevicePhyIDFromLogicID get device phyID by logicID
+func GetDevicePhyIDFromLogicID(logicID int32) (int32, error) {
+	var phyID C.uint
+	if err := C.dcmi_get_device_phyid_from_logicid(C.uint(logicID), &phyID); err != 0 {
+		errInfo := fmt.Errorf("get phyID failed, error code: %d", int32(err))
+		return retError, errInfo
+	}
+
+	// check whether phyID is too big
+	if uint32(phyID) > uint32(math.MaxInt8) {
+		errInfo := fmt.Errorf("the phyID value is invalid, phyID is: %d", uint32(phyID))
+		return retError, errInfo
+	}
+	return int32(phyID), nil
+}
+
 // CreateVDevice create virtual device
 func (w *NpuWorker) CreateVDevice(cardID, deviceID int32, coreNum string) (int32, error) {
 	var createInfo C.struct_dcmi_create_vdev_out
@@ -252,7 +282,7 @@ func (w *NpuWorker) GetProductType(cardID, deviceID int32) (string, error) {
 		}
 		return "", fmt.Errorf("get product type failed, errCode: %d", err)
 	}
-	return C.GoString(cProductType), nil
+	return goStringN(cProductType, productTypeLen), nil
 }
 
 // GetChipInfo get the chip info by cardID and deviceID
@@ -282,3 +312,112 @@ func (w *NpuWorker) GetChipInfo(cardID, deviceID int32) (*ChipInfo, error) {
 
 	return chip, nil
 }
+
+// GetDeviceSerialNumber get the serial number in the electronic label of the device
+func (w *NpuWorker) GetDeviceSerialNumber(cardID, deviceID int32) (string, error) {
+	if !isValidCardIDAndDeviceID(cardID, deviceID) {
+		return "", fmt.Errorf("cardID(%d) or deviceID(%d) is invalid", cardID, deviceID)
+	}
+	var elabelInfo C.struct_dcmi_elabel_info
+	if rCode := C.dcmi_get_device_elabel_info(C.int(cardID), C.int(deviceID), &elabelInfo); int32(rCode) != 0 {
+		return "", fmt.Errorf("get device elabel information failed, cardID(%d), deviceID(%d),"+
+			" error code: %d", cardID, deviceID, int32(rCode))
+	}
+	return goStringN(&elabelInfo.serial_number[0], len(elabelInfo.serial_number)), nil
+}
+
+// GetDevicePCIBusID get the pci bus id of the device, formatted as domain:bus:device.function
+func (w *NpuWorker) GetDevicePCIBusID(cardID, deviceID int32) (string, error) {
+	if !isValidCardIDAndDeviceID(cardID, deviceID) {
+		return "", fmt.Errorf("cardID(%d) or deviceID(%d) is invalid", cardID, deviceID)
+	}
+	var pcieInfo C.struct_dcmi_pcie_info_all
+	if rCode := C.dcmi_get_device_pcie_info(C.int(cardID), C.int(deviceID), &pcieInfo); int32(rCode) != 0 {
+		return "", fmt.Errorf("get device pcie information failed, cardID(%d), deviceID(%d),"+
+			" error code: %d", cardID, deviceID, int32(rCode))
+	}
+	return fmt.Sprintf("%04x:%02x:%02x.%x", uint32(pcieInfo.domain), uint32(pcieInfo.bdf_busid),
+		uint32(pcieInfo.bdf_deviceid), uint32(pcieInfo.bdf_funcid)), nil
+}
+
+// GetDeviceBoardID get the board id of the device
+func (w *NpuWorker) GetDeviceBoardID(cardID, deviceID int32) (uint32, error) {
+	if !isValidCardIDAndDeviceID(cardID, deviceID) {
+		return 0, fmt.Errorf("cardID(%d) or deviceID(%d) is invalid", cardID, deviceID)
+	}
+	var boardInfo C.struct_dcmi_board_info
+	if rCode := C.dcmi_get_device_board_info(C.int(cardID), C.int(deviceID), &boardInfo); int32(rCode) != 0 {
+		return 0, fmt.Errorf("get device board information failed, cardID(%d), deviceID(%d),"+
+			" error code: %d", cardID, deviceID, int32(rCode))
+	}
+	return uint32(boardInfo.board_id), nil
+}
+
+// GetDeviceHealth get the health state of the device, 0 means healthy and a larger one means a severer alarm
+func (w *NpuWorker) GetDeviceHealth(cardID, deviceID int32) (uint32, error) {
+	if !isValidCardIDAndDeviceID(cardID, deviceID) {
+		return 0, fmt.Errorf("cardID(%d) or deviceID(%d) is invalid", cardID, deviceID)
+	}
+	var health C.uint
+	if rCode := C.dcmi_get_device_health(C.int(cardID), C.int(deviceID), &health); int32(rCode) != 0 {
+		return 0, fmt.Errorf("get device health failed, cardID(%d), deviceID(%d), error code: %d",
+			cardID, deviceID, int32(rCode))
+	}
+	return uint32(health), nil
+}
+
+// GetDeviceErrorCodes get the error codes currently reported by the device
+func (w *NpuWorker) GetDeviceErrorCodes(cardID, deviceID int32) ([]uint32, error) {
+	if !isValidCardIDAndDeviceID(cardID, deviceID) {
+		return nil, fmt.Errorf("cardID(%d) or deviceID(%d) is invalid", cardID, deviceID)
+	}
+	var errorCount C.int
+	var errorCodes [maxErrorCodeCount]C.uint
+	if rCode := C.dcmi_get_device_errorcode_v2(C.int(cardID), C.int(deviceID), &errorCount, &errorCodes[0],
+		maxErrorCodeCount); int32(rCode) != 0 {
+		return nil, fmt.Errorf("get device error code failed, cardID(%d), deviceID(%d), error code: %d",
+			cardID, deviceID, int32(rCode))
+	}
+	if errorCount < 0 || errorCount > maxErrorCodeCount {
+		return nil, fmt.Errorf("invalid error code count %d, cardID(%d), deviceID(%d)", errorCount, cardID,
+			deviceID)
+	}
+	codes := make([]uint32, 0, errorCount)
+	for i := 0; i < int(errorCount); i++ {
+		codes = append(codes, uint32(errorCodes[i]))
+	}
+	return codes, nil
+}
+
+// GetDeviceIP get the ip of the roce port of the device, which is used by hccl
+func (w *NpuWorker) GetDeviceIP(cardID, deviceID int32) (string, error) {
+	if !isValidCardIDAndDeviceID(cardID, deviceID) {
+		return "", fmt.Errorf("cardID(%d) or deviceID(%d) is invalid", cardID, deviceID)
+	}
+	var ip, mask C.struct_dcmi_ip_addr
+	if rCode := C.dcmi_get_device_ip(C.int(cardID), C.int(deviceID), C.DCMI_ROCE_PORT, 0, &ip,
+		&mask); int32(rCode) != 0 {
+		return "", fmt.Errorf("get device ip failed, cardID(%d), deviceID(%d), error code: %d", cardID,
+			deviceID, int32(rCode))
+	}
+	addr := C.GoBytes(unsafe.Pointer(&ip.u_addr[0]), C.DCMI_IPV6_LEN)
+	if ip.ip_type == C.DCMI_IPADDR_TYPE_V4 {
+		return net.IP(addr[:net.IPv4len]).String(), nil
+	}
+	return net.IP(addr).String(), nil
+}
+
+// GetTopologyType get how two devices are connected, like TopologyHCCS
+func (w *NpuWorker) GetTopologyType(cardID1, deviceID1, cardID2, deviceID2 int32) (int32, error) {
+	if !isValidCardIDAndDeviceID(cardID1, deviceID1) || !isValidCardIDAndDeviceID(cardID2, deviceID2) {
+		return 0, fmt.Errorf("cardID(%d, %d) or deviceID(%d, %d) is invalid", cardID1, cardID2, deviceID1,
+			deviceID2)
+	}
+	var topoType C.int
+	if rCode := C.dcmi_get_topo_info_by_device_id(C.int(cardID1), C.int(deviceID1), C.int(cardID2),
+		C.int(deviceID2), &topoType); int32(rCode) != 0 {
+		return 0, fmt.Errorf("get topology failed, cardID(%d, %d), deviceID(%d, %d), error code: %d", cardID1,
+			cardID2, deviceID1, deviceID2, int32(rCode))
+	}
+	return int32(topoType), nil
+}
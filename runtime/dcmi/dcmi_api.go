@@ -17,6 +17,7 @@ package dcmi
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 
 	"github.com/opencontainers/runtime-spec/specs-go"
@@ -24,6 +25,23 @@ import (
 	"huawei.com/npu-exporter/v5/common-utils/hwlog"
 )
 
+const (
+	// VnpuSpecsEnv ENV requesting a vnpu created on the only requested device
+	VnpuSpecsEnv = "ASCEND_VNPU_SPECS"
+	// VnpuSpecsAnnotation annotation equivalent to ASCEND_VNPU_SPECS, it takes precedence over ENV
+	VnpuSpecsAnnotation = "huawei.com/ascend.vnpu-specs"
+)
+
+// kinds of stable device identifier, used as prefix like serial:XXXX
+const (
+	// IdentifierSerial serial number in the electronic label
+	IdentifierSerial = "serial"
+	// IdentifierPCI pci bus id like 0000:81:00.0
+	IdentifierPCI = "pci"
+	// IdentifierBoard board id, all devices on matched boards are selected
+	IdentifierBoard = "board"
+)
+
 // VDeviceInfo vdevice created info
 type VDeviceInfo struct {
 	CardID    int32
@@ -31,6 +49,14 @@ type VDeviceInfo struct {
 	VdeviceID int32
 }
 
+// NpuDevice location of a davinci device in the card/device topology
+type NpuDevice struct {
+	PhyID    int32 `json:"phy_id"`
+	LogicID  int32 `json:"logic_id"`
+	CardID   int32 `json:"card_id"`
+	DeviceID int32 `json:"device_id"`
+}
+
 // WorkerInterface worker interface
 type WorkerInterface interface {
 	Initialize() error
@@ -40,38 +66,13 @@ type WorkerInterface interface {
 	DestroyVDevice(cardID, deviceID int32, vDevID int32) error
 	GetProductType(cardID, deviceID int32) (string, error)
 	GetChipInfo(cardID, deviceID int32) (*ChipInfo, error)
-}
-
-// CreateVDevice will create virtual device
-func CreateVDevice(w WorkerInterface, spec *specs.Spec, devices []int) (VDeviceInfo, error) {
-	invalidVDevice := VDeviceInfo{CardID: -1, DeviceID: -1, VdeviceID: -1}
-	splitDevice, err := extractVpuParam(spec)
-	if err != nil {
-		return invalidVDevice, err
-	}
-	if splitDevice == "" {
-		return invalidVDevice, nil
-	}
-	if len(devices) != 1 || devices[0] < 0 || devices[0] >= hiAIMaxCardNum*hiAIMaxDeviceNum {
-		hwlog.RunLog.Errorf("invalid devices: %v", devices)
-		return invalidVDevice, fmt.Errorf("invalid devices: %v", devices)
-	}
-
-	if err := w.Initialize(); err != nil {
-		return invalidVDevice, fmt.Errorf("cannot init dcmi : %v", err)
-	}
-	defer w.ShutDown()
-	targetDeviceID, targetCardID, err := w.FindDevice(int32(devices[0]))
-	if err != nil {
-		return invalidVDevice, err
-	}
-
-	vdeviceID, err := w.CreateVDevice(targetCardID, targetDeviceID, splitDevice)
-	if err != nil || vdeviceID < 0 {
-		hwlog.RunLog.Errorf("cannot create vd or vdevice is wrong: %v %v", vdeviceID, err)
-		return invalidVDevice, err
-	}
-	return VDeviceInfo{CardID: targetCardID, DeviceID: targetDeviceID, VdeviceID: vdeviceID}, nil
+	GetDeviceSerialNumber(cardID, deviceID int32) (string, error)
+	GetDevicePCIBusID(cardID, deviceID int32) (string, error)
+	GetDeviceBoardID(cardID, deviceID int32) (uint32, error)
+	GetDeviceHealth(cardID, deviceID int32) (uint32, error)
+	GetDeviceErrorCodes(cardID, deviceID int32) ([]uint32, error)
+	GetTopologyType(cardID1, deviceID1, cardID2, deviceID2 int32) (int32, error)
+	GetDeviceIP(cardID, deviceID int32) (string, error)
 }
 
 func extractVpuParam(spec *specs.Spec) (string, error) {
@@ -85,13 +86,20 @@ func extractVpuParam(spec *specs.Spec) (string, error) {
 		"vir10_4c_16g_m": "vir10_4c_16g_m", "vir12_3c_32g": "vir12_3c_32g",
 	}
 
+	if value, ok := spec.Annotations[VnpuSpecsAnnotation]; ok {
+		if split, ok := allowSplit[value]; ok && split != "" {
+			return split, nil
+		}
+		return "", fmt.Errorf("cannot parse param : %v", value)
+	}
+
 	for _, line := range spec.Process.Env {
 		words := strings.Split(line, "=")
 		const LENGTH int = 2
 		if len(words) != LENGTH {
 			continue
 		}
-		if strings.TrimSpace(words[0]) == "ASCEND_VNPU_SPECS" {
+		if strings.TrimSpace(words[0]) == VnpuSpecsEnv {
 			if split, ok := allowSplit[words[1]]; ok && split != "" {
 				return split, nil
 			}
@@ -101,83 +109,88 @@ func extractVpuParam(spec *specs.Spec) (string, error) {
 	return "", nil
 }
 
-// GetProductType get type of product
-func GetProductType(w WorkerInterface) (string, error) {
-	invalidType := ""
-	if err := w.Initialize(); err != nil {
-		return invalidType, fmt.Errorf("cannot init dcmi : %v", err)
-	}
-	defer w.ShutDown()
-
-	cardNum, cardList, err := GetCardList()
-	if cardNum == 0 || err != nil {
+func listNpuDevices() ([]NpuDevice, error) {
+	_, cardList, err := GetCardList()
+	if err != nil {
 		hwlog.RunLog.Errorf("failed to get card list, err: %#v", err)
-		return invalidType, err
+		return nil, err
 	}
+
+	devices := make([]NpuDevice, 0, len(cardList))
 	for _, cardID := range cardList {
 		devNum, err := GetDeviceNumInCard(cardID)
 		if err != nil {
-			hwlog.RunLog.Debugf("get device num by cardID(%d) failed, error: %#v", cardID, err)
-			continue
-		}
-		if devNum == 0 {
-			hwlog.RunLog.Debugf("not found device on card %d", cardID)
-			continue
+			return nil, fmt.Errorf("cannot get device num in card %d : %v", cardID, err)
 		}
 		for devID := int32(0); devID < devNum; devID++ {
-			productType, err := w.GetProductType(cardID, devID)
+			logicID, err := GetDeviceLogicID(cardID, devID)
 			if err != nil {
-				hwlog.RunLog.Debugf("get product type by card %d deviceID %d failed, err: %#v", cardID, devID, err)
-				continue
+				return nil, fmt.Errorf("cannot get logic id of card %d device %d : %v", cardID, devID, err)
 			}
-			return productType, nil
+			phyID, err := GetDevicePhyIDFromLogicID(logicID)
+			if err != nil {
+				return nil, fmt.Errorf("cannot get phy id of logic id %d : %v", logicID, err)
+			}
+			devices = append(devices, NpuDevice{PhyID: phyID, LogicID: logicID, CardID: cardID, DeviceID: devID})
 		}
 	}
 
-	return invalidType, nil
+	return devices, nil
 }
 
-// GetChipName get name of chip
-func GetChipName() (string, error) {
-	dcWorker := NpuWorker{}
-	invalidName := ""
-
-	if err := dcWorker.Initialize(); err != nil {
-		return invalidName, fmt.Errorf("cannot init dcmi : %v", err)
+func normalizePCIBusID(busID string) string {
+	busID = strings.ToLower(strings.TrimSpace(busID))
+	const busIDWithoutDomainParts = 2
+	if len(strings.Split(busID, ":")) == busIDWithoutDomainParts {
+		busID = "0000:" + busID
 	}
-	defer dcWorker.ShutDown()
+	return busID
+}
 
-	cardNum, cardList, err := GetCardList()
-	if err != nil {
-		hwlog.RunLog.Errorf("failed to get card list, err: %#v", err)
-		return invalidName, err
-	}
-	if cardNum == 0 {
-		return invalidName, fmt.Errorf("get chip info failed, no card found")
+func matchDeviceIdentifier(w WorkerInterface, kind, value string, device NpuDevice) (bool, error) {
+	switch kind {
+	case IdentifierSerial:
+		serial, err := w.GetDeviceSerialNumber(device.CardID, device.DeviceID)
+		return err == nil && serial == value, err
+	case IdentifierPCI:
+		busID, err := w.GetDevicePCIBusID(device.CardID, device.DeviceID)
+		return err == nil && normalizePCIBusID(busID) == normalizePCIBusID(value), err
+	case IdentifierBoard:
+		expectID, err := strconv.ParseUint(value, 0, 32)
+		if err != nil {
+			return false, fmt.Errorf("invalid board id: %s", value)
+		}
+		boardID, err := w.GetDeviceBoardID(device.CardID, device.DeviceID)
+		return err == nil && boardID == uint32(expectID), err
+	default:
+		return false, fmt.Errorf("unknown device identifier kind: %s", kind)
 	}
+}
 
-	// get device in card, then get chip info by cardID and deviceID
-	for _, cardID := range cardList {
-		devNum, err := GetDeviceNumInCard(cardID)
-		if err != nil || devNum == 0 {
-			hwlog.RunLog.Warnf("get device num by cardID(%d) failed, error: %#v", cardID, err)
-			continue
+func matchDeviceIdentifiers(w WorkerInterface, identifiers []string, devices []NpuDevice) (map[string][]int32,
+	error) {
+	const identifierParts = 2
+	resolved := make(map[string][]int32, len(identifiers))
+	for _, identifier := range identifiers {
+		words := strings.SplitN(identifier, ":", identifierParts)
+		if len(words) != identifierParts || words[1] == "" {
+			return nil, fmt.Errorf("invalid device identifier: %s", identifier)
 		}
-		for devID := int32(0); devID < devNum; devID++ {
-			chipInfo, err := dcWorker.GetChipInfo(cardID, devID)
+		phyIDs := make([]int32, 0)
+		for _, device := range devices {
+			matched, err := matchDeviceIdentifier(w, words[0], words[1], device)
 			if err != nil {
-				hwlog.RunLog.Warnf("get chip info failed by cardID(%d), deviceID(%d), error: %#v", cardID, devID,
-					err)
-				continue
+				return nil, fmt.Errorf("cannot resolve %s on card %d device %d : %v", identifier, device.CardID,
+					device.DeviceID, err)
 			}
-			if !isValidChipInfo(chipInfo) {
-				hwlog.RunLog.Warnf("invalid chip info by cardID(%d), deviceID(%d), error: %#v", cardID, devID,
-					err)
-				continue
+			if matched {
+				phyIDs = append(phyIDs, device.PhyID)
 			}
-			return (*chipInfo).Name, nil
 		}
+		if len(phyIDs) == 0 {
+			return nil, fmt.Errorf("no device matches %s", identifier)
+		}
+		resolved[identifier] = phyIDs
 	}
-
-	return invalidName, fmt.Errorf("cannot get valid chip info")
+	return resolved, nil
 }
@@ -16,6 +16,8 @@
 package dcmi
 
 import (
+	"errors"
+	"fmt"
 	"testing"
 
 	"github.com/opencontainers/runtime-spec/specs-go"
@@ -23,21 +25,31 @@ import (
 
 const mockDeviceID = 100
 
-type mockWorker struct{}
+// errNotInitialized the functions of the dcmi lib are not loaded before Initialize
+var errNotInitialized = errors.New("dcmi function is not found")
+
+type mockWorker struct {
+	initCount     int
+	shutDownCount int
+	chipInfoCount int
+}
 
 func (w *mockWorker) Initialize() error {
+	w.initCount++
 	return nil
 }
 
 // ShutDown shutdown mock lib
 func (w *mockWorker) ShutDown() {
-	return
+	w.shutDownCount++
 }
 
 // CreateVDevice create v device
 func (w *mockWorker) CreateVDevice(_, _ int32, _ string) (int32, error) {
-
-	return int32(mockDeviceId), nil
+	if w.initCount == 0 {
+		return -1, errNotInitialized
+	}
+	return int32(mockDeviceID), nil
 }
 
 // DestroyVDevice destroy virtual device
@@ -50,33 +62,220 @@ func (w *mockWorker) FindDevice(_ int32) (int32, int32, error) {
 	return 0, 0, nil
 }
 
+// GetProductType get product type
+func (w *mockWorker) GetProductType(_, _ int32) (string, error) {
+	return "", nil
+}
+
+// GetChipInfo get chip info
+func (w *mockWorker) GetChipInfo(_, _ int32) (*ChipInfo, error) {
+	w.chipInfoCount++
+	return &ChipInfo{Name: "910B"}, nil
+}
+
+// GetDeviceSerialNumber get serial number
+func (w *mockWorker) GetDeviceSerialNumber(cardID, deviceID int32) (string, error) {
+	if w.initCount == 0 {
+		return "", errNotInitialized
+	}
+	return fmt.Sprintf("SN%d%d", cardID, deviceID), nil
+}
+
+// GetDevicePCIBusID get pci bus id
+func (w *mockWorker) GetDevicePCIBusID(cardID, deviceID int32) (string, error) {
+	if w.initCount == 0 {
+		return "", errNotInitialized
+	}
+	return fmt.Sprintf("0000:%02x:00.%x", cardID+0x81, deviceID), nil
+}
+
+// GetDeviceBoardID get board id
+func (w *mockWorker) GetDeviceBoardID(_, _ int32) (uint32, error) {
+	if w.initCount == 0 {
+		return 0, errNotInitialized
+	}
+	return 0x20, nil
+}
+
+// GetDeviceHealth get health, device 1 reports a major alarm
+func (w *mockWorker) GetDeviceHealth(_, deviceID int32) (uint32, error) {
+	if deviceID == 1 {
+		return HealthMajorAlarm, nil
+	}
+	return HealthOK, nil
+}
+
+// GetDeviceErrorCodes get error codes
+func (w *mockWorker) GetDeviceErrorCodes(_, _ int32) ([]uint32, error) {
+	return []uint32{0x80e01801}, nil
+}
+
+// GetTopologyType get topology, devices 0-3 and 4-7 form two HCCS rings
+func (w *mockWorker) GetTopologyType(_, deviceID1, _, deviceID2 int32) (int32, error) {
+	const ringSize = 4
+	if deviceID1/ringSize == deviceID2/ringSize {
+		return TopologyHCCS, nil
+	}
+	return TopologySYS, nil
+}
+
+// GetDeviceIP get ip, like 192.168.100.101 of device 1
+func (w *mockWorker) GetDeviceIP(_, deviceID int32) (string, error) {
+	return fmt.Sprintf("192.168.100.%d", deviceID+100), nil
+}
+
 func TestCreateVDevice(t *testing.T) {
 	t.Log("TestCreateVDevice start")
 	process := specs.Process{}
 	spec := specs.Spec{Process: &process}
 	spec.Process.Env = []string{}
 
+	session := NewSession(&mockWorker{})
+	session.devices = []NpuDevice{{PhyID: 0, CardID: 0, DeviceID: 0}}
 	// no split, all ok
-	vdevice, err := CreateVDevice(&mockWorker{}, &spec)
+	vdevice, err := session.CreateVDevice(&spec, []int{})
 	if err != nil {
 		t.Fatalf("%v %v", vdevice, err)
 	}
 
 	// no npu assigin for split
 	spec.Process.Env = []string{"ASCEND_VNPU_SPECS=vir04"}
-	vdevice, err = CreateVDevice(&mockWorker{}, &spec)
+	vdevice, err = session.CreateVDevice(&spec, []int{})
 	if err == nil {
 		t.Fatalf("%v %v", vdevice, err)
 	}
 
 	// split ok
 	spec.Process.Env = []string{"ASCEND_VNPU_SPECS=vir04", "ASCEND_VISIBLE_DEVICES=0"}
-	vdevice, err = CreateVDevice(&mockWorker{}, &spec)
+	vdevice, err = session.CreateVDevice(&spec, []int{0})
 	if err != nil {
 		t.Fatalf("%v %v", vdevice, err)
 	}
-	if vdevice.VdeviceID != mockDeviceId {
+	if vdevice.VdeviceID != mockDeviceID {
 		t.Fatalf("%v %v", vdevice, err)
 	}
+}
+
+func TestExtractVpuParamFromAnnotation(t *testing.T) {
+	spec := specs.Spec{
+		Process:     &specs.Process{Env: []string{"ASCEND_VNPU_SPECS=vir02"}},
+		Annotations: map[string]string{VnpuSpecsAnnotation: "vir04"},
+	}
+	split, err := extractVpuParam(&spec)
+	if err != nil || split != "vir04" {
+		t.Fatalf("%v %v", split, err)
+	}
+
+	spec.Annotations[VnpuSpecsAnnotation] = "vir99"
+	if split, err = extractVpuParam(&spec); err == nil {
+		t.Fatalf("%v %v", split, err)
+	}
+}
+
+func TestMatchDeviceIdentifiers(t *testing.T) {
+	devices := []NpuDevice{{PhyID: 0, CardID: 0, DeviceID: 0}, {PhyID: 1, CardID: 0, DeviceID: 1},
+		{PhyID: 4, CardID: 1, DeviceID: 0}}
+	worker := &mockWorker{initCount: 1}
+	resolved, err := matchDeviceIdentifiers(worker,
+		[]string{"serial:SN01", "pci:82:00.0", "pci:0000:81:00.1", "board:0x20"}, devices)
+	if err != nil {
+		t.Fatalf("%v %v", resolved, err)
+	}
+	if fmt.Sprint(resolved["serial:SN01"]) != "[1]" || fmt.Sprint(resolved["pci:82:00.0"]) != "[4]" ||
+		fmt.Sprint(resolved["pci:0000:81:00.1"]) != "[1]" || fmt.Sprint(resolved["board:0x20"]) != "[0 1 4]" {
+		t.Fatalf("%v", resolved)
+	}
+
+	for _, identifier := range []string{"serial:UNKNOWN", "uuid:SN01", "board:abc", "serial:"} {
+		if resolved, err = matchDeviceIdentifiers(worker, []string{identifier}, devices); err == nil {
+			t.Fatalf("%s %v", identifier, resolved)
+		}
+	}
+}
+
+func TestSessionInitializeOnce(t *testing.T) {
+	worker := &mockWorker{}
+	session := NewSession(worker)
+	session.Close()
+	if worker.shutDownCount != 0 {
+		t.Fatalf("dcmi is shut down without initializing")
+	}
+	// no dcmi lib is loaded, every query fails after initializing
+	for i := 0; i < 3; i++ {
+		if _, err := session.GetChipName(); err == nil {
+			t.Fatalf("query should fail without dcmi lib")
+		}
+	}
+	session.Close()
+	session.Close()
+	if worker.initCount != 1 || worker.shutDownCount != 1 {
+		t.Fatalf("init %d times, shut down %d times", worker.initCount, worker.shutDownCount)
+	}
+}
+
+func TestSessionCachedQueries(t *testing.T) {
+	worker := &mockWorker{}
+	session := NewSession(worker)
+	session.devices = []NpuDevice{{PhyID: 2, CardID: 1, DeviceID: 0}, {PhyID: 3, CardID: 1, DeviceID: 1}}
+	for i := 0; i < 3; i++ {
+		if name, err := session.GetChipName(); err != nil || name != "910B" {
+			t.Fatalf("%v %v", name, err)
+		}
+	}
+	chipNames, err := session.GetDeviceChipNames()
+	if err != nil || len(chipNames) != 2 || chipNames[2] != "910B" || chipNames[3] != "910B" {
+		t.Fatalf("%v %v", chipNames, err)
+	}
+	// every device is queried once
+	if worker.chipInfoCount != 2 {
+		t.Fatalf("chip info is queried %d times", worker.chipInfoCount)
+	}
+
+	cardID, deviceID, err := session.findDevice(3)
+	if err != nil || cardID != 1 || deviceID != 1 {
+		t.Fatalf("%v %v %v", cardID, deviceID, err)
+	}
+	if _, _, err = session.findDevice(0); err == nil {
+		t.Fatalf("phy id 0 should not be found")
+	}
+	if worker.initCount != 0 {
+		t.Fatalf("cached topology should not initialize dcmi")
+	}
+}
+
+func TestSessionGetDeviceHealth(t *testing.T) {
+	worker := &mockWorker{}
+	session := NewSession(worker)
+	session.devices = []NpuDevice{{PhyID: 0, CardID: 0, DeviceID: 0}, {PhyID: 1, CardID: 0, DeviceID: 1}}
+	health, err := session.GetDeviceHealth(0)
+	if err != nil || !health.IsHealthy() || len(health.ErrorCodes) != 0 {
+		t.Fatalf("%v %v", health, err)
+	}
+	health, err = session.GetDeviceHealth(1)
+	if err != nil || health.IsHealthy() || health.String() != "state 2, error codes [0x80e01801]" {
+		t.Fatalf("%v %v", health, err)
+	}
+	if _, err = session.GetDeviceHealth(2); err == nil {
+		t.Fatalf("health of unknown device should fail")
+	}
+	if worker.initCount != 1 {
+		t.Fatalf("dcmi is initialized %d times", worker.initCount)
+	}
+}
 
+func TestSessionGetHCCSDomains(t *testing.T) {
+	const deviceCount = 8
+	session := NewSession(&mockWorker{})
+	for i := int32(0); i < deviceCount; i++ {
+		session.devices = append(session.devices, NpuDevice{PhyID: i, CardID: i, DeviceID: i})
+	}
+	domains, err := session.GetHCCSDomains()
+	if err != nil || fmt.Sprint(domains) != "[[0 1 2 3] [4 5 6 7]]" {
+		t.Fatalf("%v %v", domains, err)
+	}
+	// the result is a copy
+	domains[0][0] = deviceCount
+	if domains, err = session.GetHCCSDomains(); err != nil || domains[0][0] != 0 {
+		t.Fatalf("%v %v", domains, err)
+	}
 }
@@ -59,6 +59,56 @@ unsigned char chip_ver[MAX_CHIP_NAME_LEN];
 unsigned int aicore_cnt;
 };
 
+#define MAX_ELABEL_LEN (256)
+struct dcmi_elabel_info {
+    char product_name[MAX_ELABEL_LEN];
+    char model[MAX_ELABEL_LEN];
+    char manufacturer[MAX_ELABEL_LEN];
+    char manufacturer_date[MAX_ELABEL_LEN];
+    char serial_number[MAX_ELABEL_LEN];
+};
+
+struct dcmi_pcie_info_all {
+    unsigned int venderid;
+    unsigned int subvenderid;
+    unsigned int deviceid;
+    unsigned int subdeviceid;
+    int domain;
+    unsigned int bdf_busid;
+    unsigned int bdf_deviceid;
+    unsigned int bdf_funcid;
+    unsigned char reserve[32];
+};
+
+struct dcmi_board_info {
+    unsigned int board_id;
+    unsigned int pcb_id;
+    unsigned int bom_id;
+    unsigned int slot_id;
+};
+
+#define DCMI_IPV6_LEN (16)
+#define DCMI_IPV4_LEN (4)
+enum dcmi_port_type {
+    DCMI_VNIC_PORT = 0,
+    DCMI_ROCE_PORT = 1,
+    DCMI_INVALID_PORT
+};
+
+enum dcmi_ip_addr_type {
+    DCMI_IPADDR_TYPE_V4 = 0,
+    DCMI_IPADDR_TYPE_V6 = 1,
+    DCMI_IPADDR_TYPE_ANY = 2
+};
+
+struct dcmi_ip_addr {
+    union {
+        unsigned char ip6[DCMI_IPV6_LEN];
+        unsigned char ip4[DCMI_IPV4_LEN];
+    } u_addr;
+    enum dcmi_ip_addr_type ip_type;
+};
+
 // dcmi
 int (*dcmi_init_func)();
 int dcmi_init()
@@ -106,6 +156,12 @@ int dcmi_get_device_logicid_from_phyid(unsigned int phyid, unsigned int *logicid
     CALL_FUNC(dcmi_get_device_logicid_from_phyid, phyid, logicid);
 }
 
+int (*dcmi_get_device_phyid_from_logicid_func)(unsigned int logicid, unsigned int *phyid);
+int dcmi_get_device_phyid_from_logicid(unsigned int logicid, unsigned int *phyid)
+{
+    CALL_FUNC(dcmi_get_device_phyid_from_logicid, logicid, phyid);
+}
+
 int (*dcmi_get_product_type_func)(int card_id, int device_id, char *product_type_str, int buf_size);
 int dcmi_get_product_type(int card_id, int device_id, char *product_type_str, int buf_size)
 {
@@ -118,6 +174,53 @@ int dcmi_get_device_chip_info(int card_id, int device_id, struct dcmi_chip_info
     CALL_FUNC(dcmi_get_device_chip_info, card_id, device_id, chip_info);
 }
 
+int (*dcmi_get_device_elabel_info_func)(int card_id, int device_id, struct dcmi_elabel_info *elabel_info);
+int dcmi_get_device_elabel_info(int card_id, int device_id, struct dcmi_elabel_info *elabel_info)
+{
+    CALL_FUNC(dcmi_get_device_elabel_info, card_id, device_id, elabel_info);
+}
+
+int (*dcmi_get_device_pcie_info_func)(int card_id, int device_id, struct dcmi_pcie_info_all *pcie_info);
+int dcmi_get_device_pcie_info(int card_id, int device_id, struct dcmi_pcie_info_all *pcie_info)
+{
+    CALL_FUNC(dcmi_get_device_pcie_info, card_id, device_id, pcie_info);
+}
+
+int (*dcmi_get_device_board_info_func)(int card_id, int device_id, struct dcmi_board_info *board_info);
+int dcmi_get_device_board_info(int card_id, int device_id, struct dcmi_board_info *board_info)
+{
+    CALL_FUNC(dcmi_get_device_board_info, card_id, device_id, board_info);
+}
+
+int (*dcmi_get_device_health_func)(int card_id, int device_id, unsigned int *health);
+int dcmi_get_device_health(int card_id, int device_id, unsigned int *health)
+{
+    CALL_FUNC(dcmi_get_device_health, card_id, device_id, health);
+}
+
+int (*dcmi_get_device_errorcode_v2_func)(int card_id, int device_id, int *error_count,
+    unsigned int *error_code_list, unsigned int list_len);
+int dcmi_get_device_errorcode_v2(int card_id, int device_id, int *error_count,
+    unsigned int *error_code_list, unsigned int list_len)
+{
+    CALL_FUNC(dcmi_get_device_errorcode_v2, card_id, device_id, error_count, error_code_list, list_len);
+}
+
+int (*dcmi_get_topo_info_by_device_id_func)(int card_id1, int device_id1, int card_id2, int device_id2,
+    int *topo_type);
+int dcmi_get_topo_info_by_device_id(int card_id1, int device_id1, int card_id2, int device_id2, int *topo_type)
+{
+    CALL_FUNC(dcmi_get_topo_info_by_device_id, card_id1, device_id1, card_id2, device_id2, topo_type);
+}
+
+int (*dcmi_get_device_ip_func)(int card_id, int device_id, enum dcmi_port_type input_type, int port_id,
+    struct dcmi_ip_addr *ip, struct dcmi_ip_addr *mask);
+int dcmi_get_device_ip(int card_id, int device_id, enum dcmi_port_type input_type, int port_id,
+    struct dcmi_ip_addr *ip, struct dcmi_ip_addr *mask)
+{
+    CALL_FUNC(dcmi_get_device_ip, card_id, device_id, input_type, port_id, ip, mask);
+}
+
 // load .so files and functions
 int dcmiInit_dl(char *dl_path)
 {
@@ -152,10 +255,26 @@ int dcmiInit_dl(char *dl_path)
 
     dcmi_get_device_logicid_from_phyid_func = dlsym(dcmiHandle, "dcmi_get_device_logicid_from_phyid");
 
+    dcmi_get_device_phyid_from_logicid_func = dlsym(dcmiHandle, "dcmi_get_device_phyid_from_logicid");
+
     dcmi_get_product_type_func = dlsym(dcmiHandle, "dcmi_get_product_type");
 
     dcmi_get_device_chip_info_func = dlsym(dcmiHandle, "dcmi_get_device_chip_info");
 
+    dcmi_get_device_elabel_info_func = dlsym(dcmiHandle, "dcmi_get_device_elabel_info");
+
+    dcmi_get_device_pcie_info_func = dlsym(dcmiHandle, "dcmi_get_device_pcie_info");
+
+    dcmi_get_device_board_info_func = dlsym(dcmiHandle, "dcmi_get_device_board_info");
+
+    dcmi_get_device_health_func = dlsym(dcmiHandle, "dcmi_get_device_health");
+
+    dcmi_get_device_errorcode_v2_func = dlsym(dcmiHandle, "dcmi_get_device_errorcode_v2");
+
+    dcmi_get_topo_info_by_device_id_func = dlsym(dcmiHandle, "dcmi_get_topo_info_by_device_id");
+
+    dcmi_get_device_ip_func = dlsym(dcmiHandle, "dcmi_get_device_ip");
+
     return SUCCESS;
 }
 
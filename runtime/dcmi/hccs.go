@@ -0,0 +1,100 @@
+/* Copyright(C) 2023. Huawei Technologies Co.,Ltd. All rights reserved.
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package dcmi
+
+import (
+	"sort"
+)
+
+// connections between two devices reported by dcmi
+const (
+	// TopologyHCCS the devices are connected by HCCS
+	TopologyHCCS = 0
+	// TopologyPIX the devices are connected by a PCIe switch
+	TopologyPIX = 1
+	// TopologyPIB the devices are connected by several PCIe switches
+	TopologyPIB = 2
+	// TopologyPHB the devices are connected by a PCIe host bridge
+	TopologyPHB = 3
+	// TopologySYS the devices are connected across NUMA nodes
+	TopologySYS = 4
+	// TopologySIO the devices are two dies of one chip
+	TopologySIO = 5
+	// TopologyHCCSSwitch the devices are connected by HCCS through a switch
+	TopologyHCCSSwitch = 6
+)
+
+func isHCCSConnected(topoType int32) bool {
+	return topoType == TopologyHCCS || topoType == TopologyHCCSSwitch || topoType == TopologySIO
+}
+
+// GetHCCSDomains get the groups of devices connected by HCCS, like [[0 1 2 3] [4 5 6 7]] of an 8-card 910 server,
+// a device without HCCS is a group of its own
+func (s *Session) GetHCCSDomains() ([][]int32, error) {
+	devices, err := s.GetNpuDevices()
+	if err != nil {
+		return nil, err
+	}
+	if s.hccsDomains != nil {
+		return copyDomains(s.hccsDomains), nil
+	}
+	if err = s.initialize(); err != nil {
+		return nil, err
+	}
+	parents := make(map[int32]int32, len(devices))
+	var find func(phyID int32) int32
+	find = func(phyID int32) int32 {
+		if parents[phyID] != phyID {
+			parents[phyID] = find(parents[phyID])
+		}
+		return parents[phyID]
+	}
+	for _, device := range devices {
+		parents[device.PhyID] = device.PhyID
+	}
+	for i := range devices {
+		for j := i + 1; j < len(devices); j++ {
+			topoType, err := s.worker.GetTopologyType(devices[i].CardID, devices[i].DeviceID, devices[j].CardID,
+				devices[j].DeviceID)
+			if err != nil {
+				return nil, err
+			}
+			if isHCCSConnected(topoType) {
+				parents[find(devices[i].PhyID)] = find(devices[j].PhyID)
+			}
+		}
+	}
+	groups := make(map[int32][]int32)
+	for _, device := range devices {
+		root := find(device.PhyID)
+		groups[root] = append(groups[root], device.PhyID)
+	}
+	domains := make([][]int32, 0, len(groups))
+	for _, group := range groups {
+		sort.Slice(group, func(i, j int) bool { return group[i] < group[j] })
+		domains = append(domains, group)
+	}
+	sort.Slice(domains, func(i, j int) bool { return domains[i][0] < domains[j][0] })
+	s.hccsDomains = domains
+	return copyDomains(domains), nil
+}
+
+func copyDomains(domains [][]int32) [][]int32 {
+	copied := make([][]int32, 0, len(domains))
+	for _, domain := range domains {
+		copied = append(copied, append([]int32{}, domain...))
+	}
+	return copied
+}
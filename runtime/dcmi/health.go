@@ -0,0 +1,76 @@
+/* Copyright(C) 2023. Huawei Technologies Co.,Ltd. All rights reserved.
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package dcmi
+
+import (
+	"fmt"
+)
+
+// health states reported by dcmi
+const (
+	// HealthOK the device works normally
+	HealthOK = 0
+	// HealthMinorAlarm the device reports a minor alarm and still works
+	HealthMinorAlarm = 1
+	// HealthMajorAlarm the device reports a major alarm
+	HealthMajorAlarm = 2
+	// HealthCriticalAlarm the device reports a critical alarm
+	HealthCriticalAlarm = 3
+)
+
+// DeviceHealth health state and error codes of a device
+type DeviceHealth struct {
+	PhyID      int32
+	State      uint32
+	ErrorCodes []uint32
+}
+
+// IsHealthy whether a job can run on the device, a minor alarm does not stop it
+func (h *DeviceHealth) IsHealthy() bool {
+	return h.State <= HealthMinorAlarm
+}
+
+// String describe the health like state 2, error codes [0x80e01801]
+func (h *DeviceHealth) String() string {
+	codes := make([]string, 0, len(h.ErrorCodes))
+	for _, code := range h.ErrorCodes {
+		codes = append(codes, fmt.Sprintf("%#x", code))
+	}
+	return fmt.Sprintf("state %d, error codes %v", h.State, codes)
+}
+
+// GetDeviceHealth query the current health of the device, it is never cached
+func (s *Session) GetDeviceHealth(phyID int32) (*DeviceHealth, error) {
+	cardID, deviceID, err := s.findDevice(phyID)
+	if err != nil {
+		return nil, err
+	}
+	// the topology may come from cache, the health always needs dcmi
+	if err = s.initialize(); err != nil {
+		return nil, err
+	}
+	state, err := s.worker.GetDeviceHealth(cardID, deviceID)
+	if err != nil {
+		return nil, err
+	}
+	health := &DeviceHealth{PhyID: phyID, State: state}
+	if health.State == HealthOK {
+		return health, nil
+	}
+	if health.ErrorCodes, err = s.worker.GetDeviceErrorCodes(cardID, deviceID); err != nil {
+		return nil, err
+	}
+	return health, nil
+}
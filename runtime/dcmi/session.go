@@ -0,0 +1,293 @@
+/* Copyright(C) 2023. Huawei Technologies Co.,Ltd. All rights reserved.
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package dcmi
+
+import (
+	"fmt"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+
+	"huawei.com/npu-exporter/v5/common-utils/hwlog"
+)
+
+// Session dcmi session of one invocation, dcmi is initialized and the topology is enumerated at most once,
+// then every query of the invocation is served by them
+type Session struct {
+	worker       WorkerInterface
+	initialized  bool
+	devices      []NpuDevice
+	chipNames    map[int32]string
+	productType  string
+	productKnown bool
+	hccsDomains  [][]int32
+	cache        *TopologyCache
+}
+
+// NewSession create a session on worker, dcmi is initialized by the first query
+func NewSession(w WorkerInterface) *Session {
+	return &Session{worker: w}
+}
+
+// UseTopologyCache serve the topology queries from cache when it is valid, and fill it when it is not
+func (s *Session) UseTopologyCache(cache *TopologyCache) {
+	s.cache = cache
+}
+
+func (s *Session) initialize() error {
+	if s.initialized {
+		return nil
+	}
+	if err := s.worker.Initialize(); err != nil {
+		return fmt.Errorf("cannot init dcmi : %v", err)
+	}
+	s.initialized = true
+	return nil
+}
+
+// Close shut down dcmi if the session initialized it
+func (s *Session) Close() {
+	if !s.initialized {
+		return
+	}
+	s.worker.ShutDown()
+	s.initialized = false
+}
+
+func (s *Session) loadTopology() error {
+	if s.devices != nil {
+		return nil
+	}
+	if s.cache != nil {
+		topology, err := s.cache.Load()
+		if err == nil {
+			s.devices = topology.Devices
+			s.chipNames = topology.ChipNames
+			s.productType = topology.ProductType
+			s.productKnown = true
+			s.hccsDomains = topology.HCCSDomains
+			return nil
+		}
+		hwlog.RunLog.Debugf("topology cache is not used: %v", err)
+	}
+	if err := s.initialize(); err != nil {
+		return err
+	}
+	devices, err := listNpuDevices()
+	if err != nil {
+		return err
+	}
+	s.devices = devices
+	if s.cache != nil {
+		if _, err = s.saveTopology(); err != nil {
+			hwlog.RunLog.Warnf("failed to cache topology: %v", err)
+		}
+	}
+	return nil
+}
+
+func (s *Session) saveTopology() (*Topology, error) {
+	chipNames, err := s.GetDeviceChipNames()
+	if err != nil {
+		return nil, err
+	}
+	productType, err := s.GetProductType()
+	if err != nil {
+		return nil, err
+	}
+	topology := &Topology{ChipNames: chipNames, ProductType: productType,
+		Devices: append([]NpuDevice{}, s.devices...)}
+	// not every product reports the connections, its domains are queried again when used
+	if topology.HCCSDomains, err = s.GetHCCSDomains(); err != nil {
+		hwlog.RunLog.Debugf("hccs domains are not cached: %v", err)
+	}
+	if err = s.cache.Save(topology); err != nil {
+		return nil, err
+	}
+	return topology, nil
+}
+
+// RefreshTopology query the topology from dcmi regardless of the cache, then rewrite the cache
+func (s *Session) RefreshTopology() (*Topology, error) {
+	if s.cache == nil {
+		return nil, fmt.Errorf("no topology cache is used")
+	}
+	if err := s.cache.Invalidate(); err != nil {
+		return nil, err
+	}
+	s.devices, s.chipNames, s.productType, s.productKnown, s.hccsDomains = nil, nil, "", false, nil
+	if err := s.initialize(); err != nil {
+		return nil, err
+	}
+	devices, err := listNpuDevices()
+	if err != nil {
+		return nil, err
+	}
+	s.devices = devices
+	return s.saveTopology()
+}
+
+// GetNpuDevices list all davinci devices, the card/device topology is walked only once
+func (s *Session) GetNpuDevices() ([]NpuDevice, error) {
+	if err := s.loadTopology(); err != nil {
+		return nil, err
+	}
+	return append([]NpuDevice{}, s.devices...), nil
+}
+
+// GetDeviceChipNames get the chip name of every device by phy id, a node may mix several chip types
+func (s *Session) GetDeviceChipNames() (map[int32]string, error) {
+	devices, err := s.GetNpuDevices()
+	if err != nil {
+		return nil, err
+	}
+	if s.chipNames == nil {
+		if len(devices) == 0 {
+			return nil, fmt.Errorf("get chip info failed, no card found")
+		}
+		chipNames := make(map[int32]string, len(devices))
+		for _, device := range devices {
+			chipInfo, err := s.worker.GetChipInfo(device.CardID, device.DeviceID)
+			if err != nil {
+				hwlog.RunLog.Warnf("get chip info failed by cardID(%d), deviceID(%d), error: %#v", device.CardID,
+					device.DeviceID, err)
+				continue
+			}
+			if !isValidChipInfo(chipInfo) {
+				hwlog.RunLog.Warnf("invalid chip info by cardID(%d), deviceID(%d)", device.CardID, device.DeviceID)
+				continue
+			}
+			chipNames[device.PhyID] = chipInfo.Name
+		}
+		if len(chipNames) == 0 {
+			return nil, fmt.Errorf("cannot get valid chip info")
+		}
+		s.chipNames = chipNames
+	}
+	chipNames := make(map[int32]string, len(s.chipNames))
+	for phyID, name := range s.chipNames {
+		chipNames[phyID] = name
+	}
+	return chipNames, nil
+}
+
+// GetChipName get name of chip of the first device with valid chip info
+func (s *Session) GetChipName() (string, error) {
+	chipNames, err := s.GetDeviceChipNames()
+	if err != nil {
+		return "", err
+	}
+	for _, device := range s.devices {
+		if name, ok := chipNames[device.PhyID]; ok {
+			return name, nil
+		}
+	}
+	return "", fmt.Errorf("cannot get valid chip info")
+}
+
+// GetProductType get type of product, empty means no device reports it
+func (s *Session) GetProductType() (string, error) {
+	if s.productKnown {
+		return s.productType, nil
+	}
+	devices, err := s.GetNpuDevices()
+	if err != nil {
+		return "", err
+	}
+	if s.productKnown {
+		return s.productType, nil
+	}
+	for _, device := range devices {
+		productType, err := s.worker.GetProductType(device.CardID, device.DeviceID)
+		if err != nil {
+			hwlog.RunLog.Debugf("get product type by card %d deviceID %d failed, err: %#v", device.CardID,
+				device.DeviceID, err)
+			continue
+		}
+		s.productType = productType
+		break
+	}
+	s.productKnown = true
+	return s.productType, nil
+}
+
+// findDevice get the card id and device id of a phy id
+func (s *Session) findDevice(phyID int32) (int32, int32, error) {
+	devices, err := s.GetNpuDevices()
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, device := range devices {
+		if device.PhyID == phyID {
+			return device.CardID, device.DeviceID, nil
+		}
+	}
+	return 0, 0, fmt.Errorf("device of phy id %d is not found", phyID)
+}
+
+// CreateVDevice create the virtual device requested by ASCEND_VNPU_SPECS on the only device of devices
+func (s *Session) CreateVDevice(spec *specs.Spec, devices []int) (VDeviceInfo, error) {
+	invalidVDevice := VDeviceInfo{CardID: -1, DeviceID: -1, VdeviceID: -1}
+	splitDevice, err := extractVpuParam(spec)
+	if err != nil {
+		return invalidVDevice, err
+	}
+	if splitDevice == "" {
+		return invalidVDevice, nil
+	}
+	if len(devices) != 1 || devices[0] < 0 || devices[0] >= hiAIMaxCardNum*hiAIMaxDeviceNum {
+		hwlog.RunLog.Errorf("invalid devices: %v", devices)
+		return invalidVDevice, fmt.Errorf("invalid devices: %v", devices)
+	}
+
+	targetCardID, targetDeviceID, err := s.findDevice(int32(devices[0]))
+	if err != nil {
+		return invalidVDevice, err
+	}
+	// the devices may come from the topology cache without dcmi initialized
+	if err = s.initialize(); err != nil {
+		return invalidVDevice, err
+	}
+
+	vdeviceID, err := s.worker.CreateVDevice(targetCardID, targetDeviceID, splitDevice)
+	if err != nil || vdeviceID < 0 {
+		hwlog.RunLog.Errorf("cannot create vd or vdevice is wrong: %v %v", vdeviceID, err)
+		return invalidVDevice, err
+	}
+	return VDeviceInfo{CardID: targetCardID, DeviceID: targetDeviceID, VdeviceID: vdeviceID}, nil
+}
+
+// ResolveDeviceIdentifiers resolve stable identifiers like serial:XXXX or pci:0000:81:00.0 into phy ids
+func (s *Session) ResolveDeviceIdentifiers(identifiers []string) (map[string][]int32, error) {
+	devices, err := s.GetNpuDevices()
+	if err != nil {
+		return nil, err
+	}
+	if err = s.initialize(); err != nil {
+		return nil, err
+	}
+	return matchDeviceIdentifiers(s.worker, identifiers, devices)
+}
+
+// GetDeviceIP get the ip of the roce port of the device, it is never cached
+func (s *Session) GetDeviceIP(phyID int32) (string, error) {
+	cardID, deviceID, err := s.findDevice(phyID)
+	if err != nil {
+		return "", err
+	}
+	if err = s.initialize(); err != nil {
+		return "", err
+	}
+	return s.worker.GetDeviceIP(cardID, deviceID)
+}
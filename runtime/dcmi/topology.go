@@ -0,0 +1,161 @@
+/* Copyright(C) 2023. Huawei Technologies Co.,Ltd. All rights reserved.
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package dcmi
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"huawei.com/npu-exporter/v5/common-utils/hwlog"
+
+	"mindxcheckutils"
+)
+
+const (
+	topologyCacheDirMode  = 0750
+	topologyCacheFileMode = 0640
+	driverVersionKey      = "Version"
+)
+
+var bootIDPath = "/proc/sys/kernel/random/boot_id"
+
+// TopologyKey what the topology depends on, a cache of another key is stale
+type TopologyKey struct {
+	BootID        string `json:"boot_id"`
+	DriverVersion string `json:"driver_version"`
+}
+
+// Topology node topology which does not change until reboot or driver upgrade
+type Topology struct {
+	Key         TopologyKey      `json:"key"`
+	ChipNames   map[int32]string `json:"chip_names"`
+	ProductType string           `json:"product_type"`
+	Devices     []NpuDevice      `json:"devices"`
+	HCCSDomains [][]int32        `json:"hccs_domains,omitempty"`
+}
+
+// TopologyCache topology cache file of the node
+type TopologyCache struct {
+	path string
+	key  TopologyKey
+}
+
+// ReadDriverVersion read the driver version from version.info of the driver package
+func ReadDriverVersion(versionInfoPath string) (string, error) {
+	if _, err := mindxcheckutils.RealFileChecker(versionInfoPath, true, false, mindxcheckutils.DefaultSize); err != nil {
+		return "", err
+	}
+	content, err := ioutil.ReadFile(versionInfoPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read driver version file %s: %v", versionInfoPath, err)
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		words := strings.SplitN(strings.TrimSpace(line), "=", 2)
+		if len(words) == 2 && strings.TrimSpace(words[0]) == driverVersionKey && strings.TrimSpace(words[1]) != "" {
+			return strings.TrimSpace(words[1]), nil
+		}
+	}
+	return "", fmt.Errorf("no driver version found in %s", versionInfoPath)
+}
+
+// NewTopologyCache open the cache at cachePath keyed by the boot id and the driver version in versionInfoPath
+func NewTopologyCache(cachePath, versionInfoPath string) (*TopologyCache, error) {
+	content, err := ioutil.ReadFile(bootIDPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read boot id: %v", err)
+	}
+	bootID := strings.TrimSpace(string(content))
+	if bootID == "" {
+		return nil, fmt.Errorf("boot id is empty")
+	}
+	version, err := ReadDriverVersion(versionInfoPath)
+	if err != nil {
+		return nil, err
+	}
+	return &TopologyCache{path: cachePath, key: TopologyKey{BootID: bootID, DriverVersion: version}}, nil
+}
+
+// Load read the cached topology, the cache of another boot or driver version is not used
+func (c *TopologyCache) Load() (*Topology, error) {
+	if _, err := mindxcheckutils.RealFileChecker(c.path, true, false, mindxcheckutils.DefaultSize); err != nil {
+		return nil, err
+	}
+	content, err := ioutil.ReadFile(c.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read topology cache %s: %v", c.path, err)
+	}
+	var topology Topology
+	if err = json.Unmarshal(content, &topology); err != nil {
+		return nil, fmt.Errorf("failed to parse topology cache %s: %v", c.path, err)
+	}
+	if topology.Key != c.key {
+		return nil, fmt.Errorf("topology cache of %#v is stale, current %#v", topology.Key, c.key)
+	}
+	if len(topology.Devices) == 0 || len(topology.ChipNames) == 0 {
+		return nil, fmt.Errorf("topology cache %s is incomplete", c.path)
+	}
+	return &topology, nil
+}
+
+// Save write the topology through a temp file, so that a concurrent Load never sees a partial cache
+func (c *TopologyCache) Save(topology *Topology) error {
+	topology.Key = c.key
+	content, err := json.Marshal(topology)
+	if err != nil {
+		return fmt.Errorf("failed to marshal topology: %v", err)
+	}
+	dir := filepath.Dir(c.path)
+	if err = os.MkdirAll(dir, topologyCacheDirMode); err != nil {
+		return fmt.Errorf("failed to create topology cache dir: %v", err)
+	}
+	if _, err = mindxcheckutils.RealDirChecker(dir, true, false); err != nil {
+		return err
+	}
+	tmpFile, err := ioutil.TempFile(dir, "."+filepath.Base(c.path)+"-")
+	if err != nil {
+		return fmt.Errorf("failed to create temp topology cache: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+	_, err = tmpFile.Write(content)
+	if err == nil {
+		err = tmpFile.Chmod(topologyCacheFileMode)
+	}
+	if err == nil {
+		err = tmpFile.Sync()
+	}
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write temp topology cache: %v", err)
+	}
+	if err = os.Rename(tmpFile.Name(), c.path); err != nil {
+		return fmt.Errorf("failed to rename topology cache: %v", err)
+	}
+	hwlog.RunLog.Infof("topology of %d devices cached in %s", len(topology.Devices), c.path)
+	return nil
+}
+
+// Invalidate remove the cache file
+func (c *TopologyCache) Invalidate() error {
+	if err := os.Remove(c.path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove topology cache %s: %v", c.path, err)
+	}
+	return nil
+}
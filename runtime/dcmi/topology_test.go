@@ -0,0 +1,186 @@
+/* Copyright(C) 2023. Huawei Technologies Co.,Ltd. All rights reserved.
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package dcmi
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/agiledragon/gomonkey/v2"
+	"github.com/opencontainers/runtime-spec/specs-go"
+
+	"mindxcheckutils"
+)
+
+const testFileMode = 0600
+
+// stubPathCheckers the temp dir of tests is world writable, which the path checkers refuse
+func stubPathCheckers() *gomonkey.Patches {
+	stub := gomonkey.ApplyFunc(mindxcheckutils.RealFileChecker,
+		func(path string, checkParent, allowLink bool, size int) (string, error) {
+			return path, nil
+		})
+	stub.ApplyFunc(mindxcheckutils.RealDirChecker, func(path string, checkParent, allowLink bool) (string, error) {
+		return path, nil
+	})
+	return stub
+}
+
+func newTestTopologyCache(t *testing.T, dir, version string) *TopologyCache {
+	versionInfo := filepath.Join(dir, "version.info")
+	if err := ioutil.WriteFile(versionInfo, []byte("Version="+version+"\n"), testFileMode); err != nil {
+		t.Fatalf("%v", err)
+	}
+	cache, err := NewTopologyCache(filepath.Join(dir, "cache", "topology.json"), versionInfo)
+	if err != nil {
+		t.Fatalf("failed to open cache: %v", err)
+	}
+	return cache
+}
+
+func TestTopologyCache(t *testing.T) {
+	stub := stubPathCheckers()
+	defer stub.Reset()
+	dir, err := ioutil.TempDir("", "topology")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(dir)
+	bootID := filepath.Join(dir, "boot_id")
+	if err = ioutil.WriteFile(bootID, []byte("boot-1\n"), testFileMode); err != nil {
+		t.Fatalf("%v", err)
+	}
+	original := bootIDPath
+	bootIDPath = bootID
+	defer func() { bootIDPath = original }()
+
+	cache := newTestTopologyCache(t, dir, "23.0.rc2")
+	if _, err = cache.Load(); err == nil {
+		t.Fatalf("cache should not exist")
+	}
+	topology := &Topology{ChipNames: map[int32]string{1: "910B"}, ProductType: "Atlas 800",
+		Devices: []NpuDevice{{PhyID: 1, LogicID: 0}}}
+	if err = cache.Save(topology); err != nil {
+		t.Fatalf("failed to save cache: %v", err)
+	}
+	loaded, err := cache.Load()
+	if err != nil || loaded.ChipNames[1] != "910B" || loaded.ProductType != "Atlas 800" || loaded.Devices[0].PhyID != 1 {
+		t.Fatalf("%v %v", loaded, err)
+	}
+
+	// the cache of another driver version or boot is stale
+	if _, err = newTestTopologyCache(t, dir, "23.0.rc3").Load(); err == nil {
+		t.Fatalf("cache of another driver version should be stale")
+	}
+	if err = ioutil.WriteFile(bootID, []byte("boot-2\n"), testFileMode); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if _, err = newTestTopologyCache(t, dir, "23.0.rc2").Load(); err == nil {
+		t.Fatalf("cache of another boot should be stale")
+	}
+
+	if err = cache.Invalidate(); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err = cache.Invalidate(); err != nil {
+		t.Fatalf("invalidating a removed cache should succeed: %v", err)
+	}
+}
+
+func TestSessionUseTopologyCache(t *testing.T) {
+	stub := stubPathCheckers()
+	defer stub.Reset()
+	dir, err := ioutil.TempDir("", "topology")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(dir)
+	cache := &TopologyCache{path: filepath.Join(dir, "topology.json"), key: TopologyKey{BootID: "boot-1"}}
+	if err = cache.Save(&Topology{ChipNames: map[int32]string{3: "310P"}, Devices: []NpuDevice{{PhyID: 3, CardID: 1}}}); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	worker := &mockWorker{}
+	session := NewSession(worker)
+	session.UseTopologyCache(cache)
+	name, err := session.GetChipName()
+	if err != nil || name != "310P" {
+		t.Fatalf("%v %v", name, err)
+	}
+	if productType, err := session.GetProductType(); err != nil || productType != "" {
+		t.Fatalf("%v %v", productType, err)
+	}
+	if cardID, _, err := session.findDevice(3); err != nil || cardID != 1 {
+		t.Fatalf("%v %v", cardID, err)
+	}
+	session.Close()
+	if worker.initCount != 0 || worker.chipInfoCount != 0 {
+		t.Fatalf("dcmi should not be used when the cache is valid")
+	}
+
+	// the refresh drops the cache before querying dcmi, which is not loaded in tests
+	if _, err = NewSession(worker).RefreshTopology(); err == nil {
+		t.Fatalf("refresh without cache should fail")
+	}
+	session = NewSession(worker)
+	session.UseTopologyCache(cache)
+	if _, err = session.RefreshTopology(); err == nil {
+		t.Fatalf("refresh should fail without dcmi")
+	}
+	if _, err = cache.Load(); err == nil {
+		t.Fatalf("refresh should drop the cache")
+	}
+}
+
+func TestSessionWarmCacheInitializesDcmi(t *testing.T) {
+	stub := stubPathCheckers()
+	defer stub.Reset()
+	dir, err := ioutil.TempDir("", "topology")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(dir)
+	cache := &TopologyCache{path: filepath.Join(dir, "topology.json"), key: TopologyKey{BootID: "boot-1"}}
+	if err = cache.Save(&Topology{ChipNames: map[int32]string{0: "910B", 1: "910B"},
+		Devices: []NpuDevice{{PhyID: 0, CardID: 0, DeviceID: 0},
+			{PhyID: 1, CardID: 0, DeviceID: 1}}}); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	// the devices come from the cache, the worker calls still need dcmi
+	worker := &mockWorker{}
+	session := NewSession(worker)
+	session.UseTopologyCache(cache)
+	resolved, err := session.ResolveDeviceIdentifiers([]string{"serial:SN01", "board:0x20"})
+	if err != nil || fmt.Sprint(resolved["serial:SN01"]) != "[1]" || fmt.Sprint(resolved["board:0x20"]) != "[0 1]" {
+		t.Fatalf("%v %v", resolved, err)
+	}
+	session.Close()
+
+	session = NewSession(worker)
+	session.UseTopologyCache(cache)
+	spec := &specs.Spec{Process: &specs.Process{Env: []string{"ASCEND_VNPU_SPECS=vir04"}}}
+	vdevice, err := session.CreateVDevice(spec, []int{1})
+	if err != nil || vdevice.VdeviceID != mockDeviceID || vdevice.DeviceID != 1 {
+		t.Fatalf("%v %v", vdevice, err)
+	}
+	session.Close()
+	if worker.initCount != 2 || worker.shutDownCount != 2 {
+		t.Fatalf("init %d times, shut down %d times", worker.initCount, worker.shutDownCount)
+	}
+}
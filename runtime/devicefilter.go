@@ -0,0 +1,90 @@
+/* Copyright(C) 2023. Huawei Technologies Co.,Ltd. All rights reserved.
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package main
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/cilium/ebpf/asm"
+	"github.com/opencontainers/runc/libcontainer/cgroups/ebpf"
+	"github.com/opencontainers/runc/libcontainer/cgroups/ebpf/devicefilter"
+	"github.com/opencontainers/runc/libcontainer/devices"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"golang.org/x/sys/unix"
+)
+
+// toDeviceRule convert a rule of the spec to the rule of runc, an empty type or access means all of them
+func toDeviceRule(rule specs.LinuxDeviceCgroup) *devices.Rule {
+	deviceRule := &devices.Rule{
+		Type:        devices.WildcardDevice,
+		Major:       devices.Wildcard,
+		Minor:       devices.Wildcard,
+		Permissions: devices.Permissions(rule.Access),
+		Allow:       rule.Allow,
+	}
+	if rule.Type != "" {
+		deviceRule.Type = devices.Type(rule.Type[0])
+	}
+	if rule.Major != nil {
+		deviceRule.Major = *rule.Major
+	}
+	if rule.Minor != nil {
+		deviceRule.Minor = *rule.Minor
+	}
+	if rule.Access == "" {
+		deviceRule.Permissions = deviceCgroupAccessAll
+	}
+	return deviceRule
+}
+
+// buildDeviceFilter build the device cgroup program of rules by runc, so that the rules behave the same as the ones
+// runc applies when the container is created: a later rule takes precedence over earlier ones
+func buildDeviceFilter(rules []specs.LinuxDeviceCgroup) (asm.Instructions, string, error) {
+	deviceRules := make([]*devices.Rule, 0, len(rules))
+	for _, rule := range rules {
+		if len(rule.Type) > 1 {
+			return nil, "", fmt.Errorf("unsupported device type %s", rule.Type)
+		}
+		if strings.Trim(rule.Access, deviceCgroupAccessAll) != "" {
+			return nil, "", fmt.Errorf("unsupported device access %s", rule.Access)
+		}
+		deviceRules = append(deviceRules, toDeviceRule(rule))
+	}
+	insns, license, err := devicefilter.DeviceFilter(deviceRules)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to build device filter: %v", err)
+	}
+	return insns, license, nil
+}
+
+// replaceDeviceFilter attach the program of rules to the cgroup v2 dir in place of the programs attached before,
+// so that the cgroup is never left without a device filter
+func replaceDeviceFilter(cgroupDir string, rules []specs.LinuxDeviceCgroup) error {
+	insns, license, err := buildDeviceFilter(rules)
+	if err != nil {
+		return err
+	}
+	cgroupFd, err := unix.Open(cgroupDir, unix.O_DIRECTORY|unix.O_RDONLY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return fmt.Errorf("failed to open cgroup %s: %v", cgroupDir, err)
+	}
+	defer unix.Close(cgroupFd)
+	if _, err = ebpf.LoadAttachCgroupDeviceFilter(insns, license, cgroupFd); err != nil {
+		return fmt.Errorf("failed to attach device filter to %s: %v", cgroupDir, err)
+	}
+	return nil
+}
@@ -3,10 +3,17 @@ module main
 go 1.18
 
 require (
+	ascendconfig v1.0.0
 	github.com/agiledragon/gomonkey/v2 v2.8.0
+	github.com/cilium/ebpf v0.7.0
 	github.com/containerd/containerd v1.6.24
-	github.com/opencontainers/runtime-spec v1.0.3-0.20220718201635-a8106e99982b
+	github.com/containerd/nri v0.4.0
+	github.com/opencontainers/runc v1.1.5
+	github.com/opencontainers/runtime-spec v1.0.3-0.20220825212826-86290f6a00fb
+	github.com/pmezard/go-difflib v1.0.0
 	github.com/stretchr/testify v1.8.2
+	golang.org/x/sys v0.8.0
+	gopkg.in/yaml.v3 v3.0.1
 	huawei.com/npu-exporter/v5 v5.0.0-RC1
 	mindxcheckutils v1.0.0
 )
@@ -16,7 +23,7 @@ require (
 	github.com/Microsoft/hcsshim v0.9.10 // indirect
 	github.com/containerd/cgroups v1.0.4 // indirect
 	github.com/containerd/continuity v0.3.0 // indirect
-	github.com/containerd/ttrpc v1.1.2 // indirect
+	github.com/containerd/ttrpc v1.2.2 // indirect
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/fsnotify/fsnotify v1.6.0 // indirect
 	github.com/gogo/protobuf v1.3.2 // indirect
@@ -26,20 +33,20 @@ require (
 	github.com/moby/sys/mountinfo v0.6.2 // indirect
 	github.com/opencontainers/go-digest v1.0.0 // indirect
 	github.com/opencontainers/image-spec v1.1.0-rc2.0.20221005185240-3a7f492d3f1b // indirect
-	github.com/opencontainers/runc v1.1.5 // indirect
 	github.com/pkg/errors v0.9.1 // indirect
-	github.com/pmezard/go-difflib v1.0.0 // indirect
 	github.com/sirupsen/logrus v1.9.0 // indirect
 	go.opencensus.io v0.23.0 // indirect
+	golang.org/x/net v0.9.0 // indirect
 	golang.org/x/sync v0.1.0 // indirect
-	golang.org/x/sys v0.8.0 // indirect
+	golang.org/x/text v0.9.0 // indirect
 	google.golang.org/genproto/googleapis/rpc v0.0.0-20230525234030-28d5490b6b19 // indirect
 	google.golang.org/grpc v1.57.2 // indirect
 	google.golang.org/protobuf v1.30.0 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
+	k8s.io/cri-api v0.25.3 // indirect
 )
 
 replace (
+	ascendconfig => ../ascendconfig
 	github.com/prashantv/gostub => github.com/prashantv/gostub v1.0.1-0.20191007164320-bbe3712b9c4a
 	huawei.com/npu-exporter/v5 => gitee.com/ascend/ascend-npu-exporter/v5 v5.0.0-RC4.b002
 	mindxcheckutils => ../mindxcheckutils
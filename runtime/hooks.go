@@ -0,0 +1,263 @@
+/* Copyright(C) 2023. Huawei Technologies Co.,Ltd. All rights reserved.
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package main
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"huawei.com/npu-exporter/v5/common-utils/hwlog"
+
+	"ascendconfig"
+	"main/dcmi"
+	"main/injector"
+)
+
+const (
+	hooksCommand         = "hooks"
+	hooksGenerateCommand = "generate"
+	hooksInjectCommand   = "inject"
+	hooksUsage           = "usage: ascend-docker-runtime hooks generate [--output <file>] [--always]" +
+		"\n       ascend-docker-runtime hooks inject --pid <pid> --bundle <dir>"
+
+	ociHooksVersion  = "1.0.0"
+	ociHooksFilePath = "/usr/share/containers/oci/hooks.d/ascend-docker-hook.json"
+	ociHooksStage    = "prestart"
+)
+
+// ociHooksConfig the hooks.d config of podman and cri-o, they run the hook with the stock runc or crun
+type ociHooksConfig struct {
+	Version string          `json:"version"`
+	Hook    specs.Hook      `json:"hook"`
+	When    ociHooksTrigger `json:"when"`
+	Stages  []string        `json:"stages"`
+}
+
+// ociHooksTrigger the conditions of hooks.d to run the hook, version 1.0.0 matches annotations but not ENV
+type ociHooksTrigger struct {
+	Always      *bool             `json:"always,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// generateOCIHooksConfig get the hooks.d config running ascend-docker-hook in hook-only mode, it is triggered by
+// the device annotation, or by every container when always is set so that ASCEND_VISIBLE_DEVICES also works
+func generateOCIHooksConfig(always bool) (*ociHooksConfig, error) {
+	cliPath, err := getHookCliPath()
+	if err != nil {
+		return nil, err
+	}
+	config := &ociHooksConfig{
+		Version: ociHooksVersion,
+		Hook:    specs.Hook{Path: cliPath, Args: []string{cliPath, ascendconfig.HookOnlyOption}},
+		Stages:  []string{ociHooksStage},
+	}
+	if always {
+		config.When.Always = &always
+		return config, nil
+	}
+	config.When.Annotations = map[string]string{
+		"^" + strings.ReplaceAll(injector.AscendVisibleDevicesAnnotation, ".", `\.`) + "$": ".+",
+	}
+	return config, nil
+}
+
+func getHooksInjectArgs(cmdArgs []string) (int, string, error) {
+	pid, bundle := 0, ""
+	for i := 0; i < len(cmdArgs); i++ {
+		if len(cmdArgs)-i <= 1 {
+			return 0, "", fmt.Errorf("%s", hooksUsage)
+		}
+		switch cmdArgs[i] {
+		case "--pid":
+			var err error
+			if pid, err = strconv.Atoi(cmdArgs[i+1]); err != nil || pid <= 0 {
+				return 0, "", fmt.Errorf("invalid pid: %s", cmdArgs[i+1])
+			}
+		case "--bundle":
+			bundle = cmdArgs[i+1]
+		default:
+			return 0, "", fmt.Errorf("%s", hooksUsage)
+		}
+		i++
+	}
+	if pid == 0 || !filepath.IsAbs(bundle) {
+		return 0, "", fmt.Errorf("%s", hooksUsage)
+	}
+	return pid, bundle, nil
+}
+
+// checkHookOnlySpec refuse what only ascend-docker-runtime can do, as the spec cannot be changed any more
+func checkHookOnlySpec(spec *specs.Spec) error {
+	if len(spec.Linux.UIDMappings) != 0 {
+		return fmt.Errorf("device nodes cannot be created in a user namespace in hook-only mode, " +
+			"use ascend-docker-runtime")
+	}
+	_, requested := spec.Annotations[dcmi.VnpuSpecsAnnotation]
+	if requested || injector.GetValueByKey(spec.Process.Env, dcmi.VnpuSpecsEnv) != "" {
+		return fmt.Errorf("creating vnpu is not supported in hook-only mode, use ascend-docker-runtime")
+	}
+	return nil
+}
+
+// applyDeviceRules allow the devices added to the container, cgroup v1 takes only the last rule of each apply
+// while cgroup v2 takes the whole rule set at once
+func applyDeviceRules(cgroup *deviceCgroup, rules []specs.LinuxDeviceCgroup, added int) error {
+	for i := len(rules) - added + 1; i <= len(rules); i++ {
+		if cgroup.v2 && i < len(rules) {
+			continue
+		}
+		if err := cgroup.apply(rules[:i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getBundleRootfs get the rootfs of the container, a relative root path is under the bundle
+func getBundleRootfs(bundle string, spec *specs.Spec) (string, error) {
+	if spec.Root == nil || spec.Root.Path == "" {
+		return "", fmt.Errorf("invalid OCI spec for empty root")
+	}
+	if filepath.IsAbs(spec.Root.Path) {
+		return filepath.Clean(spec.Root.Path), nil
+	}
+	return filepath.Join(bundle, spec.Root.Path), nil
+}
+
+// injectHookDevices do what ascend-docker-runtime does to the spec for a container created by another runtime,
+// the requested devices are allowed in the device cgroup and their nodes are created in /dev of the rootfs, as
+// the prestart hook runs before pivot_root
+func injectHookDevices(pid int, rootfs string, spec *specs.Spec) error {
+	if spec.Process == nil || spec.Linux == nil {
+		return fmt.Errorf("invalid OCI spec for empty process or linux")
+	}
+	if spec.Linux.Resources == nil {
+		spec.Linux.Resources = &specs.LinuxResources{}
+	}
+	session := injector.NewSession(runtimeCfg)
+	defer session.Close()
+	devices, err := injector.CheckVisibleDevice(spec, runtimeCfg, session)
+	if err != nil {
+		return fmt.Errorf("failed to check ASCEND_VISIBLE_DEVICES parameter, err: %v", err)
+	}
+	if devices == nil {
+		return nil
+	}
+	// the manager devices are added for none as well, where no davinci device is requested
+	injected := injector.DevicePath + injector.DavinciManager
+	if len(devices) > 0 {
+		injected = injector.DevicePath + injector.DavinciName + strconv.Itoa(devices[0])
+	}
+	if hasSpecDevice(spec, injected) {
+		hwlog.RunLog.Info("devices are already injected by ascend-docker-runtime")
+		return nil
+	}
+	if err = checkHookOnlySpec(spec); err != nil {
+		return err
+	}
+	if !strings.Contains(injector.GetValueFromSpec(spec, injector.AscendRuntimeOptions), "VIRTUAL") {
+		if err = injector.CheckTopologyPolicy(spec, devices, session); err != nil {
+			return fmt.Errorf("failed to check topology of devices: %v", err)
+		}
+	}
+	addedDevices := len(spec.Linux.Devices)
+	specRules := append([]specs.LinuxDeviceCgroup{}, spec.Linux.Resources.Devices...)
+	if err = injector.AddDevice(spec, devices, runtimeCfg, session); err != nil {
+		return fmt.Errorf("failed to add device: %v", err)
+	}
+	cgroup, err := getDeviceCgroup(pid)
+	if err != nil {
+		return err
+	}
+	// the nodes are created before the devices are allowed, as ascend-docker-runtime device attach does
+	for i := addedDevices; i < len(spec.Linux.Devices); i++ {
+		if err = createDeviceNode(pid, rootfs, &spec.Linux.Devices[i]); err != nil {
+			return err
+		}
+	}
+	// the default rules of the runtime follow the rules of the spec, as runc and crun do
+	addedRules := spec.Linux.Resources.Devices[len(specRules):]
+	rules := append(append(specRules, defaultDeviceRules()...), addedRules...)
+	if err = applyDeviceRules(cgroup, rules, len(addedRules)); err != nil {
+		return err
+	}
+	hwlog.RunLog.Infof("devices %v injected into process %d in hook-only mode", devices, pid)
+	return nil
+}
+
+// doHooksGenerate write the hooks.d config running ascend-docker-hook
+func doHooksGenerate(cmdArgs []string) error {
+	configPath, always := ociHooksFilePath, false
+	for i := 0; i < len(cmdArgs); i++ {
+		switch cmdArgs[i] {
+		case "--output", "-o":
+			if len(cmdArgs)-i <= 1 {
+				return fmt.Errorf("output option needs an argument")
+			}
+			configPath = cmdArgs[i+1]
+			i++
+		case "--always":
+			always = true
+		default:
+			return fmt.Errorf("%s", hooksUsage)
+		}
+	}
+	config, err := generateOCIHooksConfig(always)
+	if err != nil {
+		return err
+	}
+	content, err := json.MarshalIndent(config, "", previewIndent)
+	if err != nil {
+		return fmt.Errorf("failed to marshal hooks config: %v", err)
+	}
+	if err = writeGeneratedFile(configPath, append(content, '\n')); err != nil {
+		return err
+	}
+	hwlog.RunLog.Infof("hooks config written to %s", configPath)
+	fmt.Printf("hooks config written to %s\n", configPath)
+	return nil
+}
+
+// doHooksProcess generate the hooks.d config, or inject the devices for ascend-docker-hook in hook-only mode
+func doHooksProcess(cmdArgs []string) error {
+	if len(cmdArgs) == 0 {
+		return fmt.Errorf("%s", hooksUsage)
+	}
+	switch cmdArgs[0] {
+	case hooksGenerateCommand:
+		return doHooksGenerate(cmdArgs[1:])
+	case hooksInjectCommand:
+		pid, bundle, err := getHooksInjectArgs(cmdArgs[1:])
+		if err != nil {
+			return err
+		}
+		spec, err := readSpecFile(filepath.Join(bundle, "config.json"))
+		if err != nil {
+			return err
+		}
+		rootfs, err := getBundleRootfs(bundle, spec)
+		if err != nil {
+			return err
+		}
+		return injectHookDevices(pid, rootfs, spec)
+	default:
+		return fmt.Errorf("%s", hooksUsage)
+	}
+}
@@ -0,0 +1,266 @@
+/* Copyright(C) 2023. Huawei Technologies Co.,Ltd. All rights reserved.
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package main
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/agiledragon/gomonkey/v2"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
+
+	"ascendconfig"
+	"main/dcmi"
+	"main/injector"
+	"mindxcheckutils"
+)
+
+func TestDoHooksGenerate(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "hooks.d", "ascend-docker-hook.json")
+	stub := gomonkey.ApplyFunc(getHookCliPath, func() (string, error) {
+		return "/usr/local/bin/ascend-docker-hook", nil
+	})
+	defer stub.Reset()
+	stub.ApplyFunc(mindxcheckutils.RealDirChecker, func(path string, checkParent, allowLink bool) (string, error) {
+		return path, nil
+	})
+
+	assert.Nil(t, doHooksProcess([]string{"generate", "--output", configPath}))
+	content, err := ioutil.ReadFile(configPath)
+	assert.Nil(t, err)
+	var config ociHooksConfig
+	assert.Nil(t, json.Unmarshal(content, &config))
+	assert.EqualValues(t, "1.0.0", config.Version)
+	assert.EqualValues(t, []string{"/usr/local/bin/ascend-docker-hook", "--hook-only"}, config.Hook.Args)
+	assert.EqualValues(t, []string{"prestart"}, config.Stages)
+	assert.Nil(t, config.When.Always)
+	assert.EqualValues(t, map[string]string{`^huawei\.com/ascend\.visible-devices$`: ".+"}, config.When.Annotations)
+
+	assert.Nil(t, doHooksProcess([]string{"generate", "--always", "-o", configPath}))
+	content, err = ioutil.ReadFile(configPath)
+	assert.Nil(t, err)
+	config = ociHooksConfig{}
+	assert.Nil(t, json.Unmarshal(content, &config))
+	assert.True(t, *config.When.Always)
+	assert.Empty(t, config.When.Annotations)
+
+	assert.NotNil(t, doHooksProcess([]string{"generate", "--output"}))
+	assert.NotNil(t, doHooksProcess([]string{"generate", "--when"}))
+	assert.NotNil(t, doHooksProcess([]string{"install"}))
+}
+
+func TestGetHooksInjectArgs(t *testing.T) {
+	pid, bundle, err := getHooksInjectArgs([]string{"--pid", "123", "--bundle", "/run/containers/abc"})
+	assert.Nil(t, err)
+	assert.EqualValues(t, 123, pid)
+	assert.EqualValues(t, "/run/containers/abc", bundle)
+
+	for _, cmdArgs := range [][]string{{}, {"--pid", "123"}, {"--pid", "0", "--bundle", "/abc"},
+		{"--pid", "x", "--bundle", "/abc"}, {"--pid", "1", "--bundle", "abc"}, {"--pid", "1", "--bundle"},
+		{"--pid", "1", "--bundle", "/abc", "--root", "/run"}} {
+		_, _, err = getHooksInjectArgs(cmdArgs)
+		assert.NotNil(t, err, cmdArgs)
+	}
+}
+
+func TestCheckHookOnlySpec(t *testing.T) {
+	spec := &specs.Spec{Process: &specs.Process{Env: []string{"ASCEND_VISIBLE_DEVICES=0"}}, Linux: &specs.Linux{}}
+	assert.Nil(t, checkHookOnlySpec(spec))
+
+	spec.Process.Env = append(spec.Process.Env, "ASCEND_VNPU_SPECS=vir02")
+	assert.NotNil(t, checkHookOnlySpec(spec))
+
+	spec.Process.Env = spec.Process.Env[:1]
+	spec.Annotations = map[string]string{"huawei.com/ascend.vnpu-specs": "vir02"}
+	assert.NotNil(t, checkHookOnlySpec(spec))
+
+	spec.Annotations = nil
+	spec.Linux.UIDMappings = []specs.LinuxIDMapping{{ContainerID: 0, HostID: 100000, Size: 65536}}
+	assert.NotNil(t, checkHookOnlySpec(spec))
+}
+
+// stubHookBundle lay out a bundle whose rootfs is seen through the root of process 1 like the prestart hook sees
+// it before pivot_root, only the devices of the driver are stubbed
+func stubHookBundle(t *testing.T, spec *specs.Spec, cgroupLine string) (*gomonkey.Patches, string, string) {
+	dir := t.TempDir()
+	bundle := filepath.Join(dir, "bundle")
+	assert.Nil(t, os.MkdirAll(filepath.Join(bundle, "rootfs", "dev"), stateDirMode))
+	spec.Root = &specs.Root{Path: "rootfs"}
+	content, err := json.Marshal(spec)
+	assert.Nil(t, err)
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(bundle, "config.json"), content, testFileMode))
+	procDir := filepath.Join(dir, "proc", "1")
+	assert.Nil(t, os.MkdirAll(procDir, stateDirMode))
+	assert.Nil(t, os.Symlink("/", filepath.Join(procDir, "root")))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(procDir, "cgroup"), []byte(cgroupLine+"\n"), testFileMode))
+	cgroupDir := filepath.Join(dir, "cgroup", devicesController, "abc")
+	assert.Nil(t, os.MkdirAll(cgroupDir, stateDirMode))
+	for _, name := range []string{devicesAllowFile, devicesDenyFile} {
+		assert.Nil(t, ioutil.WriteFile(filepath.Join(cgroupDir, name), nil, testFileMode))
+	}
+
+	stub := gomonkey.ApplyGlobalVar(&procRoot, filepath.Join(dir, "proc"))
+	stub.ApplyGlobalVar(&cgroupRoot, filepath.Join(dir, "cgroup"))
+	stub.ApplyFunc(mindxcheckutils.RealFileChecker, func(path string, checkParent, allowLink bool,
+		size int) (string, error) {
+		return path, nil
+	})
+	stub.ApplyFunc(injector.CheckTopologyPolicy, func(spec *specs.Spec, devices []int,
+		session *dcmi.Session) error {
+		return nil
+	})
+	stub.ApplyFunc(injector.AddDevice, func(spec *specs.Spec, deviceIDs []int, cfg *ascendconfig.Config,
+		session *dcmi.Session) error {
+		mode := os.FileMode(testFileMode)
+		spec.Linux.Devices = append(spec.Linux.Devices, specs.LinuxDevice{Path: "/dev/davinci_manager",
+			Type: "c", Major: testManagerMajor, FileMode: &mode})
+		spec.Linux.Resources.Devices = append(spec.Linux.Resources.Devices,
+			newTestRule(true, "c", testManagerMajor, 0, deviceCgroupAccessAll))
+		for _, id := range deviceIDs {
+			minor := int64(id)
+			spec.Linux.Devices = append(spec.Linux.Devices, specs.LinuxDevice{
+				Path: "/dev/davinci" + strconv.Itoa(id), Type: "c", Major: testDavinciMajor, Minor: minor,
+				FileMode: &mode})
+			spec.Linux.Resources.Devices = append(spec.Linux.Resources.Devices,
+				newTestRule(true, "c", testDavinciMajor, minor, deviceCgroupAccessAll))
+		}
+		return nil
+	})
+	return stub, bundle, cgroupDir
+}
+
+func skipWithoutMknod(t *testing.T) {
+	if err := unix.Mknod(filepath.Join(t.TempDir(), "null"), unix.S_IFCHR, int(unix.Mkdev(1, 3))); err != nil {
+		t.Skipf("mknod is not permitted: %v", err)
+	}
+}
+
+func TestInjectHookDevices(t *testing.T) {
+	skipWithoutMknod(t)
+	spec := &specs.Spec{Process: &specs.Process{Env: []string{"ASCEND_VISIBLE_DEVICES=1,2"}}, Linux: &specs.Linux{
+		Resources: &specs.LinuxResources{Devices: []specs.LinuxDeviceCgroup{{Allow: false, Access: "rwm"}}}}}
+	stub, bundle, cgroupDir := stubHookBundle(t, spec, "5:devices:/abc")
+	defer stub.Reset()
+
+	assert.Nil(t, doHooksProcess([]string{"inject", "--pid", "1", "--bundle", bundle}))
+	for _, minor := range []uint32{1, 2} {
+		var stat unix.Stat_t
+		assert.Nil(t, unix.Lstat(filepath.Join(bundle, "rootfs", "dev", fmt.Sprintf("davinci%d", minor)), &stat))
+		assert.EqualValues(t, unix.Mkdev(testDavinciMajor, minor), stat.Rdev)
+	}
+	// cgroup v1 is given every new rule, the last one stays in the file
+	content, err := ioutil.ReadFile(filepath.Join(cgroupDir, devicesAllowFile))
+	assert.Nil(t, err)
+	assert.EqualValues(t, "c 236:2 rwm", string(content))
+	// the nodes already exist
+	assert.Nil(t, doHooksProcess([]string{"inject", "--pid", "1", "--bundle", bundle}))
+
+	// the devices were added by ascend-docker-runtime
+	rootfs := filepath.Join(bundle, "rootfs")
+	spec = &specs.Spec{Process: &specs.Process{Env: []string{"ASCEND_VISIBLE_DEVICES=3"}},
+		Linux: &specs.Linux{Devices: []specs.LinuxDevice{{Path: "/dev/davinci3"}}}}
+	assert.Nil(t, injectHookDevices(1, rootfs, spec))
+	spec = &specs.Spec{Process: &specs.Process{Env: []string{"ASCEND_VISIBLE_DEVICES=void"}}, Linux: &specs.Linux{}}
+	assert.Nil(t, injectHookDevices(1, rootfs, spec))
+	spec = &specs.Spec{Process: &specs.Process{Env: []string{"ASCEND_VISIBLE_DEVICES=3",
+		"ASCEND_VNPU_SPECS=vir02"}}, Linux: &specs.Linux{}}
+	assert.NotNil(t, injectHookDevices(1, rootfs, spec))
+	_, err = os.Lstat(filepath.Join(rootfs, "dev", "davinci3"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestInjectHookDevicesNone(t *testing.T) {
+	skipWithoutMknod(t)
+	spec := &specs.Spec{Process: &specs.Process{Env: []string{"ASCEND_VISIBLE_DEVICES=none"}}, Linux: &specs.Linux{}}
+	stub, bundle, cgroupDir := stubHookBundle(t, spec, "5:devices:/abc")
+	defer stub.Reset()
+
+	// none still gets the manager devices
+	assert.Nil(t, doHooksProcess([]string{"inject", "--pid", "1", "--bundle", bundle}))
+	var stat unix.Stat_t
+	assert.Nil(t, unix.Lstat(filepath.Join(bundle, "rootfs", "dev", "davinci_manager"), &stat))
+	assert.EqualValues(t, unix.Mkdev(testManagerMajor, 0), stat.Rdev)
+	content, err := ioutil.ReadFile(filepath.Join(cgroupDir, devicesAllowFile))
+	assert.Nil(t, err)
+	assert.EqualValues(t, "c 237:0 rwm", string(content))
+	entries, err := ioutil.ReadDir(filepath.Join(bundle, "rootfs", "dev"))
+	assert.Nil(t, err)
+	assert.Len(t, entries, 1)
+
+	// the manager devices were added by ascend-docker-runtime
+	spec.Linux.Devices = []specs.LinuxDevice{{Path: "/dev/davinci_manager"}}
+	assert.Nil(t, injectHookDevices(1, filepath.Join(bundle, "rootfs"), spec))
+}
+
+func TestInjectHookDevicesCgroupV2(t *testing.T) {
+	spec := &specs.Spec{Process: &specs.Process{Env: []string{"ASCEND_VISIBLE_DEVICES=1,2"}}, Linux: &specs.Linux{
+		Resources: &specs.LinuxResources{Devices: []specs.LinuxDeviceCgroup{{Allow: false, Access: "rwm"}}}}}
+	stub, bundle, _ := stubHookBundle(t, spec, "0::/abc")
+	defer stub.Reset()
+	var applied []specs.LinuxDeviceCgroup
+	stub.ApplyFunc(replaceDeviceFilter, func(cgroupDir string, rules []specs.LinuxDeviceCgroup) error {
+		applied = rules
+		return nil
+	})
+	stub.ApplyFunc(createDeviceNode, func(pid int, rootfs string, device *specs.LinuxDevice) error {
+		return nil
+	})
+
+	assert.Nil(t, doHooksProcess([]string{"inject", "--pid", "1", "--bundle", bundle}))
+	insns, _, err := buildDeviceFilter(applied)
+	assert.Nil(t, err)
+	// the default devices of the runtime are still allowed besides the new ones
+	assert.EqualValues(t, 1, runDeviceFilter(t, insns, unix.BPF_DEVCG_DEV_CHAR,
+		unix.BPF_DEVCG_ACC_READ|unix.BPF_DEVCG_ACC_WRITE, 1, 3))
+	assert.EqualValues(t, 1, runDeviceFilter(t, insns, unix.BPF_DEVCG_DEV_CHAR, unix.BPF_DEVCG_ACC_READ,
+		testDavinciMajor, 2))
+	assert.EqualValues(t, 0, runDeviceFilter(t, insns, unix.BPF_DEVCG_DEV_CHAR, unix.BPF_DEVCG_ACC_READ,
+		testDavinciMajor, 3))
+}
+
+func TestGetBundleRootfs(t *testing.T) {
+	rootfs, err := getBundleRootfs("/run/containers/abc", &specs.Spec{Root: &specs.Root{Path: "rootfs"}})
+	assert.Nil(t, err)
+	assert.EqualValues(t, "/run/containers/abc/rootfs", rootfs)
+	rootfs, err = getBundleRootfs("/run/containers/abc", &specs.Spec{Root: &specs.Root{Path: "/var/lib/abc/"}})
+	assert.Nil(t, err)
+	assert.EqualValues(t, "/var/lib/abc", rootfs)
+	_, err = getBundleRootfs("/run/containers/abc", &specs.Spec{})
+	assert.NotNil(t, err)
+}
+
+func TestApplyDeviceRules(t *testing.T) {
+	var applied [][]specs.LinuxDeviceCgroup
+	stub := gomonkey.ApplyFunc(replaceDeviceFilter, func(cgroupDir string, rules []specs.LinuxDeviceCgroup) error {
+		applied = append(applied, rules)
+		return nil
+	})
+	defer stub.Reset()
+	rules := []specs.LinuxDeviceCgroup{newTestRule(false, "a", wildcardID, wildcardID, deviceCgroupAccessAll),
+		newTestRule(true, "c", testDavinciMajor, 1, deviceCgroupAccessAll),
+		newTestRule(true, "c", testDavinciMajor, 2, deviceCgroupAccessAll)}
+
+	// cgroup v2 takes the whole rule set once
+	assert.Nil(t, applyDeviceRules(&deviceCgroup{path: "/sys/fs/cgroup/abc", v2: true}, rules, 2))
+	assert.EqualValues(t, [][]specs.LinuxDeviceCgroup{rules}, applied)
+}
@@ -0,0 +1,379 @@
+/* Copyright(C) 2023. Huawei Technologies Co.,Ltd. All rights reserved.
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package injector adds Ascend devices, manager devices and driver environment to OCI specs
+package injector
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/containerd/containerd/oci"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"huawei.com/npu-exporter/v5/common-utils/hwlog"
+
+	"ascendconfig"
+	"main/dcmi"
+)
+
+const (
+	// Atlas200ISoc Product name
+	Atlas200ISoc = "Atlas 200I SoC A1"
+	// Atlas200 Product name
+	Atlas200 = "Atlas 200 Model 3000"
+	// Ascend310 ascend 310 chip
+	Ascend310 = "Ascend310"
+	// Ascend310P ascend 310P chip
+	Ascend310P = "Ascend310P"
+	// Ascend310B ascend 310B chip
+	Ascend310B = "Ascend310B"
+	// Ascend910 ascend 910 chip
+	Ascend910 = "Ascend910"
+	ascend    = "Ascend"
+
+	// DevicePath directory of the device nodes
+	DevicePath = "/dev/"
+	// DavinciName prefix of the davinci device nodes
+	DavinciName = "davinci"
+	// DavinciManager name of the manager device node
+	DavinciManager = "davinci_manager"
+
+	virtualDavinciName   = "vdavinci"
+	davinciManagerDocker = "davinci_manager_docker"
+	notRenameDeviceType  = ""
+	devmmSvm             = "devmm_svm"
+	hisiHdc              = "hisi_hdc"
+	svm0                 = "svm0"
+	tsAisle              = "ts_aisle"
+	upgrade              = "upgrade"
+	sys                  = "sys"
+	vdec                 = "vdec"
+	vpc                  = "vpc"
+	pngd                 = "pngd"
+	venc                 = "venc"
+	dvppCmdList          = "dvpp_cmdlist"
+	logDrv               = "log_drv"
+	acodec               = "acodec"
+	ai                   = "ai"
+	ao                   = "ao"
+	vo                   = "vo"
+	hdmi                 = "hdmi"
+
+	ldEnvKey  = "LD_LIBRARY_PATH"
+	envLength = 2
+)
+
+// GetDeviceTypeByChipName get device type by chipName
+func GetDeviceTypeByChipName(chipName string) string {
+	if strings.Contains(chipName, "310B") {
+		return Ascend310B
+	}
+	if strings.Contains(chipName, "310P") {
+		return Ascend310P
+	}
+	if strings.Contains(chipName, "310") {
+		return Ascend310
+	}
+	if strings.Contains(chipName, "910") {
+		return Ascend910
+	}
+	return ""
+}
+
+// getDeviceTypes get the device type of every device by phy id
+func getDeviceTypes(session *dcmi.Session) (map[int]string, error) {
+	chipNames, err := session.GetDeviceChipNames()
+	if err != nil {
+		return nil, err
+	}
+	deviceTypes := make(map[int]string, len(chipNames))
+	for phyID, chipName := range chipNames {
+		deviceTypes[int(phyID)] = GetDeviceTypeByChipName(chipName)
+	}
+	return deviceTypes, nil
+}
+
+// getRequestedDeviceTypes get the union of the device types of deviceIDs, the types of all devices on the host
+// are used when none of deviceIDs is a known davinci device, like a vdevice id or an empty request
+func getRequestedDeviceTypes(deviceIDs []int, session *dcmi.Session) (map[string]bool, error) {
+	deviceTypes, err := getDeviceTypes(session)
+	if err != nil {
+		return nil, err
+	}
+	requested := make(map[string]bool, len(deviceTypes))
+	for _, deviceID := range deviceIDs {
+		if devType := deviceTypes[deviceID]; devType != "" {
+			requested[devType] = true
+		}
+	}
+	if len(requested) != 0 {
+		return requested, nil
+	}
+	for _, devType := range deviceTypes {
+		if devType != "" {
+			requested[devType] = true
+		}
+	}
+	return requested, nil
+}
+
+// AddDeviceToSpec add the device node and its cgroup rule to spec, dPath is the path in the container
+// and the node is looked up under devRoot on the host
+func AddDeviceToSpec(spec *specs.Spec, devRoot string, dPath string, deviceType string) error {
+	device, err := oci.DeviceFromPath(ascendconfig.HostPath(devRoot, dPath))
+	if err != nil {
+		return fmt.Errorf("failed to get %s info : %#v", dPath, err)
+	}
+	device.Path = dPath
+
+	switch deviceType {
+	case virtualDavinciName:
+		vDeviceNumber := regexp.MustCompile("[0-9]+").FindAllString(dPath, -1)
+		if len(vDeviceNumber) != 1 {
+			return fmt.Errorf("invalid vdavinci path: %s", dPath)
+		}
+		device.Path = DevicePath + DavinciName + vDeviceNumber[0]
+	case davinciManagerDocker:
+		device.Path = DevicePath + DavinciManager
+	default:
+		// do nothing
+	}
+
+	spec.Linux.Devices = append(spec.Linux.Devices, *device)
+	newDeviceCgroup := specs.LinuxDeviceCgroup{
+		Allow:  true,
+		Type:   device.Type,
+		Major:  &device.Major,
+		Minor:  &device.Minor,
+		Access: "rwm",
+	}
+	spec.Linux.Resources.Devices = append(spec.Linux.Resources.Devices, newDeviceCgroup)
+	return nil
+}
+
+func addAscend310BManagerDevice(spec *specs.Spec, devRoot string) error {
+	var Ascend310BManageDevices = []string{
+		svm0,
+		tsAisle,
+		upgrade,
+		sys,
+		vdec,
+		vpc,
+		pngd,
+		venc,
+		dvppCmdList,
+		logDrv,
+		acodec,
+		ai,
+		ao,
+		vo,
+		hdmi,
+	}
+
+	for _, device := range Ascend310BManageDevices {
+		dPath := DevicePath + device
+		if err := AddDeviceToSpec(spec, devRoot, dPath, notRenameDeviceType); err != nil {
+			hwlog.RunLog.Warnf("failed to add %s to spec : %#v", dPath, err)
+		}
+	}
+
+	davinciManagerPath := DevicePath + davinciManagerDocker
+	if _, err := os.Stat(ascendconfig.HostPath(devRoot, davinciManagerPath)); err != nil {
+		hwlog.RunLog.Warnf("failed to get davinci manager docker, err: %#v", err)
+		davinciManagerPath = DevicePath + DavinciManager
+		if _, err := os.Stat(ascendconfig.HostPath(devRoot, davinciManagerPath)); err != nil {
+			return fmt.Errorf("failed to get davinci manager, err: %#v", err)
+		}
+	}
+	return AddDeviceToSpec(spec, devRoot, davinciManagerPath, davinciManagerDocker)
+}
+
+func addCommonManagerDevice(spec *specs.Spec, devRoot string) error {
+	var commonManagerDevices = []string{
+		devmmSvm,
+		hisiHdc,
+	}
+
+	for _, device := range commonManagerDevices {
+		dPath := DevicePath + device
+		if err := AddDeviceToSpec(spec, devRoot, dPath, notRenameDeviceType); err != nil {
+			return fmt.Errorf("failed to add common manage device to spec : %#v", err)
+		}
+	}
+
+	return nil
+}
+
+// AddManagerDevice add the manager devices of the chip types of deviceIDs to spec
+func AddManagerDevice(spec *specs.Spec, deviceIDs []int, devRoot string, session *dcmi.Session) error {
+	devTypes, err := getRequestedDeviceTypes(deviceIDs, session)
+	if err != nil {
+		return fmt.Errorf("get chip name error: %#v", err)
+	}
+	hwlog.RunLog.Infof("device types are: %v", devTypes)
+	if devTypes[Ascend310B] {
+		if err = addAscend310BManagerDevice(spec, devRoot); err != nil {
+			return err
+		}
+		// davinci_manager is already added by its docker variant
+		if len(devTypes) == 1 {
+			return nil
+		}
+	} else if err = AddDeviceToSpec(spec, devRoot, DevicePath+DavinciManager, notRenameDeviceType); err != nil {
+		return fmt.Errorf("add davinci_manager to spec error: %#v", err)
+	}
+
+	productType, err := session.GetProductType()
+	if err != nil {
+		return fmt.Errorf("parse product type error: %#v", err)
+	}
+	hwlog.RunLog.Infof("product type is %s", productType)
+
+	switch productType {
+	// do nothing
+	case Atlas200ISoc, Atlas200:
+	default:
+		if err = addCommonManagerDevice(spec, devRoot); err != nil {
+			return fmt.Errorf("add common manage device error: %#v", err)
+		}
+	}
+
+	return nil
+}
+
+// CheckDeviceHealth check the health of a davinci device, policy decides whether an unhealthy one is an error
+func CheckDeviceHealth(deviceID int, policy string, session *dcmi.Session) error {
+	if policy == ascendconfig.UnhealthyPolicyIgnore {
+		return nil
+	}
+	var reason string
+	health, err := session.GetDeviceHealth(int32(deviceID))
+	if err != nil {
+		reason = fmt.Sprintf("health of %s%d is unknown: %v", DavinciName, deviceID, err)
+	} else if !health.IsHealthy() {
+		reason = fmt.Sprintf("%s%d is unhealthy, %s", DavinciName, deviceID, health)
+	} else {
+		return nil
+	}
+	if policy == ascendconfig.UnhealthyPolicyReject {
+		return fmt.Errorf("%s", reason)
+	}
+	hwlog.RunLog.Warnf("%s", reason)
+	return nil
+}
+
+// AddDevice add the davinci devices of deviceIDs and the manager devices to spec, the device nodes are looked up
+// under the dev root of cfg and the health of every davinci device is checked by the policy of cfg
+func AddDevice(spec *specs.Spec, deviceIDs []int, cfg *ascendconfig.Config, session *dcmi.Session) error {
+	deviceName := DavinciName
+	if strings.Contains(GetValueFromSpec(spec, AscendRuntimeOptions), "VIRTUAL") {
+		deviceName = virtualDavinciName
+	}
+	devRoot := cfg.GetDevRoot()
+	added := len(spec.Linux.Devices)
+	for _, deviceID := range deviceIDs {
+		// the ids of vdevices are not known by dcmi
+		if deviceName == DavinciName {
+			if err := CheckDeviceHealth(deviceID, cfg.UnhealthyDevicePolicy, session); err != nil {
+				return err
+			}
+		}
+		dPath := DevicePath + deviceName + strconv.Itoa(deviceID)
+		if err := AddDeviceToSpec(spec, devRoot, dPath, deviceName); err != nil {
+			return fmt.Errorf("failed to add davinci device to spec: %v", err)
+		}
+	}
+
+	if err := AddManagerDevice(spec, deviceIDs, devRoot, session); err != nil {
+		return fmt.Errorf("failed to add Manager device to spec: %v", err)
+	}
+	applyDeviceOwners(spec, spec.Linux.Devices[added:], cfg)
+
+	return nil
+}
+
+// AddLDEnv append ldEnvValue to LD_LIBRARY_PATH of the container, empty means not to set it
+func AddLDEnv(spec *specs.Spec, ldEnvValue string) error {
+	if ldEnvValue == "" {
+		return nil
+	}
+	for i, val := range spec.Process.Env {
+		kv := strings.Split(val, "=")
+		if len(kv) != envLength {
+			continue
+		}
+		k, v := kv[0], kv[1]
+		if k != ldEnvKey {
+			continue
+		}
+		// update the existing entry, a second LD_LIBRARY_PATH would be ambiguous
+		if v != "" {
+			ldEnvValue = v + ":" + ldEnvValue
+		}
+		spec.Process.Env[i] = ldEnvKey + "=" + ldEnvValue
+		return nil
+	}
+	spec.Process.Env = append(spec.Process.Env, ldEnvKey+"="+ldEnvValue)
+	return nil
+}
+
+// GetAllDevices get the phy id of every davinci device on the host
+func GetAllDevices(session *dcmi.Session) ([]int, error) {
+	npuDevices, err := session.GetNpuDevices()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get all devices: %v", err)
+	}
+	devices := make([]int, 0, len(npuDevices))
+	for _, npuDevice := range npuDevices {
+		devices = append(devices, int(npuDevice.PhyID))
+	}
+	sort.Ints(devices)
+	return removeDuplication(devices), nil
+}
+
+func removeDuplication(devices []int) []int {
+	list := make([]int, 0, len(devices))
+	prev := -1
+
+	for _, device := range devices {
+		if device == prev {
+			continue
+		}
+
+		list = append(list, device)
+		prev = device
+	}
+
+	return list
+}
+
+// NewSession create the dcmi session of an invocation, the topology is served from the cache configured in cfg
+func NewSession(cfg *ascendconfig.Config) *dcmi.Session {
+	session := dcmi.NewSession(&dcmi.NpuWorker{})
+	if cfg.TopologyCache == "" {
+		return session
+	}
+	cache, err := dcmi.NewTopologyCache(cfg.TopologyCache,
+		ascendconfig.HostPath(cfg.GetDriverRoot(), ascendconfig.DriverVersionInfo))
+	if err != nil {
+		hwlog.RunLog.Warnf("topology cache is not used: %v", err)
+		return session
+	}
+	session.UseTopologyCache(cache)
+	return session
+}
@@ -0,0 +1,417 @@
+/* Copyright(C) 2023. Huawei Technologies Co.,Ltd. All rights reserved.
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package injector
+package injector
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/agiledragon/gomonkey/v2"
+	"github.com/containerd/containerd/oci"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/stretchr/testify/assert"
+	"huawei.com/npu-exporter/v5/common-utils/hwlog"
+
+	"ascendconfig"
+	"main/dcmi"
+)
+
+func initTestLog(ctx context.Context) error {
+	const backups = 2
+	const logMaxAge = 365
+	runLogConfig := hwlog.LogConfig{
+		LogFileName: ascendconfig.Default().LogFile("runtime-run.log"),
+		LogLevel:    0,
+		MaxBackups:  backups,
+		MaxAge:      logMaxAge,
+		OnlyToFile:  true,
+		FileMaxSize: 2,
+	}
+	return hwlog.InitRunLogger(&runLogConfig, ctx)
+}
+
+func TestRemoveDuplication(t *testing.T) {
+	originList := []int{1, 2, 2, 4, 5, 5, 5, 6, 8, 8}
+	targetList := []int{1, 2, 4, 5, 6, 8}
+	resultList := removeDuplication(originList)
+
+	assert.EqualValues(t, targetList, resultList)
+}
+
+func TestGetDeviceTypeByChipName0(t *testing.T) {
+	chipName := "310B"
+	devType := GetDeviceTypeByChipName(chipName)
+	assert.EqualValues(t, Ascend310B, devType)
+}
+
+func TestGetDeviceTypeByChipName1(t *testing.T) {
+	chipName := "310P"
+	devType := GetDeviceTypeByChipName(chipName)
+	assert.EqualValues(t, Ascend310P, devType)
+}
+
+func TestGetDeviceTypeByChipName2(t *testing.T) {
+	chipName := "310"
+	devType := GetDeviceTypeByChipName(chipName)
+	assert.EqualValues(t, Ascend310, devType)
+}
+
+func TestGetDeviceTypeByChipName3(t *testing.T) {
+	chipName := "910"
+	devType := GetDeviceTypeByChipName(chipName)
+	assert.EqualValues(t, Ascend910, devType)
+}
+
+func TestGetDeviceTypeByChipName4(t *testing.T) {
+	chipName := "980b"
+	devType := GetDeviceTypeByChipName(chipName)
+	assert.EqualValues(t, "", devType)
+}
+
+func TestAddDeviceToSpec0(t *testing.T) {
+	devPath := "/dev/davinci0"
+	statStub := gomonkey.ApplyFunc(oci.DeviceFromPath, func(name string) (*specs.LinuxDevice, error) {
+		return &specs.LinuxDevice{
+			Path: devPath,
+		}, nil
+	})
+	defer statStub.Reset()
+
+	spec := specs.Spec{
+		Linux: &specs.Linux{
+			Devices: []specs.LinuxDevice{},
+			Resources: &specs.LinuxResources{
+				Devices: []specs.LinuxDeviceCgroup{},
+			},
+		},
+	}
+
+	err := AddDeviceToSpec(&spec, "/", devPath, notRenameDeviceType)
+	assert.Nil(t, err)
+	assert.Contains(t, spec.Linux.Devices[0].Path, devPath)
+}
+
+func TestAddDeviceToSpecDevRoot(t *testing.T) {
+	var lookupPath string
+	statStub := gomonkey.ApplyFunc(oci.DeviceFromPath, func(name string) (*specs.LinuxDevice, error) {
+		lookupPath = name
+		return &specs.LinuxDevice{Path: name}, nil
+	})
+	defer statStub.Reset()
+
+	spec := specs.Spec{
+		Linux: &specs.Linux{
+			Devices: []specs.LinuxDevice{},
+			Resources: &specs.LinuxResources{
+				Devices: []specs.LinuxDeviceCgroup{},
+			},
+		},
+	}
+
+	err := AddDeviceToSpec(&spec, "/run/ascend/driver", "/dev/vdavinci105", virtualDavinciName)
+	assert.Nil(t, err)
+	assert.EqualValues(t, "/run/ascend/driver/dev/vdavinci105", lookupPath)
+	assert.EqualValues(t, "/dev/davinci105", spec.Linux.Devices[0].Path)
+}
+
+func TestAddAscend310BManagerDevice(t *testing.T) {
+	statStub := gomonkey.ApplyFunc(AddDeviceToSpec, func(spec *specs.Spec, devRoot string, dPath string, deviceType string) error {
+		return nil
+	})
+	defer statStub.Reset()
+
+	pathStub := gomonkey.ApplyFunc(os.Stat, func(name string) (os.FileInfo, error) {
+		return nil, nil
+	})
+	defer pathStub.Reset()
+
+	spec := specs.Spec{
+		Linux: &specs.Linux{
+			Devices: []specs.LinuxDevice{},
+			Resources: &specs.LinuxResources{
+				Devices: []specs.LinuxDeviceCgroup{},
+			},
+		},
+	}
+
+	err := addAscend310BManagerDevice(&spec, "/")
+	assert.Nil(t, err)
+}
+
+func TestAddCommonManagerDevice(t *testing.T) {
+	statStub := gomonkey.ApplyFunc(AddDeviceToSpec, func(spec *specs.Spec, devRoot string, dPath string, deviceType string) error {
+		return nil
+	})
+	defer statStub.Reset()
+
+	spec := specs.Spec{
+		Linux: &specs.Linux{
+			Devices: []specs.LinuxDevice{},
+			Resources: &specs.LinuxResources{
+				Devices: []specs.LinuxDeviceCgroup{},
+			},
+		},
+	}
+
+	err := addCommonManagerDevice(&spec, "/")
+	assert.Nil(t, err)
+}
+
+func TestAddManagerDevice(t *testing.T) {
+	devPath := "/dev/mockdevice"
+	statStub := gomonkey.ApplyFunc(oci.DeviceFromPath, func(dPath string) (*specs.LinuxDevice, error) {
+		return &specs.LinuxDevice{
+			Path: devPath,
+		}, nil
+	})
+	defer statStub.Reset()
+
+	sessionType := reflect.TypeOf(&dcmi.Session{})
+	dcmiStub := gomonkey.ApplyMethod(sessionType, "GetDeviceChipNames",
+		func(_ *dcmi.Session) (map[int32]string, error) {
+			return map[int32]string{0: "910B", 1: "310P3"}, nil
+		})
+	defer dcmiStub.Reset()
+
+	productStub := gomonkey.ApplyMethod(sessionType, "GetProductType", func(_ *dcmi.Session) (string, error) {
+		return "", nil
+	})
+	defer productStub.Reset()
+
+	spec := specs.Spec{
+		Linux: &specs.Linux{
+			Devices: []specs.LinuxDevice{},
+			Resources: &specs.LinuxResources{
+				Devices: []specs.LinuxDeviceCgroup{},
+			},
+		},
+	}
+	ctx := context.Background()
+	err := initTestLog(ctx)
+	assert.Nil(t, err)
+	err = AddManagerDevice(&spec, []int{0}, "/", dcmi.NewSession(&dcmi.NpuWorker{}))
+	assert.Nil(t, err)
+	// davinci_manager, devmm_svm and hisi_hdc
+	assert.EqualValues(t, 3, len(spec.Linux.Devices))
+
+	// the types of all devices are used for vdevice ids
+	spec.Linux.Devices = nil
+	err = AddManagerDevice(&spec, []int{100}, "/", dcmi.NewSession(&dcmi.NpuWorker{}))
+	assert.Nil(t, err)
+	assert.EqualValues(t, 3, len(spec.Linux.Devices))
+}
+
+func TestGetRequestedDeviceTypes(t *testing.T) {
+	stub := gomonkey.ApplyMethod(reflect.TypeOf(&dcmi.Session{}), "GetDeviceChipNames",
+		func(_ *dcmi.Session) (map[int32]string, error) {
+			return map[int32]string{0: "310P3", 1: "310P3", 2: "910B", 3: "unknown"}, nil
+		})
+	defer stub.Reset()
+	session := dcmi.NewSession(&dcmi.NpuWorker{})
+
+	devTypes, err := getRequestedDeviceTypes([]int{0, 1}, session)
+	assert.Nil(t, err)
+	assert.EqualValues(t, map[string]bool{Ascend310P: true}, devTypes)
+	devTypes, err = getRequestedDeviceTypes([]int{1, 2}, session)
+	assert.Nil(t, err)
+	assert.EqualValues(t, map[string]bool{Ascend310P: true, Ascend910: true}, devTypes)
+	devTypes, err = getRequestedDeviceTypes([]int{}, session)
+	assert.Nil(t, err)
+	assert.EqualValues(t, map[string]bool{Ascend310P: true, Ascend910: true}, devTypes)
+}
+
+func TestAddDevice(t *testing.T) {
+	devPath := "/dev/davinci1"
+	statStub := gomonkey.ApplyFunc(oci.DeviceFromPath, func(name string) (*specs.LinuxDevice, error) {
+		return &specs.LinuxDevice{
+			Path: devPath,
+		}, nil
+	})
+	defer statStub.Reset()
+
+	manageDeviceStub := gomonkey.ApplyFunc(AddManagerDevice, func(spec *specs.Spec, deviceIDs []int, devRoot string,
+		session *dcmi.Session) error {
+		return nil
+	})
+	defer manageDeviceStub.Reset()
+
+	spec := specs.Spec{
+		Linux: &specs.Linux{
+			Devices: []specs.LinuxDevice{},
+			Resources: &specs.LinuxResources{
+				Devices: []specs.LinuxDeviceCgroup{},
+			},
+		},
+		Process: &specs.Process{
+			Env: []string{"KUBE_DNS_PORT_53_UDP_PORT=53",
+				"ASCEND_VISIBLE_DEVICES=1",
+				"ASCEND_RUNTIME_OPTIONS=",
+				"KUBE_DNS_PORT_53_UDP_PROTO=udp"},
+		},
+	}
+
+	ctx := context.Background()
+	err := initTestLog(ctx)
+	assert.Nil(t, err)
+	// the default policy never queries dcmi
+	queried := false
+	healthStub := gomonkey.ApplyMethod(reflect.TypeOf(&dcmi.Session{}), "GetDeviceHealth",
+		func(_ *dcmi.Session, phyID int32) (*dcmi.DeviceHealth, error) {
+			queried = true
+			return &dcmi.DeviceHealth{PhyID: phyID}, nil
+		})
+	defer healthStub.Reset()
+	err = AddDevice(&spec, []int{1}, ascendconfig.Default(), dcmi.NewSession(&dcmi.NpuWorker{}))
+	assert.Nil(t, err)
+	assert.Contains(t, spec.Linux.Devices[0].Path, devPath)
+	assert.False(t, queried)
+}
+
+func TestCheckDeviceHealth(t *testing.T) {
+	stub := gomonkey.ApplyMethod(reflect.TypeOf(&dcmi.Session{}), "GetDeviceHealth",
+		func(_ *dcmi.Session, phyID int32) (*dcmi.DeviceHealth, error) {
+			switch phyID {
+			case 0:
+				return &dcmi.DeviceHealth{PhyID: phyID, State: dcmi.HealthMinorAlarm}, nil
+			case 1:
+				return &dcmi.DeviceHealth{PhyID: phyID, State: dcmi.HealthCriticalAlarm,
+					ErrorCodes: []uint32{0x80e01801}}, nil
+			default:
+				return nil, fmt.Errorf("device not found")
+			}
+		})
+	defer stub.Reset()
+	session := dcmi.NewSession(&dcmi.NpuWorker{})
+
+	for _, policy := range []string{ascendconfig.UnhealthyPolicyIgnore, ascendconfig.UnhealthyPolicyWarn,
+		ascendconfig.UnhealthyPolicyReject} {
+		assert.Nil(t, CheckDeviceHealth(0, policy, session))
+	}
+	assert.Nil(t, CheckDeviceHealth(1, ascendconfig.UnhealthyPolicyIgnore, session))
+	assert.Nil(t, CheckDeviceHealth(1, ascendconfig.UnhealthyPolicyWarn, session))
+	assert.NotNil(t, CheckDeviceHealth(1, ascendconfig.UnhealthyPolicyReject, session))
+	assert.Nil(t, CheckDeviceHealth(2, ascendconfig.UnhealthyPolicyWarn, session))
+	assert.NotNil(t, CheckDeviceHealth(2, ascendconfig.UnhealthyPolicyReject, session))
+}
+
+func TestAddLDEnv(t *testing.T) {
+	spec := specs.Spec{Process: &specs.Process{Env: []string{"LD_LIBRARY_PATH=/usr/lib"}}}
+	assert.Nil(t, AddLDEnv(&spec, "/opt/driver/lib64"))
+	assert.EqualValues(t, []string{"LD_LIBRARY_PATH=/usr/lib:/opt/driver/lib64"}, spec.Process.Env)
+
+	spec.Process.Env = []string{}
+	assert.Nil(t, AddLDEnv(&spec, ""))
+	assert.Empty(t, spec.Process.Env)
+}
+
+func TestGetDeviceMetadata(t *testing.T) {
+	sessionType := reflect.TypeOf(&dcmi.Session{})
+	stub := gomonkey.ApplyMethod(sessionType, "GetNpuDevices", func(_ *dcmi.Session) ([]dcmi.NpuDevice, error) {
+		return []dcmi.NpuDevice{{PhyID: 0, LogicID: 0, CardID: 0, DeviceID: 0},
+			{PhyID: 1, LogicID: 2, CardID: 1, DeviceID: 0}}, nil
+	})
+	defer stub.Reset()
+	stub.ApplyMethod(sessionType, "GetDeviceChipNames", func(_ *dcmi.Session) (map[int32]string, error) {
+		return map[int32]string{0: "910B", 1: "310P3"}, nil
+	})
+	stub.ApplyMethod(sessionType, "GetProductType", func(_ *dcmi.Session) (string, error) {
+		return "Atlas 800", nil
+	})
+	stub.ApplyFunc(dcmi.ReadDriverVersion, func(versionInfoPath string) (string, error) {
+		return "23.0.rc2", nil
+	})
+	session := dcmi.NewSession(&dcmi.NpuWorker{})
+
+	metadata, err := GetDeviceMetadata([]int{1}, []int{100}, ascendconfig.Default(), session)
+	assert.Nil(t, err)
+	assert.EqualValues(t, "310P3", metadata.ChipName)
+	spec := &specs.Spec{Process: &specs.Process{Env: []string{AscendChipName + "=fake"}}}
+	AddDeviceMetadataEnv(spec, metadata)
+	assert.EqualValues(t, []string{AscendChipName + "=310P3", AscendDevicePhyIDs + "=1",
+		AscendDeviceLogicIDs + "=2", AscendDeviceCardIDs + "=1:0", AscendProductType + "=Atlas 800",
+		AscendDriverVersion + "=23.0.rc2", AscendVnpuIDs + "=100"}, spec.Process.Env)
+
+	_, err = GetDeviceMetadata([]int{2}, nil, ascendconfig.Default(), session)
+	assert.NotNil(t, err)
+}
+
+func TestGetRankTable(t *testing.T) {
+	stub := gomonkey.ApplyMethod(reflect.TypeOf(&dcmi.Session{}), "GetDeviceIP",
+		func(_ *dcmi.Session, phyID int32) (string, error) {
+			if phyID > 1 {
+				return "", fmt.Errorf("no roce port")
+			}
+			return fmt.Sprintf("192.168.100.%d", phyID+100), nil
+		})
+	defer stub.Reset()
+	session := dcmi.NewSession(&dcmi.NpuWorker{})
+
+	rankTable, err := GetRankTable([]int{1, 0}, "10.0.0.1", session)
+	assert.Nil(t, err)
+	assert.EqualValues(t, "1", rankTable.ServerCount)
+	assert.EqualValues(t, "10.0.0.1", rankTable.ServerList[0].ServerID)
+	assert.EqualValues(t, []RankTableDevice{{DeviceID: "1", DeviceIP: "192.168.100.101", RankID: "0"},
+		{DeviceID: "0", DeviceIP: "192.168.100.100", RankID: "1"}}, rankTable.ServerList[0].Devices)
+	_, err = GetRankTable([]int{0, 2}, "10.0.0.1", session)
+	assert.NotNil(t, err)
+}
+
+func TestApplyDeviceOwners(t *testing.T) {
+	const (
+		hostGroup    = 1000
+		mappedBase   = 100000
+		deviceNumber = 3
+	)
+	newDevices := func() []specs.LinuxDevice {
+		devices := make([]specs.LinuxDevice, 0, deviceNumber)
+		for i := 0; i < deviceNumber; i++ {
+			uid, gid := uint32(0), uint32(hostGroup)
+			devices = append(devices, specs.LinuxDevice{Path: fmt.Sprintf("/dev/davinci%d", i), UID: &uid, GID: &gid})
+		}
+		return devices
+	}
+	cfg := ascendconfig.Default()
+	spec := &specs.Spec{Process: &specs.Process{}, Linux: &specs.Linux{}}
+	devices := newDevices()
+	applyDeviceOwners(spec, devices, cfg)
+	assert.EqualValues(t, hostGroup, *devices[0].GID)
+	assert.Empty(t, spec.Process.User.AdditionalGids)
+
+	// the host group is mapped into the container, root of the host is not
+	spec.Linux.UIDMappings = []specs.LinuxIDMapping{{ContainerID: 0, HostID: mappedBase, Size: 65536}}
+	spec.Linux.GIDMappings = []specs.LinuxIDMapping{{ContainerID: 0, HostID: mappedBase, Size: 65536},
+		{ContainerID: 2000, HostID: hostGroup, Size: 1}}
+	applyDeviceOwners(spec, devices, cfg)
+	assert.EqualValues(t, 0, *devices[1].UID)
+	assert.EqualValues(t, 2000, *devices[1].GID)
+	assert.EqualValues(t, []uint32{2000}, spec.Process.User.AdditionalGids)
+
+	spec.Process.User.AdditionalGids = nil
+	spec.Linux.GIDMappings = spec.Linux.GIDMappings[:1]
+	devices = newDevices()
+	applyDeviceOwners(spec, devices, cfg)
+	assert.EqualValues(t, 0, *devices[2].GID)
+	assert.Empty(t, spec.Process.User.AdditionalGids)
+
+	deviceGID := uint32(44)
+	cfg.DeviceGID = &deviceGID
+	applyDeviceOwners(spec, devices, cfg)
+	assert.EqualValues(t, deviceGID, *devices[0].GID)
+	assert.EqualValues(t, []uint32{deviceGID}, spec.Process.User.AdditionalGids)
+}
@@ -0,0 +1,146 @@
+/* Copyright(C) 2023. Huawei Technologies Co.,Ltd. All rights reserved.
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package injector
+package injector
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"huawei.com/npu-exporter/v5/common-utils/hwlog"
+
+	"ascendconfig"
+	"main/dcmi"
+)
+
+// ENV telling the container what devices it got
+const (
+	// AscendDevicePhyIDs ENV of the phy ids of the devices, like 0,1
+	AscendDevicePhyIDs = "ASCEND_DEVICE_PHY_IDS"
+	// AscendDeviceLogicIDs ENV of the logic ids of the devices
+	AscendDeviceLogicIDs = "ASCEND_DEVICE_LOGIC_IDS"
+	// AscendDeviceCardIDs ENV of the card id and device id pairs of the devices, like 0:0,1:0
+	AscendDeviceCardIDs = "ASCEND_DEVICE_CARD_IDS"
+	// AscendChipName ENV of the chip name, like 910B
+	AscendChipName = "ASCEND_CHIP_NAME"
+	// AscendProductType ENV of the product type, empty when no device reports it
+	AscendProductType = "ASCEND_PRODUCT_TYPE"
+	// AscendDriverVersion ENV of the driver version on the host
+	AscendDriverVersion = "ASCEND_DRIVER_VERSION"
+	// AscendVnpuIDs ENV of the vnpu ids
+	AscendVnpuIDs = "ASCEND_VNPU_IDS"
+
+	// DeviceMetadataPath file of the device metadata in the container
+	DeviceMetadataPath = "/run/ascend/devices.json"
+)
+
+// DeviceInfo a device of the container
+type DeviceInfo struct {
+	dcmi.NpuDevice
+	ChipName string `json:"chip_name"`
+}
+
+// DeviceMetadata what devices the container got
+type DeviceMetadata struct {
+	Devices       []DeviceInfo `json:"devices"`
+	VnpuIDs       []int        `json:"vnpu_ids,omitempty"`
+	ChipName      string       `json:"chip_name"`
+	ProductType   string       `json:"product_type"`
+	DriverVersion string       `json:"driver_version"`
+}
+
+// GetDeviceMetadata collect the metadata of the physical devices and vnpu of the container from the session
+func GetDeviceMetadata(physicalIDs, vnpuIDs []int, cfg *ascendconfig.Config,
+	session *dcmi.Session) (*DeviceMetadata, error) {
+	npuDevices, err := session.GetNpuDevices()
+	if err != nil {
+		return nil, err
+	}
+	chipNames, err := session.GetDeviceChipNames()
+	if err != nil {
+		return nil, err
+	}
+	metadata := &DeviceMetadata{Devices: make([]DeviceInfo, 0, len(physicalIDs)), VnpuIDs: vnpuIDs}
+	for _, id := range physicalIDs {
+		found := false
+		for _, device := range npuDevices {
+			if int(device.PhyID) == id {
+				metadata.Devices = append(metadata.Devices, DeviceInfo{NpuDevice: device,
+					ChipName: chipNames[device.PhyID]})
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("%s%d is not found", DavinciName, id)
+		}
+	}
+	if len(metadata.Devices) != 0 && metadata.Devices[0].ChipName != "" {
+		metadata.ChipName = metadata.Devices[0].ChipName
+	} else if metadata.ChipName, err = session.GetChipName(); err != nil {
+		return nil, err
+	}
+	if metadata.ProductType, err = session.GetProductType(); err != nil {
+		return nil, err
+	}
+	metadata.DriverVersion, err = dcmi.ReadDriverVersion(ascendconfig.HostPath(cfg.GetDriverRoot(),
+		ascendconfig.DriverVersionInfo))
+	if err != nil {
+		hwlog.RunLog.Warnf("driver version is unknown: %v", err)
+	}
+	return metadata, nil
+}
+
+func joinInts(ids []int) string {
+	words := make([]string, 0, len(ids))
+	for _, id := range ids {
+		words = append(words, strconv.Itoa(id))
+	}
+	return strings.Join(words, ",")
+}
+
+// setEnv set the ENV of the container, the value given by the user is replaced
+func setEnv(spec *specs.Spec, key, value string) {
+	for i, envLine := range spec.Process.Env {
+		if strings.HasPrefix(envLine, key+"=") {
+			spec.Process.Env[i] = key + "=" + value
+			return
+		}
+	}
+	spec.Process.Env = append(spec.Process.Env, key+"="+value)
+}
+
+// AddDeviceMetadataEnv tell the container its devices through ENV
+func AddDeviceMetadataEnv(spec *specs.Spec, metadata *DeviceMetadata) {
+	phyIDs := make([]int, 0, len(metadata.Devices))
+	logicIDs := make([]int, 0, len(metadata.Devices))
+	cardIDs := make([]string, 0, len(metadata.Devices))
+	for _, device := range metadata.Devices {
+		phyIDs = append(phyIDs, int(device.PhyID))
+		logicIDs = append(logicIDs, int(device.LogicID))
+		cardIDs = append(cardIDs, fmt.Sprintf("%d:%d", device.CardID, device.DeviceID))
+	}
+	setEnv(spec, AscendDevicePhyIDs, joinInts(phyIDs))
+	setEnv(spec, AscendDeviceLogicIDs, joinInts(logicIDs))
+	setEnv(spec, AscendDeviceCardIDs, strings.Join(cardIDs, ","))
+	setEnv(spec, AscendChipName, metadata.ChipName)
+	setEnv(spec, AscendProductType, metadata.ProductType)
+	setEnv(spec, AscendDriverVersion, metadata.DriverVersion)
+	if len(metadata.VnpuIDs) != 0 {
+		setEnv(spec, AscendVnpuIDs, joinInts(metadata.VnpuIDs))
+	}
+}
@@ -0,0 +1,72 @@
+/* Copyright(C) 2023. Huawei Technologies Co.,Ltd. All rights reserved.
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package injector
+package injector
+
+import (
+	"fmt"
+	"strconv"
+
+	"main/dcmi"
+)
+
+const (
+	// RankTableFile ENV of the path of the hccl rank table
+	RankTableFile = "RANK_TABLE_FILE"
+
+	rankTableVersion         = "1.0"
+	rankTableStatusCompleted = "completed"
+	singleServerCount        = "1"
+)
+
+// RankTableDevice a device of the rank table
+type RankTableDevice struct {
+	DeviceID string `json:"device_id"`
+	DeviceIP string `json:"device_ip"`
+	RankID   string `json:"rank_id"`
+}
+
+// RankTableServer a server of the rank table
+type RankTableServer struct {
+	ServerID string            `json:"server_id"`
+	Devices  []RankTableDevice `json:"device"`
+}
+
+// RankTable hccl rank table in the 1.0 format
+type RankTable struct {
+	Version     string            `json:"version"`
+	ServerCount string            `json:"server_count"`
+	ServerList  []RankTableServer `json:"server_list"`
+	Status      string            `json:"status"`
+}
+
+// GetRankTable build the single server rank table of the devices, the ranks follow the order of the devices
+func GetRankTable(deviceIDs []int, serverID string, session *dcmi.Session) (*RankTable, error) {
+	devices := make([]RankTableDevice, 0, len(deviceIDs))
+	for rank, id := range deviceIDs {
+		deviceIP, err := session.GetDeviceIP(int32(id))
+		if err != nil {
+			return nil, fmt.Errorf("failed to get ip of %s%d: %v", DavinciName, id, err)
+		}
+		devices = append(devices, RankTableDevice{DeviceID: strconv.Itoa(id), DeviceIP: deviceIP,
+			RankID: strconv.Itoa(rank)})
+	}
+	return &RankTable{
+		Version:     rankTableVersion,
+		ServerCount: singleServerCount,
+		ServerList:  []RankTableServer{{ServerID: serverID, Devices: devices}},
+		Status:      rankTableStatusCompleted,
+	}, nil
+}
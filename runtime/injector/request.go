@@ -0,0 +1,396 @@
+/* Copyright(C) 2023. Huawei Technologies Co.,Ltd. All rights reserved.
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package injector
+package injector
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"huawei.com/npu-exporter/v5/common-utils/hwlog"
+
+	"ascendconfig"
+	"main/dcmi"
+)
+
+const (
+	// AscendVisibleDevices ENV of the device request
+	AscendVisibleDevices = "ASCEND_VISIBLE_DEVICES"
+	// AscendRuntimeOptions ENV of the runtime options like VIRTUAL and NODRV
+	AscendRuntimeOptions = "ASCEND_RUNTIME_OPTIONS"
+	// AscendRuntimeMounts ENV of the mount lists under /etc/ascend-docker-runtime.d
+	AscendRuntimeMounts = "ASCEND_RUNTIME_MOUNTS"
+
+	// AscendVisibleDevicesAnnotation annotation equivalent to ASCEND_VISIBLE_DEVICES, it takes precedence over ENV
+	AscendVisibleDevicesAnnotation = "huawei.com/ascend.visible-devices"
+	// AscendRuntimeOptionsAnnotation annotation equivalent to ASCEND_RUNTIME_OPTIONS
+	AscendRuntimeOptionsAnnotation = "huawei.com/ascend.runtime-options"
+	// AscendRuntimeMountsAnnotation annotation equivalent to ASCEND_RUNTIME_MOUNTS
+	AscendRuntimeMountsAnnotation = "huawei.com/ascend.runtime-mounts"
+
+	// keywords of ASCEND_VISIBLE_DEVICES
+	visibleDevicesAll  = "all"
+	visibleDevicesNone = "none"
+	visibleDevicesVoid = "void"
+	// VisibleDevicesAuto prefix of the requests like auto:2 asking the runtime to pick free devices
+	VisibleDevicesAuto = "auto:"
+	maxAutoDevices     = 128
+
+	// CDIKind kind of the Ascend CDI devices
+	CDIKind = "huawei.com/npu"
+	// CDIAllDevice name of the CDI device containing every davinci device
+	CDIAllDevice = "all"
+
+	kvPairSize = 2
+	borderNum  = 2
+)
+
+var envAnnotations = map[string]string{
+	AscendVisibleDevices: AscendVisibleDevicesAnnotation,
+	AscendRuntimeOptions: AscendRuntimeOptionsAnnotation,
+	AscendRuntimeMounts:  AscendRuntimeMountsAnnotation,
+	AscendTopologyPolicy: AscendTopologyPolicyAnnotation,
+}
+
+// GetValueByKey get the value of name from ENV lines like name=value
+func GetValueByKey(data []string, name string) string {
+	for _, envLine := range data {
+		words := strings.SplitN(envLine, "=", kvPairSize)
+		if len(words) != kvPairSize {
+			hwlog.RunLog.Error("environment error")
+			return ""
+		}
+
+		if words[0] == name {
+			return words[1]
+		}
+	}
+
+	return ""
+}
+
+// GetValueFromSpec get the value of an ascend ENV, the equivalent annotation takes precedence over ENV
+func GetValueFromSpec(spec *specs.Spec, name string) string {
+	if value, ok := spec.Annotations[envAnnotations[name]]; ok {
+		return value
+	}
+	if spec.Process == nil {
+		return ""
+	}
+	return GetValueByKey(spec.Process.Env, name)
+}
+
+// getValueByDeviceKey get the device request of the container from the sources accepted by configuration
+func getValueByDeviceKey(spec *specs.Spec, cfg *ascendconfig.Config) string {
+	res := cfg.GetDeviceRequest(getMountDestinations(spec.Mounts), func() string {
+		if res, ok := spec.Annotations[AscendVisibleDevicesAnnotation]; ok {
+			return res
+		}
+		if spec.Process == nil {
+			return ""
+		}
+		return getDeviceValueFromEnv(spec.Process.Env)
+	})
+	if res == "" && !cfg.AcceptEnvvar {
+		hwlog.RunLog.Info("device requests through ASCEND_VISIBLE_DEVICES are ignored by configuration")
+	}
+	return res
+}
+
+// SetMountedDeviceRequest copy the device request of volume mounts to the annotation, as the hook reads the
+// request from annotations and ENV only
+func SetMountedDeviceRequest(spec *specs.Spec, cfg *ascendconfig.Config) {
+	if !cfg.AcceptVolumeMounts {
+		return
+	}
+	res := ascendconfig.GetMountedDeviceRequest(getMountDestinations(spec.Mounts))
+	if res == "" {
+		return
+	}
+	if spec.Annotations == nil {
+		spec.Annotations = map[string]string{}
+	}
+	spec.Annotations[AscendVisibleDevicesAnnotation] = res
+}
+
+// GetAutoDeviceCount get N of an auto:N request, false means the request of the container is not auto:N
+func GetAutoDeviceCount(spec *specs.Spec, cfg *ascendconfig.Config) (int, bool, error) {
+	visibleDevices := strings.TrimSpace(getValueByDeviceKey(spec, cfg))
+	if !strings.HasPrefix(visibleDevices, VisibleDevicesAuto) {
+		return 0, false, nil
+	}
+	count, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(visibleDevices, VisibleDevicesAuto)))
+	if err != nil || count <= 0 || count > maxAutoDevices {
+		return 0, true, fmt.Errorf("invalid auto device request: %s", visibleDevices)
+	}
+	return count, true, nil
+}
+
+// SetVisibleDevices replace the device request of the container with the device ids, so that the hook sees them
+func SetVisibleDevices(spec *specs.Spec, devices []int) {
+	ids := make([]string, 0, len(devices))
+	for _, id := range devices {
+		ids = append(ids, strconv.Itoa(id))
+	}
+	value := strings.Join(ids, ",")
+	if _, ok := spec.Annotations[AscendVisibleDevicesAnnotation]; ok {
+		spec.Annotations[AscendVisibleDevicesAnnotation] = value
+	}
+	if spec.Process == nil {
+		return
+	}
+	for i, envLine := range spec.Process.Env {
+		if strings.HasPrefix(envLine, AscendVisibleDevices+"=") {
+			spec.Process.Env[i] = AscendVisibleDevices + "=" + value
+		}
+	}
+}
+
+// getMountDestinations the destinations of the mounts, the device requests are mounted like
+// /var/run/ascend-container-devices/<id>
+func getMountDestinations(mounts []specs.Mount) []string {
+	destinations := make([]string, 0, len(mounts))
+	for _, mount := range mounts {
+		destinations = append(destinations, mount.Destination)
+	}
+	return destinations
+}
+
+func getDeviceValueFromEnv(data []string) string {
+	res := ""
+	isKeyExist := false
+	for _, envLine := range data {
+		words := strings.SplitN(envLine, "=", kvPairSize)
+		if len(words) != kvPairSize {
+			hwlog.RunLog.Error("environment error")
+			return ""
+		}
+
+		if words[0] == AscendVisibleDevices {
+			res = words[1]
+			if strings.Contains(res, ascend) {
+				return res
+			}
+			isKeyExist = true
+		}
+	}
+	if isKeyExist && res == "" {
+		hwlog.RunLog.Error("ASCEND_VISIBLE_DEVICES env variable is empty, will not mount any ascend device")
+	}
+
+	return res
+}
+
+func parseDevices(visibleDevices string) ([]int, error) {
+	devices := make([]int, 0)
+	const maxDevice = 128
+
+	for _, d := range strings.Split(visibleDevices, ",") {
+		d = strings.TrimSpace(d)
+		if strings.Contains(d, "-") {
+			borders := strings.Split(d, "-")
+			if len(borders) != borderNum {
+				return nil, fmt.Errorf("invalid device range: %s", d)
+			}
+
+			borders[0] = strings.TrimSpace(borders[0])
+			borders[1] = strings.TrimSpace(borders[1])
+
+			left, err := strconv.Atoi(borders[0])
+			if err != nil || left < 0 {
+				return nil, fmt.Errorf("invalid left boarder range parameter: %s", borders[0])
+			}
+
+			right, err := strconv.Atoi(borders[1])
+			if err != nil || right > maxDevice {
+				return nil, fmt.Errorf("invalid right boarder range parameter: %s", borders[1])
+			}
+
+			if left > right {
+				return nil, fmt.Errorf("left boarder (%d) should not be larger than the right one(%d)", left, right)
+			}
+
+			for n := left; n <= right; n++ {
+				devices = append(devices, n)
+			}
+		} else {
+			n, err := strconv.Atoi(d)
+			if err != nil {
+				return nil, fmt.Errorf("invalid single device parameter: %s", d)
+			}
+
+			devices = append(devices, n)
+		}
+	}
+
+	sort.Ints(devices)
+	return removeDuplication(devices), nil
+}
+
+// parseAscendDevices parse requests like Ascend310P-0,Ascend910-1, every id is a phy id and must be a device
+// of the chip type before it, so that one request can mix the chip types of a heterogeneous node
+func parseAscendDevices(visibleDevices string, session *dcmi.Session) ([]int, error) {
+	devicesList := strings.Split(visibleDevices, ",")
+	devices := make([]int, 0, len(devicesList))
+	deviceTypes, err := getDeviceTypes(session)
+	if err != nil {
+		return nil, fmt.Errorf("get chip name error: %v", err)
+	}
+
+	for _, d := range devicesList {
+		matchGroups := regexp.MustCompile(`^Ascend(910|310|310B|310P)-(\d+)$`).FindStringSubmatch(strings.TrimSpace(d))
+		if matchGroups == nil {
+			return nil, fmt.Errorf("invalid device format: %s", d)
+		}
+		n, err := strconv.Atoi(matchGroups[2])
+		if err != nil {
+			return nil, fmt.Errorf("invalid device id: %s", d)
+		}
+		devType, ok := deviceTypes[n]
+		if !ok {
+			return nil, fmt.Errorf("device %d of %s is not found", n, d)
+		}
+		if devType != ascend+matchGroups[1] {
+			return nil, fmt.Errorf("chip type not match really: device %d is %s, not %s", n, devType, d)
+		}
+
+		devices = append(devices, n)
+	}
+
+	sort.Ints(devices)
+	return removeDuplication(devices), nil
+}
+
+func hasDeviceIdentifier(visibleDevices string) bool {
+	for _, d := range strings.Split(visibleDevices, ",") {
+		d = strings.TrimSpace(d)
+		for _, kind := range []string{dcmi.IdentifierSerial, dcmi.IdentifierPCI, dcmi.IdentifierBoard} {
+			if strings.HasPrefix(d, kind+":") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// parseIdentifierDevices resolve serial:, pci: and board: identifiers, plain ids can be mixed in
+func parseIdentifierDevices(visibleDevices string, session *dcmi.Session) ([]int, error) {
+	identifiers := make([]string, 0)
+	ids := make([]string, 0)
+	for _, d := range strings.Split(visibleDevices, ",") {
+		d = strings.TrimSpace(d)
+		if strings.Contains(d, ":") {
+			identifiers = append(identifiers, d)
+			continue
+		}
+		ids = append(ids, d)
+	}
+
+	resolved, err := session.ResolveDeviceIdentifiers(identifiers)
+	if err != nil {
+		return nil, err
+	}
+	devices := make([]int, 0)
+	if len(ids) != 0 {
+		if devices, err = parseDevices(strings.Join(ids, ",")); err != nil {
+			return nil, err
+		}
+	}
+	for _, identifier := range identifiers {
+		hwlog.RunLog.Infof("device %s resolved to physical id %v", identifier, resolved[identifier])
+		for _, phyID := range resolved[identifier] {
+			devices = append(devices, int(phyID))
+		}
+	}
+	sort.Ints(devices)
+	return removeDuplication(devices), nil
+}
+
+// parseCDIDevices converts qualified CDI names such as huawei.com/npu=0 to the device id list
+func parseCDIDevices(visibleDevices string, session *dcmi.Session) ([]int, error) {
+	ids := make([]string, 0)
+	for _, d := range strings.Split(visibleDevices, ",") {
+		d = strings.TrimSpace(d)
+		if !strings.HasPrefix(d, CDIKind+"=") {
+			return nil, fmt.Errorf("invalid cdi device name: %s", d)
+		}
+		id := strings.TrimPrefix(d, CDIKind+"=")
+		if id == CDIAllDevice {
+			return GetAllDevices(session)
+		}
+		ids = append(ids, id)
+	}
+	return parseDevices(strings.Join(ids, ","))
+}
+
+// CheckVisibleDevice parse the device request accepted by cfg, nil means no request while an empty list means
+// that the manager devices and driver are requested without any davinci device
+func CheckVisibleDevice(spec *specs.Spec, cfg *ascendconfig.Config, session *dcmi.Session) ([]int, error) {
+	visibleDevices := strings.TrimSpace(getValueByDeviceKey(spec, cfg))
+	switch visibleDevices {
+	case "", visibleDevicesVoid:
+		return nil, nil
+	case visibleDevicesNone:
+		hwlog.RunLog.Info("no davinci device is requested")
+		return []int{}, nil
+	case visibleDevicesAll:
+		devices, err := GetAllDevices(session)
+		if err != nil {
+			return nil, err
+		}
+		hwlog.RunLog.Infof("all devices is: %v", devices)
+		return devices, nil
+	default:
+	}
+
+	if strings.HasPrefix(visibleDevices, VisibleDevicesAuto) {
+		return nil, fmt.Errorf("%s is only supported by ascend-docker-runtime", visibleDevices)
+	}
+
+	if hasDeviceIdentifier(visibleDevices) {
+		devices, err := parseIdentifierDevices(visibleDevices, session)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse device identifier : %v", err)
+		}
+		hwlog.RunLog.Infof("devices is: %v", devices)
+		return devices, err
+	}
+	if strings.Contains(visibleDevices, CDIKind) {
+		devices, err := parseCDIDevices(visibleDevices, session)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse cdi device : %v", err)
+		}
+		hwlog.RunLog.Infof("cdi devices is: %v", devices)
+		return devices, err
+	}
+	if strings.Contains(visibleDevices, ascend) {
+		devices, err := parseAscendDevices(visibleDevices, session)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse ascend device : %v", err)
+		}
+		hwlog.RunLog.Infof("ascend devices is: %v", devices)
+		return devices, err
+	}
+	devices, err := parseDevices(visibleDevices)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse device : %v", err)
+	}
+	hwlog.RunLog.Infof("devices is: %v", devices)
+	return devices, err
+}
@@ -0,0 +1,318 @@
+/* Copyright(C) 2023. Huawei Technologies Co.,Ltd. All rights reserved.
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package injector
+package injector
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/agiledragon/gomonkey/v2"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/stretchr/testify/assert"
+
+	"ascendconfig"
+	"main/dcmi"
+)
+
+func TestParseDevicesCase1(t *testing.T) {
+	visibleDevices := "0-3,5,7"
+	expectVal := []int{0, 1, 2, 3, 5, 7}
+	actualVal, err := parseDevices(visibleDevices)
+	if err != nil || !reflect.DeepEqual(expectVal, actualVal) {
+		t.Fail()
+	}
+}
+
+func TestParseDevicesUnsorted(t *testing.T) {
+	// the devices are sorted so that the duplicated ones are removed
+	actualVal, err := parseDevices("7,0-2,1")
+	if err != nil || !reflect.DeepEqual([]int{0, 1, 2, 7}, actualVal) {
+		t.Fatalf("unexpected devices %v: %v", actualVal, err)
+	}
+}
+
+func TestParseDevicesCase2(t *testing.T) {
+	visibleDevices := "0-3-4,5,7"
+	_, err := parseDevices(visibleDevices)
+	assert.NotNil(t, err)
+}
+
+func TestParseDevicesCase3(t *testing.T) {
+	visibleDevices := "0l-3,5,7"
+	_, err := parseDevices(visibleDevices)
+	assert.NotNil(t, err)
+}
+
+func TestParseDevicesCase4(t *testing.T) {
+	visibleDevices := "0-3o,5,7"
+	_, err := parseDevices(visibleDevices)
+	assert.NotNil(t, err)
+}
+
+func TestParseDevicesCase5(t *testing.T) {
+	visibleDevices := "4-3,5,7"
+	_, err := parseDevices(visibleDevices)
+	assert.NotNil(t, err)
+}
+
+func TestParseDevicesCase6(t *testing.T) {
+	visibleDevices := "3o,5,7"
+	_, err := parseDevices(visibleDevices)
+	assert.NotNil(t, err)
+}
+
+func TestParseDevicesCase7(t *testing.T) {
+	visibleDevices := "0=3,5,7"
+	_, err := parseDevices(visibleDevices)
+	assert.NotNil(t, err)
+}
+
+func TestGetValueByKeyCase1(t *testing.T) {
+	data := []string{"ASCEND_VISIBLE_DEVICES=0-3,5,7"}
+	word := "ASCEND_VISIBLE_DEVICES"
+	expectVal := "0-3,5,7"
+	actualVal := GetValueByKey(data, word)
+	assert.EqualValues(t, expectVal, actualVal)
+}
+
+func TestGetValueByKeyCase2(t *testing.T) {
+	data := []string{"ASCEND_VISIBLE_DEVICES"}
+	word := "ASCEND_VISIBLE_DEVICES"
+	expectVal := ""
+	defer func() {
+		if err := recover(); err != nil {
+			t.Log("exception occur")
+		}
+	}()
+	actualVal := GetValueByKey(data, word)
+	assert.EqualValues(t, expectVal, actualVal)
+}
+
+func TestGetValueByKeyCase3(t *testing.T) {
+	data := []string{"ASCEND_VISIBLE_DEVICES=0-3,5,7"}
+	word := "ASCEND_VISIBLE_DEVICE"
+	expectVal := ""
+	actualVal := GetValueByKey(data, word)
+	assert.EqualValues(t, expectVal, actualVal)
+}
+
+func TestGetValueFromSpec(t *testing.T) {
+	spec := specs.Spec{
+		Process: &specs.Process{
+			Env: []string{"ASCEND_RUNTIME_OPTIONS=NODRV"},
+		},
+	}
+	assert.EqualValues(t, "NODRV", GetValueFromSpec(&spec, AscendRuntimeOptions))
+
+	spec.Annotations = map[string]string{AscendRuntimeOptionsAnnotation: "VIRTUAL"}
+	assert.EqualValues(t, "VIRTUAL", GetValueFromSpec(&spec, AscendRuntimeOptions))
+}
+
+func TestCheckVisibleDeviceFromAnnotation(t *testing.T) {
+	spec := specs.Spec{
+		Process: &specs.Process{
+			Env: []string{"ASCEND_VISIBLE_DEVICES=0"},
+		},
+		Annotations: map[string]string{AscendVisibleDevicesAnnotation: "2-3"},
+	}
+	devices, err := CheckVisibleDevice(&spec, ascendconfig.Default(), dcmi.NewSession(&dcmi.NpuWorker{}))
+	assert.Nil(t, err)
+	assert.EqualValues(t, []int{2, 3}, devices)
+
+	spec.Annotations[AscendVisibleDevicesAnnotation] = ""
+	devices, err = CheckVisibleDevice(&spec, ascendconfig.Default(), dcmi.NewSession(&dcmi.NpuWorker{}))
+	assert.Nil(t, err)
+	assert.Nil(t, devices)
+}
+
+func TestGetDeviceValueFromMounts(t *testing.T) {
+	mounts := []specs.Mount{
+		{Source: "/dev/null", Destination: "/var/run/ascend-container-devices/0"},
+		{Source: "/tmp", Destination: "/tmp"},
+		{Source: "/dev/null", Destination: "/var/run/ascend-container-devices/3/"},
+	}
+	assert.EqualValues(t, "0,3", ascendconfig.GetMountedDeviceRequest(getMountDestinations(mounts)))
+}
+
+func TestGetValueByDeviceKeyWithVolumeMounts(t *testing.T) {
+	cfg := &ascendconfig.Config{AcceptEnvvar: false, AcceptVolumeMounts: true}
+	spec := specs.Spec{
+		Process: &specs.Process{
+			Env: []string{"ASCEND_VISIBLE_DEVICES=0-7"},
+		},
+	}
+	assert.EqualValues(t, "", getValueByDeviceKey(&spec, cfg))
+
+	spec.Mounts = []specs.Mount{{Source: "/dev/null", Destination: "/var/run/ascend-container-devices/1"}}
+	assert.EqualValues(t, "1", getValueByDeviceKey(&spec, cfg))
+	// the lookup does not change the spec
+	assert.Nil(t, spec.Annotations)
+
+	SetMountedDeviceRequest(&spec, cfg)
+	assert.EqualValues(t, "1", spec.Annotations[AscendVisibleDevicesAnnotation])
+	SetMountedDeviceRequest(&spec, ascendconfig.Default())
+	assert.EqualValues(t, "1", spec.Annotations[AscendVisibleDevicesAnnotation])
+}
+
+func TestGetValueByDeviceKeyWithEnvvar(t *testing.T) {
+	spec := specs.Spec{
+		Process: &specs.Process{
+			Env: []string{"ASCEND_VISIBLE_DEVICES=0-7"},
+		},
+		Mounts: []specs.Mount{{Source: "/dev/null", Destination: "/var/run/ascend-container-devices/1"}},
+	}
+	assert.EqualValues(t, "0-7", getValueByDeviceKey(&spec, ascendconfig.Default()))
+}
+
+func TestCheckVisibleDeviceKeywords(t *testing.T) {
+	stub := gomonkey.ApplyMethod(reflect.TypeOf(&dcmi.Session{}), "GetNpuDevices",
+		func(_ *dcmi.Session) ([]dcmi.NpuDevice, error) {
+			return []dcmi.NpuDevice{{PhyID: 4}, {PhyID: 0}, {PhyID: 1}}, nil
+		})
+	defer stub.Reset()
+
+	spec := specs.Spec{Process: &specs.Process{Env: []string{"ASCEND_VISIBLE_DEVICES=all"}}}
+	devices, err := CheckVisibleDevice(&spec, ascendconfig.Default(), dcmi.NewSession(&dcmi.NpuWorker{}))
+	assert.Nil(t, err)
+	assert.EqualValues(t, []int{0, 1, 4}, devices)
+
+	spec.Process.Env = []string{"ASCEND_VISIBLE_DEVICES=huawei.com/npu=all"}
+	devices, err = CheckVisibleDevice(&spec, ascendconfig.Default(), dcmi.NewSession(&dcmi.NpuWorker{}))
+	assert.Nil(t, err)
+	assert.EqualValues(t, []int{0, 1, 4}, devices)
+
+	spec.Process.Env = []string{"ASCEND_VISIBLE_DEVICES=none"}
+	devices, err = CheckVisibleDevice(&spec, ascendconfig.Default(), dcmi.NewSession(&dcmi.NpuWorker{}))
+	assert.Nil(t, err)
+	assert.NotNil(t, devices)
+	assert.Empty(t, devices)
+
+	spec.Process.Env = []string{"ASCEND_VISIBLE_DEVICES=void"}
+	devices, err = CheckVisibleDevice(&spec, ascendconfig.Default(), dcmi.NewSession(&dcmi.NpuWorker{}))
+	assert.Nil(t, err)
+	assert.Nil(t, devices)
+}
+
+func TestCheckVisibleDeviceHeterogeneous(t *testing.T) {
+	stub := gomonkey.ApplyMethod(reflect.TypeOf(&dcmi.Session{}), "GetDeviceChipNames",
+		func(_ *dcmi.Session) (map[int32]string, error) {
+			return map[int32]string{0: "310P3", 1: "310P3", 2: "910B", 3: "910B"}, nil
+		})
+	defer stub.Reset()
+
+	spec := specs.Spec{Process: &specs.Process{Env: []string{"ASCEND_VISIBLE_DEVICES=Ascend910-3,Ascend310P-1"}}}
+	devices, err := CheckVisibleDevice(&spec, ascendconfig.Default(), dcmi.NewSession(&dcmi.NpuWorker{}))
+	assert.Nil(t, err)
+	assert.EqualValues(t, []int{1, 3}, devices)
+
+	for _, request := range []string{"Ascend910-1", "Ascend310P-5"} {
+		_, err = parseAscendDevices(request, dcmi.NewSession(&dcmi.NpuWorker{}))
+		assert.NotNil(t, err, request)
+	}
+}
+
+func TestCheckVisibleDeviceWithIdentifier(t *testing.T) {
+	stub := gomonkey.ApplyMethod(reflect.TypeOf(&dcmi.Session{}), "ResolveDeviceIdentifiers",
+		func(_ *dcmi.Session, identifiers []string) (map[string][]int32, error) {
+			return map[string][]int32{"serial:SN01": {5}, "pci:0000:81:00.0": {2, 3}}, nil
+		})
+	defer stub.Reset()
+
+	spec := specs.Spec{Process: &specs.Process{
+		Env: []string{"ASCEND_VISIBLE_DEVICES=serial:SN01,0,pci:0000:81:00.0,2"}}}
+	devices, err := CheckVisibleDevice(&spec, ascendconfig.Default(), dcmi.NewSession(&dcmi.NpuWorker{}))
+	assert.Nil(t, err)
+	assert.EqualValues(t, []int{0, 2, 3, 5}, devices)
+}
+
+func TestParseCDIDevices(t *testing.T) {
+	devices, err := parseCDIDevices("huawei.com/npu=0-1, huawei.com/npu=3", dcmi.NewSession(&dcmi.NpuWorker{}))
+	assert.Nil(t, err)
+	assert.EqualValues(t, []int{0, 1, 3}, devices)
+}
+
+func TestParseCDIDevicesCase1(t *testing.T) {
+	_, err := parseCDIDevices("huawei.com/npu=0,1", dcmi.NewSession(&dcmi.NpuWorker{}))
+	assert.NotNil(t, err)
+}
+
+func TestParseCDIDevicesCase2(t *testing.T) {
+	_, err := parseCDIDevices("huawei.com/gpu=0", dcmi.NewSession(&dcmi.NpuWorker{}))
+	assert.NotNil(t, err)
+}
+
+func TestCheckVisibleDeviceWithCDIName(t *testing.T) {
+	spec := specs.Spec{
+		Process: &specs.Process{
+			Env: []string{"ASCEND_VISIBLE_DEVICES=huawei.com/npu=1,huawei.com/npu=2"},
+		},
+	}
+	devices, err := CheckVisibleDevice(&spec, ascendconfig.Default(), dcmi.NewSession(&dcmi.NpuWorker{}))
+	assert.Nil(t, err)
+	assert.EqualValues(t, []int{1, 2}, devices)
+}
+
+func TestGetAutoDeviceCount(t *testing.T) {
+	spec := specs.Spec{Process: &specs.Process{Env: []string{"ASCEND_VISIBLE_DEVICES=auto:2"}}}
+	count, isAuto, err := GetAutoDeviceCount(&spec, ascendconfig.Default())
+	assert.Nil(t, err)
+	assert.True(t, isAuto)
+	assert.EqualValues(t, 2, count)
+
+	_, err = CheckVisibleDevice(&spec, ascendconfig.Default(), dcmi.NewSession(&dcmi.NpuWorker{}))
+	assert.NotNil(t, err)
+	SetVisibleDevices(&spec, []int{1, 3})
+	assert.EqualValues(t, []string{"ASCEND_VISIBLE_DEVICES=1,3"}, spec.Process.Env)
+	_, isAuto, err = GetAutoDeviceCount(&spec, ascendconfig.Default())
+	assert.Nil(t, err)
+	assert.False(t, isAuto)
+
+	for _, value := range []string{"auto:0", "auto:x", "auto:"} {
+		spec.Annotations = map[string]string{AscendVisibleDevicesAnnotation: value}
+		_, isAuto, err = GetAutoDeviceCount(&spec, ascendconfig.Default())
+		assert.True(t, isAuto)
+		assert.NotNil(t, err)
+	}
+}
+
+func TestIsTopologyAligned(t *testing.T) {
+	domains := [][]int32{{0, 1, 2, 3}, {4, 5, 6, 7}}
+	assert.True(t, IsTopologyAligned([]int{5}, domains))
+	assert.True(t, IsTopologyAligned([]int{0, 2, 3}, domains))
+	assert.True(t, IsTopologyAligned([]int{0, 1, 2, 3, 4, 5, 6, 7}, domains))
+	assert.False(t, IsTopologyAligned([]int{2, 3, 4, 5}, domains))
+	assert.False(t, IsTopologyAligned([]int{0, 1, 2, 3, 4}, domains))
+	assert.False(t, IsTopologyAligned([]int{0, 8}, domains))
+	assert.True(t, HasHCCS(domains))
+	assert.False(t, HasHCCS([][]int32{{0}, {1}}))
+}
+
+func TestCheckTopologyPolicy(t *testing.T) {
+	stub := gomonkey.ApplyMethod(reflect.TypeOf(&dcmi.Session{}), "GetHCCSDomains",
+		func(_ *dcmi.Session) ([][]int32, error) {
+			return [][]int32{{0, 1, 2, 3}, {4, 5, 6, 7}}, nil
+		})
+	defer stub.Reset()
+	session := dcmi.NewSession(&dcmi.NpuWorker{})
+	spec := &specs.Spec{Process: &specs.Process{}}
+	assert.Nil(t, CheckTopologyPolicy(spec, []int{3, 4}, session))
+
+	spec.Process.Env = []string{AscendTopologyPolicy + "=" + TopologyPolicyStrict}
+	assert.Nil(t, CheckTopologyPolicy(spec, []int{0, 1}, session))
+	assert.NotNil(t, CheckTopologyPolicy(spec, []int{3, 4}, session))
+	spec.Annotations = map[string]string{AscendTopologyPolicyAnnotation: "loose"}
+	assert.NotNil(t, CheckTopologyPolicy(spec, []int{0, 1}, session))
+}
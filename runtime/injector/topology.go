@@ -0,0 +1,110 @@
+/* Copyright(C) 2023. Huawei Technologies Co.,Ltd. All rights reserved.
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package injector
+package injector
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"huawei.com/npu-exporter/v5/common-utils/hwlog"
+
+	"main/dcmi"
+)
+
+const (
+	// AscendTopologyPolicy ENV of how the requested devices are checked against the HCCS domains
+	AscendTopologyPolicy = "ASCEND_TOPOLOGY_POLICY"
+	// AscendTopologyPolicyAnnotation annotation equivalent to ASCEND_TOPOLOGY_POLICY
+	AscendTopologyPolicyAnnotation = "huawei.com/ascend.topology-policy"
+
+	// TopologyPolicyNone the HCCS domains are ignored, even by auto:N
+	TopologyPolicyNone = "none"
+	// TopologyPolicyStrict the devices must be inside one HCCS domain or be whole domains, even listed by ids
+	TopologyPolicyStrict = "strict"
+)
+
+// GetTopologyPolicy get the topology policy of the container, empty means that only auto:N follows the domains
+func GetTopologyPolicy(spec *specs.Spec) (string, error) {
+	policy := strings.TrimSpace(GetValueFromSpec(spec, AscendTopologyPolicy))
+	switch policy {
+	case "", TopologyPolicyNone, TopologyPolicyStrict:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("invalid %s %s, it should be %s or %s", AscendTopologyPolicy, policy,
+			TopologyPolicyNone, TopologyPolicyStrict)
+	}
+}
+
+// HasHCCS whether any domain connects several devices
+func HasHCCS(domains [][]int32) bool {
+	for _, domain := range domains {
+		if len(domain) > 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// IsTopologyAligned whether the devices are inside one HCCS domain or are made of whole domains, like 0-3 or 0-7
+// of an 8-card 910 server with the rings 0-3 and 4-7
+func IsTopologyAligned(devices []int, domains [][]int32) bool {
+	if len(devices) <= 1 {
+		return true
+	}
+	requested := make(map[int32]bool, len(devices))
+	for _, id := range devices {
+		requested[int32(id)] = true
+	}
+	covered := 0
+	for _, domain := range domains {
+		count := 0
+		for _, phyID := range domain {
+			if requested[phyID] {
+				count++
+			}
+		}
+		if count == len(requested) {
+			return true
+		}
+		// a domain shared with other containers
+		if count != 0 && count != len(domain) {
+			return false
+		}
+		covered += count
+	}
+	return covered == len(requested)
+}
+
+// CheckTopologyPolicy check the requested devices against the HCCS domains when the policy is strict
+func CheckTopologyPolicy(spec *specs.Spec, devices []int, session *dcmi.Session) error {
+	policy, err := GetTopologyPolicy(spec)
+	if err != nil {
+		return err
+	}
+	if policy != TopologyPolicyStrict || len(devices) <= 1 {
+		return nil
+	}
+	domains, err := session.GetHCCSDomains()
+	if err != nil {
+		return fmt.Errorf("hccs topology is unknown: %v", err)
+	}
+	if !IsTopologyAligned(devices, domains) {
+		return fmt.Errorf("devices %v are not inside one hccs domain, the domains are %v", devices, domains)
+	}
+	hwlog.RunLog.Infof("devices %v are aligned with hccs domains %v", devices, domains)
+	return nil
+}
@@ -0,0 +1,88 @@
+/* Copyright(C) 2023. Huawei Technologies Co.,Ltd. All rights reserved.
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package injector
+package injector
+
+import (
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"huawei.com/npu-exporter/v5/common-utils/hwlog"
+
+	"ascendconfig"
+)
+
+// containerRootID id of root in the container, the owner of the devices whose owner is not mapped
+const containerRootID = 0
+
+// mapHostID get the id in the container of a host id, false when the id is not mapped
+func mapHostID(mappings []specs.LinuxIDMapping, hostID uint32) (uint32, bool) {
+	for _, mapping := range mappings {
+		if hostID >= mapping.HostID && hostID-mapping.HostID < mapping.Size {
+			return mapping.ContainerID + hostID - mapping.HostID, true
+		}
+	}
+	return 0, false
+}
+
+func addAdditionalGid(spec *specs.Spec, gid uint32) {
+	if spec.Process == nil || spec.Process.User.GID == gid {
+		return
+	}
+	for _, additionalGid := range spec.Process.User.AdditionalGids {
+		if additionalGid == gid {
+			return
+		}
+	}
+	spec.Process.User.AdditionalGids = append(spec.Process.User.AdditionalGids, gid)
+}
+
+// applyDeviceOwners give the devices to the ids of the container when it has a user namespace, an owner not mapped
+// becomes root of the container, and device-gid replaces the group when it is set. The group of the devices is
+// added to the process, because runc bind mounts the device nodes of the host into a user namespace, and the
+// process opens them by a host group mapped into the container
+func applyDeviceOwners(spec *specs.Spec, devices []specs.LinuxDevice, cfg *ascendconfig.Config) {
+	userNamespaced := len(spec.Linux.UIDMappings) != 0
+	if !userNamespaced && cfg.DeviceGID == nil {
+		return
+	}
+	for i := range devices {
+		device := &devices[i]
+		if userNamespaced && device.UID != nil {
+			uid, ok := mapHostID(spec.Linux.UIDMappings, *device.UID)
+			if !ok {
+				uid = containerRootID
+			}
+			device.UID = &uid
+		}
+		if cfg.DeviceGID != nil {
+			gid := *cfg.DeviceGID
+			device.GID = &gid
+			addAdditionalGid(spec, gid)
+			continue
+		}
+		if device.GID == nil {
+			continue
+		}
+		gid, ok := mapHostID(spec.Linux.GIDMappings, *device.GID)
+		if !ok {
+			hwlog.RunLog.Warnf("group %d of %s is not mapped into the container, only root of the container "+
+				"may open it", *device.GID, device.Path)
+			gid = containerRootID
+			device.GID = &gid
+			continue
+		}
+		device.GID = &gid
+		addAdditionalGid(spec, gid)
+	}
+}
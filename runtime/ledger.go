@@ -0,0 +1,274 @@
+/* Copyright(C) 2023. Huawei Technologies Co.,Ltd. All rights reserved.
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package main
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"huawei.com/npu-exporter/v5/common-utils/hwlog"
+
+	"main/injector"
+	"mindxcheckutils"
+)
+
+const (
+	psCommand     = "ps"
+	psJSONOption  = "--json"
+	psUsage       = "usage: ascend-docker-runtime ps [--json]"
+	psStatusGone  = "gone"
+	psStatusUnset = "unknown"
+	psMinWidth    = 0
+	psTabWidth    = 8
+	psPadding     = 2
+)
+
+var (
+	allocationDir            = "/run/ascend-docker-runtime/allocations"
+	psOutput       io.Writer = os.Stdout
+	createBundle   string
+	vnpuIdList     []int
+	physicalIdList []int
+)
+
+// allocationRecord the npu allocated to a container when it is created
+type allocationRecord struct {
+	ContainerID string   `json:"container_id"`
+	Bundle      string   `json:"bundle"`
+	Devices     []int    `json:"devices"`
+	VnpuIDs     []int    `json:"vnpu_ids,omitempty"`
+	GlobalArgs  []string `json:"global_args,omitempty"`
+	Created     int64    `json:"created"`
+	Status      string   `json:"status,omitempty"`
+}
+
+func getAllocationPath(id string) string {
+	return filepath.Join(allocationDir, id+".json")
+}
+
+// setAllocatedDevices tell the physical devices from the vnpu ids, a vnpu created by the runtime is recorded with
+// the devices it is split from
+func setAllocatedDevices(spec *specs.Spec, requested []int, requestVirtual bool) {
+	physicalIdList, vnpuIdList = deviceIdList, nil
+	if requestVirtual {
+		physicalIdList, vnpuIdList = nil, deviceIdList
+	} else if strings.Contains(injector.GetValueFromSpec(spec, injector.AscendRuntimeOptions), "VIRTUAL") {
+		physicalIdList, vnpuIdList = requested, deviceIdList
+	}
+}
+
+func writeAllocation(record *allocationRecord) error {
+	content, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to marshal allocation record: %v", err)
+	}
+	return writeStateFile(getAllocationPath(record.ContainerID), content)
+}
+
+// saveAllocation record the devices of the container being created, the container is created even if it fails
+func saveAllocation() {
+	if dryRun || containerID == "" || len(deviceIdList) == 0 {
+		return
+	}
+	err := writeAllocation(&allocationRecord{
+		ContainerID: containerID,
+		Bundle:      createBundle,
+		Devices:     physicalIdList,
+		VnpuIDs:     vnpuIdList,
+		GlobalArgs:  runcGlobalArgs,
+		Created:     time.Now().Unix(),
+	})
+	if err != nil {
+		hwlog.RunLog.Warnf("failed to record the allocation of container %s: %v", containerID, err)
+	}
+}
+
+// saveProvisionalAllocation record the devices picked for an auto:N request before the spec is written, so that
+// the concurrent requests count them, the record is replaced by saveAllocation once the spec is modified
+func saveProvisionalAllocation(id string, devices []int) error {
+	if dryRun || id == "" {
+		return nil
+	}
+	err := writeAllocation(&allocationRecord{
+		ContainerID: id,
+		Bundle:      createBundle,
+		Devices:     devices,
+		GlobalArgs:  runcGlobalArgs,
+		Created:     time.Now().Unix(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to record the devices picked: %v", err)
+	}
+	return nil
+}
+
+// removeCreateAllocation remove the allocation record of a container failed to be created
+func removeCreateAllocation(id string) {
+	if dryRun || id == "" {
+		return
+	}
+	if err := os.Remove(getAllocationPath(id)); err != nil && !os.IsNotExist(err) {
+		hwlog.RunLog.Warnf("failed to remove the allocation of container %s: %v", id, err)
+	}
+}
+
+func readAllocation(recordPath string) (*allocationRecord, error) {
+	if _, err := mindxcheckutils.RealFileChecker(recordPath, true, false, mindxcheckutils.DefaultSize); err != nil {
+		return nil, err
+	}
+	content, err := ioutil.ReadFile(recordPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read allocation record %s: %v", recordPath, err)
+	}
+	var record allocationRecord
+	if err = json.Unmarshal(content, &record); err != nil {
+		return nil, fmt.Errorf("failed to parse allocation record %s: %v", recordPath, err)
+	}
+	return &record, nil
+}
+
+// applyAttachRecord update the devices of the allocation with the devices attached or detached since creation
+func applyAttachRecord(record *allocationRecord) error {
+	attach, err := loadAttachRecord(record.ContainerID)
+	if err != nil {
+		return err
+	}
+	devices := make([]int, 0, len(record.Devices)+len(attach.Attached))
+	for _, id := range record.Devices {
+		if !hasAttachedDevice(attach.Detached, id) {
+			devices = append(devices, id)
+		}
+	}
+	for _, device := range attach.Attached {
+		devices = append(devices, device.ID)
+	}
+	sort.Ints(devices)
+	record.Devices = devices
+	return nil
+}
+
+// removeAllocation remove the records of a container that no longer exists
+func removeAllocation(record *allocationRecord) {
+	for _, recordPath := range []string{getAllocationPath(record.ContainerID),
+		getAttachRecordPath(record.ContainerID), getMetadataPath(record.ContainerID),
+		getRankTablePath(record.ContainerID)} {
+		if err := os.Remove(recordPath); err != nil && !os.IsNotExist(err) {
+			hwlog.RunLog.Warnf("failed to remove %s: %v", recordPath, err)
+		}
+	}
+	hwlog.RunLog.Infof("allocation of container %s is removed, the container is gone", record.ContainerID)
+}
+
+// loadAllocations load the allocation records with the devices attached or detached since creation, the state of
+// the containers is not queried
+func loadAllocations() ([]*allocationRecord, error) {
+	recordPaths, err := filepath.Glob(filepath.Join(allocationDir, "*.json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list allocation records: %v", err)
+	}
+	records := make([]*allocationRecord, 0, len(recordPaths))
+	for _, recordPath := range recordPaths {
+		record, err := readAllocation(recordPath)
+		if err != nil {
+			return nil, err
+		}
+		if err = applyAttachRecord(record); err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Created < records[j].Created })
+	return records, nil
+}
+
+// listAllocations list the allocations of the containers, records of the containers gone are pruned
+func listAllocations() ([]*allocationRecord, error) {
+	loaded, err := loadAllocations()
+	if err != nil {
+		return nil, err
+	}
+	records := make([]*allocationRecord, 0, len(loaded))
+	for _, record := range loaded {
+		state, err := queryContainerState(record.GlobalArgs, record.ContainerID)
+		switch {
+		case err != nil:
+			hwlog.RunLog.Warnf("state of container %s is unknown: %v", record.ContainerID, err)
+			record.Status = psStatusUnset
+		case state != nil:
+			record.Status = state.Status
+		case time.Since(time.Unix(record.Created, 0)) < lockGracePeriod:
+			// runc create has not finished yet
+			record.Status = psStatusUnset
+		default:
+			removeAllocation(record)
+			continue
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+func joinDevices(prefix string, ids []int) string {
+	if len(ids) == 0 {
+		return "-"
+	}
+	names := make([]string, 0, len(ids))
+	for _, id := range ids {
+		names = append(names, prefix+strconv.Itoa(id))
+	}
+	return strings.Join(names, ",")
+}
+
+func writeAllocationTable(records []*allocationRecord) error {
+	writer := tabwriter.NewWriter(psOutput, psMinWidth, psTabWidth, psPadding, ' ', 0)
+	fmt.Fprintln(writer, "CONTAINER ID\tSTATUS\tDEVICES\tVNPU IDS\tCREATED\tBUNDLE")
+	for _, record := range records {
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\t%s\n", record.ContainerID, record.Status,
+			joinDevices(injector.DavinciName, record.Devices), joinDevices("", record.VnpuIDs),
+			time.Unix(record.Created, 0).Format(time.RFC3339), record.Bundle)
+	}
+	return writer.Flush()
+}
+
+// doPsProcess print the npu held by each container
+func doPsProcess(cmdArgs []string) error {
+	if len(cmdArgs) > 1 || (len(cmdArgs) == 1 && cmdArgs[0] != psJSONOption) {
+		return fmt.Errorf("%s", psUsage)
+	}
+	records, err := listAllocations()
+	if err != nil {
+		return err
+	}
+	if len(cmdArgs) == 0 {
+		return writeAllocationTable(records)
+	}
+	content, err := json.MarshalIndent(records, "", previewIndent)
+	if err != nil {
+		return fmt.Errorf("failed to marshal allocation records: %v", err)
+	}
+	_, err = fmt.Fprintf(psOutput, "%s\n", content)
+	return err
+}
@@ -0,0 +1,121 @@
+/* Copyright(C) 2023. Huawei Technologies Co.,Ltd. All rights reserved.
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package main
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/agiledragon/gomonkey/v2"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/stretchr/testify/assert"
+
+	"ascendconfig"
+	"main/dcmi"
+	"main/injector"
+)
+
+func TestSetAllocatedDevices(t *testing.T) {
+	stub := gomonkey.ApplyGlobalVar(&deviceIdList, []int{0, 1})
+	defer stub.Reset()
+	spec := &specs.Spec{Process: &specs.Process{}}
+	setAllocatedDevices(spec, []int{0, 1}, false)
+	assert.EqualValues(t, []int{0, 1}, physicalIdList)
+	assert.Empty(t, vnpuIdList)
+
+	setAllocatedDevices(spec, []int{0, 1}, true)
+	assert.Empty(t, physicalIdList)
+	assert.EqualValues(t, []int{0, 1}, vnpuIdList)
+
+	// a vnpu split by the runtime
+	deviceIdList = []int{100}
+	spec.Process.Env = []string{injector.AscendRuntimeOptions + "=VIRTUAL"}
+	setAllocatedDevices(spec, []int{0}, false)
+	assert.EqualValues(t, []int{0}, physicalIdList)
+	assert.EqualValues(t, []int{100}, vnpuIdList)
+}
+
+func TestDoPsProcess(t *testing.T) {
+	alive := map[string]bool{"abc": true, "def": true}
+	stub := stubLock(t, alive)
+	defer stub.Reset()
+	dir := t.TempDir()
+	stub.ApplyGlobalVar(&allocationDir, filepath.Join(dir, "allocations"))
+	stub.ApplyGlobalVar(&attachRecordDir, filepath.Join(dir, "attachments"))
+
+	stub.ApplyGlobalVar(&containerID, "abc")
+	stub.ApplyGlobalVar(&createBundle, "/bundle/abc")
+	stub.ApplyGlobalVar(&deviceIdList, []int{3})
+	stub.ApplyGlobalVar(&physicalIdList, []int{3})
+	saveAllocation()
+	assert.Nil(t, saveAttachRecord(&attachRecord{ContainerID: "abc", Attached: []attachedDevice{{ID: 5}}}))
+	containerID, physicalIdList, vnpuIdList = "def", []int{1}, []int{100}
+	saveAllocation()
+
+	output := &bytes.Buffer{}
+	stub.ApplyGlobalVar(&psOutput, output)
+	assert.Nil(t, doPsProcess(nil))
+	lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+	assert.Len(t, lines, 3)
+	assert.Contains(t, lines[1], "davinci3,davinci5")
+	assert.Contains(t, lines[1], "/bundle/abc")
+	assert.Contains(t, lines[2], "davinci1")
+
+	// the records of a container gone are pruned
+	alive["def"] = false
+	record, err := readAllocation(getAllocationPath("def"))
+	assert.Nil(t, err)
+	record.Created = time.Now().Add(-2 * lockGracePeriod).Unix()
+	content, err := json.Marshal(record)
+	assert.Nil(t, err)
+	assert.Nil(t, ioutil.WriteFile(getAllocationPath("def"), content, testFileMode))
+	output.Reset()
+	assert.Nil(t, doPsProcess([]string{"--json"}))
+	var records []allocationRecord
+	assert.Nil(t, json.Unmarshal(output.Bytes(), &records))
+	assert.Len(t, records, 1)
+	assert.EqualValues(t, "running", records[0].Status)
+	assert.EqualValues(t, []int{3, 5}, records[0].Devices)
+	_, err = os.Stat(getAllocationPath("def"))
+	assert.True(t, os.IsNotExist(err))
+
+	assert.NotNil(t, doPsProcess([]string{"-a"}))
+}
+
+func TestInjectDeviceMetadata(t *testing.T) {
+	stub := stubLock(t, nil)
+	defer stub.Reset()
+	stub.ApplyGlobalVar(&metadataDir, filepath.Join(t.TempDir(), "metadata"))
+	stub.ApplyGlobalVar(&containerID, "abc")
+	stub.ApplyFunc(injector.GetDeviceMetadata, func(physicalIDs, vnpuIDs []int, cfg *ascendconfig.Config,
+		session *dcmi.Session) (*injector.DeviceMetadata, error) {
+		return &injector.DeviceMetadata{ChipName: "910B"}, nil
+	})
+	spec := &specs.Spec{Process: &specs.Process{}}
+	injectDeviceMetadata(spec, nil)
+	assert.Contains(t, spec.Process.Env, injector.AscendChipName+"=910B")
+	assert.Len(t, spec.Mounts, 1)
+	assert.EqualValues(t, injector.DeviceMetadataPath, spec.Mounts[0].Destination)
+	stat, err := os.Stat(spec.Mounts[0].Source)
+	assert.Nil(t, err)
+	assert.EqualValues(t, injectedFileMode, stat.Mode().Perm())
+}
@@ -0,0 +1,363 @@
+/* Copyright(C) 2023. Huawei Technologies Co.,Ltd. All rights reserved.
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package main
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"golang.org/x/sys/unix"
+	"huawei.com/npu-exporter/v5/common-utils/hwlog"
+
+	"main/injector"
+	"mindxcheckutils"
+)
+
+const (
+	lockCommand        = "lock"
+	lockReleaseCommand = "release"
+	lockGCCommand      = "gc"
+	lockListCommand    = "list"
+	lockUsage          = "usage: ascend-docker-runtime lock release [<container-id>]|gc|list"
+
+	dirLockName    = ".lock"
+	lockFileSuffix = ".json"
+	// a container appears in the runtime state only after runc create starts, its young locks are kept
+	lockGracePeriod = time.Minute
+	maxHookState    = 64 * 1024
+)
+
+var (
+	lockDir              = "/run/ascend-docker-runtime/locks"
+	lockOutput io.Writer = os.Stdout
+	lockInput  io.Reader = os.Stdin
+	// createLocks the devices whose locks are taken by the container being created
+	createLocks []int
+)
+
+// deviceLock the container exclusively using a davinci device
+type deviceLock struct {
+	DeviceID    int      `json:"device_id"`
+	ContainerID string   `json:"container_id"`
+	GlobalArgs  []string `json:"global_args,omitempty"`
+	Created     int64    `json:"created"`
+}
+
+func getLockPath(deviceID int) string {
+	return filepath.Join(lockDir, injector.DavinciName+strconv.Itoa(deviceID)+lockFileSuffix)
+}
+
+// withLockDir run f holding the lock of the lock dir, so that checking and taking device locks is atomic
+func withLockDir(f func() error) error {
+	if err := os.MkdirAll(lockDir, stateDirMode); err != nil {
+		return fmt.Errorf("failed to create lock dir: %v", err)
+	}
+	if _, err := mindxcheckutils.RealDirChecker(lockDir, true, false); err != nil {
+		return err
+	}
+	fd, err := unix.Open(filepath.Join(lockDir, dirLockName), unix.O_CREAT|unix.O_RDWR|unix.O_NOFOLLOW|unix.O_CLOEXEC,
+		stateFileMode)
+	if err != nil {
+		return fmt.Errorf("failed to open lock of %s: %v", lockDir, err)
+	}
+	defer unix.Close(fd)
+	if err = unix.Flock(fd, unix.LOCK_EX); err != nil {
+		return fmt.Errorf("failed to lock %s: %v", lockDir, err)
+	}
+	defer unix.Flock(fd, unix.LOCK_UN)
+	return f()
+}
+
+func readDeviceLock(lockPath string) (*deviceLock, error) {
+	if _, err := os.Stat(lockPath); os.IsNotExist(err) {
+		return nil, nil
+	}
+	if _, err := mindxcheckutils.RealFileChecker(lockPath, true, false, mindxcheckutils.DefaultSize); err != nil {
+		return nil, err
+	}
+	content, err := ioutil.ReadFile(lockPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read device lock %s: %v", lockPath, err)
+	}
+	var lock deviceLock
+	if err = json.Unmarshal(content, &lock); err != nil {
+		return nil, fmt.Errorf("failed to parse device lock %s: %v", lockPath, err)
+	}
+	return &lock, nil
+}
+
+func listDeviceLocks() ([]*deviceLock, error) {
+	lockPaths, err := filepath.Glob(filepath.Join(lockDir, injector.DavinciName+"*"+lockFileSuffix))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list device locks: %v", err)
+	}
+	locks := make([]*deviceLock, 0, len(lockPaths))
+	for _, lockPath := range lockPaths {
+		lock, err := readDeviceLock(lockPath)
+		if err != nil {
+			return nil, err
+		}
+		if lock != nil {
+			locks = append(locks, lock)
+		}
+	}
+	sort.Slice(locks, func(i, j int) bool { return locks[i].DeviceID < locks[j].DeviceID })
+	return locks, nil
+}
+
+// isLockHolderAlive whether the container holding the lock may still use the device, a container whose state
+// cannot be queried is taken as alive
+func isLockHolderAlive(lock *deviceLock) bool {
+	if time.Since(time.Unix(lock.Created, 0)) < lockGracePeriod {
+		return true
+	}
+	state, err := queryContainerState(lock.GlobalArgs, lock.ContainerID)
+	if err != nil {
+		hwlog.RunLog.Warnf("liveness of container %s is unknown: %v", lock.ContainerID, err)
+		return true
+	}
+	return state != nil && state.Status != containerStopped
+}
+
+func removeDeviceLock(lock *deviceLock) error {
+	if err := os.Remove(getLockPath(lock.DeviceID)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove lock of %s%d: %v", injector.DavinciName, lock.DeviceID, err)
+	}
+	return nil
+}
+
+func takeDeviceLock(deviceID int, id string, globalArgs []string) (bool, error) {
+	holder, err := readDeviceLock(getLockPath(deviceID))
+	if err != nil {
+		return false, err
+	}
+	if holder != nil && holder.ContainerID == id {
+		return false, nil
+	}
+	if holder != nil {
+		if isLockHolderAlive(holder) {
+			return false, fmt.Errorf("%s%d is exclusively used by container %s", injector.DavinciName, deviceID,
+				holder.ContainerID)
+		}
+		hwlog.RunLog.Infof("stale lock of %s%d held by container %s is released", injector.DavinciName, deviceID,
+			holder.ContainerID)
+	}
+	content, err := json.Marshal(deviceLock{DeviceID: deviceID, ContainerID: id, GlobalArgs: globalArgs,
+		Created: time.Now().Unix()})
+	if err != nil {
+		return false, fmt.Errorf("failed to marshal device lock: %v", err)
+	}
+	if err = writeStateFile(getLockPath(deviceID), content); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// lockDevices take the locks of all devices for the container, none is taken when any of them is in use, the
+// devices whose locks are newly taken are returned
+func lockDevices(deviceIDs []int, id string, globalArgs []string) ([]int, error) {
+	taken := make([]int, 0, len(deviceIDs))
+	err := withLockDir(func() error {
+		for _, deviceID := range deviceIDs {
+			newLock, err := takeDeviceLock(deviceID, id, globalArgs)
+			if err == nil {
+				if newLock {
+					taken = append(taken, deviceID)
+				}
+				continue
+			}
+			for _, takenID := range taken {
+				if removeErr := removeDeviceLock(&deviceLock{DeviceID: takenID}); removeErr != nil {
+					hwlog.RunLog.Warnf("%v", removeErr)
+				}
+			}
+			taken = nil
+			return err
+		}
+		hwlog.RunLog.Infof("devices %v are locked by container %s", deviceIDs, id)
+		return nil
+	})
+	return taken, err
+}
+
+// releaseDeviceLocks release the locks of the container, nil deviceIDs means all of them
+func releaseDeviceLocks(id string, deviceIDs []int) error {
+	return withLockDir(func() error {
+		locks, err := listDeviceLocks()
+		if err != nil {
+			return err
+		}
+		for _, lock := range locks {
+			if lock.ContainerID != id || (deviceIDs != nil && !containsInt(deviceIDs, lock.DeviceID)) {
+				continue
+			}
+			if err = removeDeviceLock(lock); err != nil {
+				return err
+			}
+			hwlog.RunLog.Infof("lock of %s%d is released by container %s", injector.DavinciName, lock.DeviceID, id)
+		}
+		return nil
+	})
+}
+
+// gcDeviceLocks release the locks whose container is gone
+func gcDeviceLocks() ([]*deviceLock, error) {
+	released := make([]*deviceLock, 0)
+	err := withLockDir(func() error {
+		locks, err := listDeviceLocks()
+		if err != nil {
+			return err
+		}
+		for _, lock := range locks {
+			if isLockHolderAlive(lock) {
+				continue
+			}
+			if err = removeDeviceLock(lock); err != nil {
+				return err
+			}
+			released = append(released, lock)
+		}
+		return nil
+	})
+	return released, err
+}
+
+func containsInt(list []int, n int) bool {
+	for _, item := range list {
+		if item == n {
+			return true
+		}
+	}
+	return false
+}
+
+// lockSpecDevices lock the davinci devices of the container being created when exclusive-devices is set, the locks
+// are released by a poststop hook, vnpu devices are shared by design and never locked
+func lockSpecDevices(spec *specs.Spec) error {
+	if !runtimeCfg.ExclusiveDevices || dryRun || len(deviceIdList) == 0 ||
+		strings.Contains(injector.GetValueFromSpec(spec, injector.AscendRuntimeOptions), "VIRTUAL") {
+		return nil
+	}
+	if containerID == "" {
+		return fmt.Errorf("failed to lock devices: the container id is unknown")
+	}
+	taken, err := lockDevices(deviceIdList, containerID, runcGlobalArgs)
+	if err != nil {
+		return fmt.Errorf("failed to lock devices: %v", err)
+	}
+	createLocks = taken
+	currentExecPath, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("cannot get the path of ascend-docker-runtime: %v", err)
+	}
+	if spec.Hooks == nil {
+		spec.Hooks = &specs.Hooks{}
+	}
+	spec.Hooks.Poststop = append(spec.Hooks.Poststop, specs.Hook{
+		Path: currentExecPath,
+		Args: []string{currentExecPath, lockCommand, lockReleaseCommand},
+	})
+	return nil
+}
+
+// releaseCreateLocks release the locks taken by the container failed to be created, the locks the container held
+// before are kept
+func releaseCreateLocks() {
+	if len(createLocks) == 0 {
+		return
+	}
+	if err := releaseDeviceLocks(containerID, createLocks); err != nil {
+		hwlog.RunLog.Warnf("failed to release the locks of container %s: %v", containerID, err)
+	}
+	createLocks = nil
+}
+
+// getHookContainerID get the container id from the state passed to the hook through stdin
+func getHookContainerID() (string, error) {
+	content, err := ioutil.ReadAll(io.LimitReader(lockInput, maxHookState))
+	if err != nil {
+		return "", fmt.Errorf("failed to read container state: %v", err)
+	}
+	var state specs.State
+	if err = json.Unmarshal(content, &state); err != nil {
+		return "", fmt.Errorf("failed to parse container state: %v", err)
+	}
+	return state.ID, nil
+}
+
+func writeDeviceLocks(locks []*deviceLock) error {
+	content, err := json.MarshalIndent(locks, "", previewIndent)
+	if err != nil {
+		return fmt.Errorf("failed to marshal device locks: %v", err)
+	}
+	_, err = fmt.Fprintf(lockOutput, "%s\n", content)
+	return err
+}
+
+// doLockProcess release the locks of a container, collect the stale locks or list the locks
+func doLockProcess(cmdArgs []string) error {
+	if len(cmdArgs) == 0 {
+		return fmt.Errorf("%s", lockUsage)
+	}
+	switch cmdArgs[0] {
+	case lockReleaseCommand:
+		id := ""
+		if len(cmdArgs) > 1 {
+			id = cmdArgs[1]
+		} else {
+			var err error
+			if id, err = getHookContainerID(); err != nil {
+				return err
+			}
+		}
+		if !containerIDRe.MatchString(id) {
+			return fmt.Errorf("invalid container id: %s", id)
+		}
+		return releaseDeviceLocks(id, nil)
+	case lockGCCommand:
+		released, err := gcDeviceLocks()
+		if err != nil {
+			return err
+		}
+		// the allocation records of the containers gone are pruned as well
+		if _, err = listAllocations(); err != nil {
+			return err
+		}
+		return writeDeviceLocks(released)
+	case lockListCommand:
+		var locks []*deviceLock
+		err := withLockDir(func() error {
+			var err error
+			locks, err = listDeviceLocks()
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		return writeDeviceLocks(locks)
+	default:
+		return fmt.Errorf("%s", lockUsage)
+	}
+}
@@ -0,0 +1,231 @@
+/* Copyright(C) 2023. Huawei Technologies Co.,Ltd. All rights reserved.
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package main
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/agiledragon/gomonkey/v2"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/stretchr/testify/assert"
+
+	"mindxcheckutils"
+)
+
+func stubLock(t *testing.T, alive map[string]bool) *gomonkey.Patches {
+	stub := gomonkey.ApplyGlobalVar(&lockDir, filepath.Join(t.TempDir(), "locks"))
+	stub.ApplyGlobalVar(&lockOutput, &bytes.Buffer{})
+	stub.ApplyGlobalVar(&queryContainerState, func(globalArgs []string, containerID string) (*containerState,
+		error) {
+		if !alive[containerID] {
+			return nil, nil
+		}
+		return &containerState{ID: containerID, Status: "running"}, nil
+	})
+	stub.ApplyFunc(mindxcheckutils.RealFileChecker, func(path string, checkParent, allowLink bool,
+		size int) (string, error) {
+		return path, nil
+	})
+	stub.ApplyFunc(mindxcheckutils.RealDirChecker, func(path string, checkParent, allowLink bool) (string, error) {
+		return path, nil
+	})
+	return stub
+}
+
+// ageLocks move the locks out of the grace period
+func ageLocks(t *testing.T) {
+	locks, err := listDeviceLocks()
+	assert.Nil(t, err)
+	for _, lock := range locks {
+		lock.Created = time.Now().Add(-2 * lockGracePeriod).Unix()
+		content, err := json.Marshal(lock)
+		assert.Nil(t, err)
+		assert.Nil(t, ioutil.WriteFile(getLockPath(lock.DeviceID), content, testFileMode))
+	}
+}
+
+func TestGetArgsContainerID(t *testing.T) {
+	stub := gomonkey.ApplyGlobalVar(&os.Args, []string{"runtime", "--root", "/run/docker/runc", "--log", "x",
+		"create", "--bundle", "/bundle", "abc"})
+	defer stub.Reset()
+	args, err := getArgs()
+	assert.Nil(t, err)
+	assert.EqualValues(t, "abc", args.containerID)
+	assert.EqualValues(t, []string{"--root", "/run/docker/runc"}, args.globalArgs)
+
+	stub.ApplyGlobalVar(&os.Args, []string{"runtime", "--root=/x", "create"})
+	args, err = getArgs()
+	assert.Nil(t, err)
+	assert.Empty(t, args.containerID)
+	assert.EqualValues(t, []string{"--root=/x"}, args.globalArgs)
+}
+
+func TestLockDevices(t *testing.T) {
+	alive := map[string]bool{"abc": true}
+	stub := stubLock(t, alive)
+	defer stub.Reset()
+
+	taken, err := lockDevices([]int{0, 1}, "abc", nil)
+	assert.Nil(t, err)
+	assert.EqualValues(t, []int{0, 1}, taken)
+	// the locks held already are not taken again
+	taken, err = lockDevices([]int{0, 1}, "abc", nil)
+	assert.Nil(t, err)
+	assert.Empty(t, taken)
+	// none of the devices is taken when one is in use
+	taken, err = lockDevices([]int{2, 1}, "def", nil)
+	assert.NotNil(t, err)
+	assert.Empty(t, taken)
+	_, err = os.Stat(getLockPath(2))
+	assert.True(t, os.IsNotExist(err))
+
+	// young locks are kept even if the container is not found
+	alive["abc"] = false
+	_, err = lockDevices([]int{1}, "def", nil)
+	assert.NotNil(t, err)
+	ageLocks(t)
+	_, err = lockDevices([]int{1}, "def", nil)
+	assert.Nil(t, err)
+	locks, err := listDeviceLocks()
+	assert.Nil(t, err)
+	assert.Len(t, locks, 2)
+	assert.EqualValues(t, "abc", locks[0].ContainerID)
+	assert.EqualValues(t, "def", locks[1].ContainerID)
+}
+
+func TestDoLockProcess(t *testing.T) {
+	alive := map[string]bool{"abc": true, "def": true}
+	stub := stubLock(t, alive)
+	defer stub.Reset()
+
+	_, err := lockDevices([]int{0, 1}, "abc", nil)
+	assert.Nil(t, err)
+	_, err = lockDevices([]int{2}, "def", nil)
+	assert.Nil(t, err)
+	stub.ApplyGlobalVar(&lockInput, strings.NewReader(`{"ociVersion":"1.0.2","id":"abc","status":"stopped"}`))
+	assert.Nil(t, doLockProcess([]string{"release"}))
+	locks, err := listDeviceLocks()
+	assert.Nil(t, err)
+	assert.Len(t, locks, 1)
+
+	alive["def"] = false
+	stub.ApplyGlobalVar(&allocationDir, filepath.Join(t.TempDir(), "allocations"))
+	assert.Nil(t, writeAllocation(&allocationRecord{ContainerID: "def", Devices: []int{2},
+		Created: time.Now().Add(-2 * lockGracePeriod).Unix()}))
+	assert.Nil(t, doLockProcess([]string{"gc"}))
+	// the allocation records of the containers gone are pruned by gc as well
+	_, err = os.Stat(getAllocationPath("def"))
+	assert.True(t, os.IsNotExist(err))
+	locks, err = listDeviceLocks()
+	assert.Nil(t, err)
+	assert.Len(t, locks, 1)
+	ageLocks(t)
+	output := &bytes.Buffer{}
+	stub.ApplyGlobalVar(&lockOutput, output)
+	assert.Nil(t, doLockProcess([]string{"gc"}))
+	assert.Contains(t, output.String(), `"container_id": "def"`)
+	locks, err = listDeviceLocks()
+	assert.Nil(t, err)
+	assert.Empty(t, locks)
+
+	assert.Nil(t, doLockProcess([]string{"list"}))
+	assert.NotNil(t, doLockProcess([]string{"release", "../abc"}))
+	assert.NotNil(t, doLockProcess([]string{"unknown"}))
+}
+
+func TestLockSpecDevices(t *testing.T) {
+	stub := stubLock(t, nil)
+	defer stub.Reset()
+	stub.ApplyGlobalVar(&deviceIdList, []int{3})
+	stub.ApplyGlobalVar(&containerID, "abc")
+	spec := &specs.Spec{Process: &specs.Process{}}
+
+	assert.Nil(t, lockSpecDevices(spec))
+	assert.Nil(t, spec.Hooks)
+
+	cfg := *runtimeCfg
+	cfg.ExclusiveDevices = true
+	stub.ApplyGlobalVar(&runtimeCfg, &cfg)
+	assert.Nil(t, lockSpecDevices(spec))
+	assert.Len(t, spec.Hooks.Poststop, 1)
+	assert.EqualValues(t, []string{spec.Hooks.Poststop[0].Path, lockCommand, lockReleaseCommand},
+		spec.Hooks.Poststop[0].Args)
+	locks, err := listDeviceLocks()
+	assert.Nil(t, err)
+	assert.Len(t, locks, 1)
+	assert.EqualValues(t, "abc", locks[0].ContainerID)
+	assert.EqualValues(t, []int{3}, createLocks)
+}
+
+func TestDoProcessRollback(t *testing.T) {
+	stub := stubLock(t, nil)
+	defer stub.Reset()
+	cfg := *runtimeCfg
+	cfg.ExclusiveDevices = true
+	stub.ApplyGlobalVar(&runtimeCfg, &cfg)
+	stub.ApplyGlobalVar(&allocationDir, filepath.Join(t.TempDir(), "allocations"))
+	stub.ApplyGlobalVar(&os.Args, []string{"runtime", "create", "--bundle", t.TempDir(), "abc"})
+	stub.ApplyGlobalVar(&containerID, "")
+	stub.ApplyGlobalVar(&runcGlobalArgs, []string(nil))
+	stub.ApplyGlobalVar(&createBundle, "")
+	stub.ApplyGlobalVar(&createLocks, []int(nil))
+	stub.ApplyGlobalVar(&deviceIdList, []int{3})
+	stub.ApplyGlobalVar(&physicalIdList, []int{3})
+	modifyErr := fmt.Errorf("failed to write spec")
+	stub.ApplyFunc(modifySpecFile, func(path string) error {
+		if err := lockSpecDevices(&specs.Spec{Process: &specs.Process{}}); err != nil {
+			return err
+		}
+		return modifyErr
+	})
+	execErr := fmt.Errorf("failed to exec runc")
+	stub.ApplyFunc(execRunc, func() error {
+		return execErr
+	})
+	held, err := lockDevices([]int{5}, "abc", nil)
+	assert.Nil(t, err)
+	assert.EqualValues(t, []int{5}, held)
+
+	// the locks taken by the failed create are released, the ones held before are kept
+	for _, failure := range []string{"modify", "exec"} {
+		if failure == "exec" {
+			modifyErr = nil
+		}
+		assert.NotNil(t, doProcess(), failure)
+		locks, err := listDeviceLocks()
+		assert.Nil(t, err)
+		assert.Len(t, locks, 1, failure)
+		assert.EqualValues(t, 5, locks[0].DeviceID)
+		_, err = os.Stat(getAllocationPath("abc"))
+		assert.True(t, os.IsNotExist(err), failure)
+	}
+
+	execErr = nil
+	assert.Nil(t, doProcess())
+	locks, err := listDeviceLocks()
+	assert.Nil(t, err)
+	assert.Len(t, locks, 2)
+	_, err = os.Stat(getAllocationPath("abc"))
+	assert.Nil(t, err)
+}
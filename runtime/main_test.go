@@ -16,18 +16,22 @@
 package main
 
 import (
-	"context"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
 
 	"github.com/agiledragon/gomonkey/v2"
-	"github.com/containerd/containerd/oci"
 	"github.com/opencontainers/runtime-spec/specs-go"
 	"github.com/stretchr/testify/assert"
 
+	"ascendconfig"
 	"main/dcmi"
+	"main/injector"
+	"mindxcheckutils"
 )
 
 func TestArgsIsCreate(t *testing.T) {
@@ -165,7 +169,7 @@ func TestModifySpecFileCase3(t *testing.T) {
 
 func TestAddHook(t *testing.T) {
 	var specArgs = &specs.Spec{}
-	if err := addHook(specArgs); err != nil {
+	if err := addHook(specArgs, dcmi.NewSession(&dcmi.NpuWorker{})); err != nil {
 	}
 }
 
@@ -174,7 +178,7 @@ func TestAddHookCase1(t *testing.T) {
 	stub := gomonkey.ApplyGlobalVar(&hookCliPath, ".")
 	defer stub.Reset()
 
-	err := addHook(specArgs)
+	err := addHook(specArgs, dcmi.NewSession(&dcmi.NpuWorker{}))
 	assert.NotNil(t, err)
 }
 
@@ -183,7 +187,7 @@ func TestAddHookCase2(t *testing.T) {
 	stub := gomonkey.ApplyGlobalVar(&hookCliPath, ".")
 	defer stub.Reset()
 	stub.ApplyGlobalVar(&hookDefaultFile, ".")
-	err := addHook(specArgs)
+	err := addHook(specArgs, dcmi.NewSession(&dcmi.NpuWorker{}))
 	assert.NotNil(t, err)
 }
 
@@ -195,7 +199,7 @@ func TestAddHookCase3(t *testing.T) {
 		t.Log("rename ", file)
 	}
 	var specArgs = &specs.Spec{}
-	err := addHook(specArgs)
+	err := addHook(specArgs, dcmi.NewSession(&dcmi.NpuWorker{}))
 	assert.NotNil(t, err)
 
 	if err := os.Rename(filenew, file); err != nil {
@@ -204,67 +208,15 @@ func TestAddHookCase3(t *testing.T) {
 }
 
 func TestExecRunc(t *testing.T) {
-	stub := gomonkey.ApplyGlobalVar(&dockerRuncName, "abc-runc")
-	stub.ApplyGlobalVar(&runcName, "runc123")
+	cfg := ascendconfig.Default()
+	cfg.RuntimeNames = []string{"abc-runc", "runc123"}
+	stub := gomonkey.ApplyGlobalVar(&runtimeCfg, cfg)
 	defer stub.Reset()
 
 	err := execRunc()
 	assert.NotNil(t, err)
 }
 
-func TestParseDevicesCase1(t *testing.T) {
-	visibleDevices := "0-3,5,7"
-	expectVal := []int{0, 1, 2, 3, 5, 7}
-	actualVal, err := parseDevices(visibleDevices)
-	if err != nil || !reflect.DeepEqual(expectVal, actualVal) {
-		t.Fail()
-	}
-}
-
-func TestParseDevicesCase2(t *testing.T) {
-	visibleDevices := "0-3-4,5,7"
-	_, err := parseDevices(visibleDevices)
-	assert.NotNil(t, err)
-}
-
-func TestParseDevicesCase3(t *testing.T) {
-	visibleDevices := "0l-3,5,7"
-	_, err := parseDevices(visibleDevices)
-	assert.NotNil(t, err)
-}
-
-func TestParseDevicesCase4(t *testing.T) {
-	visibleDevices := "0-3o,5,7"
-	_, err := parseDevices(visibleDevices)
-	assert.NotNil(t, err)
-}
-
-func TestParseDevicesCase5(t *testing.T) {
-	visibleDevices := "4-3,5,7"
-	_, err := parseDevices(visibleDevices)
-	assert.NotNil(t, err)
-}
-
-func TestParseDevicesCase6(t *testing.T) {
-	visibleDevices := "3o,5,7"
-	_, err := parseDevices(visibleDevices)
-	assert.NotNil(t, err)
-}
-
-func TestParseDevicesCase7(t *testing.T) {
-	visibleDevices := "0=3,5,7"
-	_, err := parseDevices(visibleDevices)
-	assert.NotNil(t, err)
-}
-
-func TestRemoveDuplication(t *testing.T) {
-	originList := []int{1, 2, 2, 4, 5, 5, 5, 6, 8, 8}
-	targetList := []int{1, 2, 4, 5, 6, 8}
-	resultList := removeDuplication(originList)
-
-	assert.EqualValues(t, targetList, resultList)
-}
-
 func TestAddEnvToDevicePlugin0(t *testing.T) {
 	devicePluginHostName := devicePlugin + "pf2i6r"
 	spec := specs.Spec{
@@ -293,65 +245,6 @@ func TestAddEnvToDevicePlugin1(t *testing.T) {
 	assert.NotContains(t, spec.Process.Env, useAscendDocker)
 }
 
-func TestGetDeviceTypeByChipName0(t *testing.T) {
-	chipName := "310B"
-	devType := GetDeviceTypeByChipName(chipName)
-	assert.EqualValues(t, Ascend310B, devType)
-}
-
-func TestGetDeviceTypeByChipName1(t *testing.T) {
-	chipName := "310P"
-	devType := GetDeviceTypeByChipName(chipName)
-	assert.EqualValues(t, Ascend310P, devType)
-}
-
-func TestGetDeviceTypeByChipName2(t *testing.T) {
-	chipName := "310"
-	devType := GetDeviceTypeByChipName(chipName)
-	assert.EqualValues(t, Ascend310, devType)
-}
-
-func TestGetDeviceTypeByChipName3(t *testing.T) {
-	chipName := "910"
-	devType := GetDeviceTypeByChipName(chipName)
-	assert.EqualValues(t, Ascend910, devType)
-}
-
-func TestGetDeviceTypeByChipName4(t *testing.T) {
-	chipName := "980b"
-	devType := GetDeviceTypeByChipName(chipName)
-	assert.EqualValues(t, "", devType)
-}
-
-func TestGetValueByKeyCase1(t *testing.T) {
-	data := []string{"ASCEND_VISIBLE_DEVICES=0-3,5,7"}
-	word := "ASCEND_VISIBLE_DEVICES"
-	expectVal := "0-3,5,7"
-	actualVal := getValueByKey(data, word)
-	assert.EqualValues(t, expectVal, actualVal)
-}
-
-func TestGetValueByKeyCase2(t *testing.T) {
-	data := []string{"ASCEND_VISIBLE_DEVICES"}
-	word := "ASCEND_VISIBLE_DEVICES"
-	expectVal := ""
-	defer func() {
-		if err := recover(); err != nil {
-			t.Log("exception occur")
-		}
-	}()
-	actualVal := getValueByKey(data, word)
-	assert.EqualValues(t, expectVal, actualVal)
-}
-
-func TestGetValueByKeyCase3(t *testing.T) {
-	data := []string{"ASCEND_VISIBLE_DEVICES=0-3,5,7"}
-	word := "ASCEND_VISIBLE_DEVICE"
-	expectVal := ""
-	actualVal := getValueByKey(data, word)
-	assert.EqualValues(t, expectVal, actualVal)
-}
-
 func TestUpdateEnvAndPostHook(t *testing.T) {
 	defer func() {
 		if e := recover(); e != nil {
@@ -367,7 +260,7 @@ func TestUpdateEnvAndPostHook(t *testing.T) {
 	spec := specs.Spec{
 		Process: &specs.Process{
 			Env: []string{"KUBE_DNS_PORT_53_UDP_PORT=53",
-				fmt.Sprintf("%s=0", ascendVisibleDevices),
+				fmt.Sprintf("%s=0", injector.AscendVisibleDevices),
 				"KUBE_DNS_PORT_53_UDP_PROTO=udp"},
 		},
 		Hooks: &specs.Hooks{},
@@ -379,139 +272,134 @@ func TestUpdateEnvAndPostHook(t *testing.T) {
 	assert.Contains(t, spec.Hooks.Poststop[0].Path, destroyHookCli)
 }
 
-func TestAddDeviceToSpec0(t *testing.T) {
-	devPath := "/dev/davinci0"
-	statStub := gomonkey.ApplyFunc(oci.DeviceFromPath, func(name string) (*specs.LinuxDevice, error) {
-		return &specs.LinuxDevice{
-			Path: devPath,
-		}, nil
-	})
-	defer statStub.Reset()
-
+func TestUpdateEnvAndPostHookWithAnnotation(t *testing.T) {
 	spec := specs.Spec{
-		Linux: &specs.Linux{
-			Devices: []specs.LinuxDevice{},
-			Resources: &specs.LinuxResources{
-				Devices: []specs.LinuxDeviceCgroup{},
-			},
-		},
+		Process:     &specs.Process{},
+		Hooks:       &specs.Hooks{},
+		Annotations: map[string]string{injector.AscendRuntimeOptionsAnnotation: "NODRV"},
 	}
 
-	err := addDeviceToSpec(&spec, devPath, notRenameDeviceType)
-	assert.Nil(t, err)
-	assert.Contains(t, spec.Linux.Devices[0].Path, devPath)
+	updateEnvAndPostHook(&spec, dcmi.VDeviceInfo{VdeviceID: 100})
+	assert.EqualValues(t, "NODRV,VIRTUAL", spec.Annotations[injector.AscendRuntimeOptionsAnnotation])
+	assert.Contains(t, spec.Process.Env, "ASCEND_RUNTIME_OPTIONS=VIRTUAL")
 }
 
-func TestAddAscend310BManagerDevice(t *testing.T) {
-	statStub := gomonkey.ApplyFunc(addDeviceToSpec, func(spec *specs.Spec, dPath string, deviceType string) error {
-		return nil
-	})
-	defer statStub.Reset()
-
-	pathStub := gomonkey.ApplyFunc(os.Stat, func(name string) (os.FileInfo, error) {
-		return nil, nil
-	})
-	defer pathStub.Reset()
+const testSpecContent = `{"ociVersion": "1.0.2", "process": {}}`
 
-	spec := specs.Spec{
-		Linux: &specs.Linux{
-			Devices: []specs.LinuxDevice{},
-			Resources: &specs.LinuxResources{
-				Devices: []specs.LinuxDeviceCgroup{},
-			},
-		},
+func writeTestSpec(t *testing.T) (string, string) {
+	dir := t.TempDir()
+	specPath := filepath.Join(dir, "config.json")
+	if err := ioutil.WriteFile(specPath, []byte(testSpecContent), 0640); err != nil {
+		t.Fatalf("write spec failed: %v", err)
 	}
+	return dir, specPath
+}
 
-	err := addAscend310BManagerDevice(&spec)
+func assertSpecUntouched(t *testing.T, dir, specPath string) {
+	content, err := ioutil.ReadFile(specPath)
+	assert.Nil(t, err)
+	assert.EqualValues(t, testSpecContent, string(content))
+	entries, err := ioutil.ReadDir(dir)
 	assert.Nil(t, err)
+	assert.Len(t, entries, 1)
 }
 
-func TestAddCommonManagerDevice(t *testing.T) {
-	statStub := gomonkey.ApplyFunc(addDeviceToSpec, func(spec *specs.Spec, dPath string, deviceType string) error {
-		return nil
-	})
-	defer statStub.Reset()
-
-	spec := specs.Spec{
-		Linux: &specs.Linux{
-			Devices: []specs.LinuxDevice{},
-			Resources: &specs.LinuxResources{
-				Devices: []specs.LinuxDeviceCgroup{},
-			},
-		},
-	}
+func TestWriteSpecFile(t *testing.T) {
+	dir, specPath := writeTestSpec(t)
+	stat, err := os.Stat(specPath)
+	assert.Nil(t, err)
 
-	err := addCommonManagerDevice(&spec)
+	assert.Nil(t, writeSpecFile(specPath, []byte(`{"ociVersion": "1.0.3"}`), stat))
+	content, err := ioutil.ReadFile(specPath)
+	assert.Nil(t, err)
+	assert.EqualValues(t, `{"ociVersion": "1.0.3"}`, string(content))
+	newStat, err := os.Stat(specPath)
+	assert.Nil(t, err)
+	assert.EqualValues(t, stat.Mode(), newStat.Mode())
+	entries, err := ioutil.ReadDir(dir)
 	assert.Nil(t, err)
+	assert.Len(t, entries, 1)
 }
 
-func TestAddManagerDevice(t *testing.T) {
-	devPath := "/dev/mockdevice"
-	statStub := gomonkey.ApplyFunc(oci.DeviceFromPath, func(dPath string) (*specs.LinuxDevice, error) {
-		return &specs.LinuxDevice{
-			Path: devPath,
-		}, nil
-	})
-	defer statStub.Reset()
-
-	dcmiStub := gomonkey.ApplyFunc(dcmi.GetChipName, func() (string, error) {
-		return "910", nil
-	})
-	defer dcmiStub.Reset()
-
-	productStub := gomonkey.ApplyFunc(dcmi.GetProductType, func(w dcmi.WorkerInterface) (string, error) {
-		return "", nil
-	})
-	defer productStub.Reset()
-
-	spec := specs.Spec{
-		Linux: &specs.Linux{
-			Devices: []specs.LinuxDevice{},
-			Resources: &specs.LinuxResources{
-				Devices: []specs.LinuxDeviceCgroup{},
-			},
+func TestWriteSpecFileFailure(t *testing.T) {
+	fileType := reflect.TypeOf(&os.File{})
+	failures := map[string]func() *gomonkey.Patches{
+		"write": func() *gomonkey.Patches {
+			return gomonkey.ApplyMethod(fileType, "Write", func(_ *os.File, _ []byte) (int, error) {
+				return 0, fmt.Errorf("no space left")
+			})
+		},
+		"chmod": func() *gomonkey.Patches {
+			return gomonkey.ApplyMethod(fileType, "Chmod", func(_ *os.File, _ os.FileMode) error {
+				return fmt.Errorf("chmod failed")
+			})
+		},
+		"chown": func() *gomonkey.Patches {
+			return gomonkey.ApplyMethod(fileType, "Chown", func(_ *os.File, _, _ int) error {
+				return fmt.Errorf("chown failed")
+			})
+		},
+		"sync": func() *gomonkey.Patches {
+			return gomonkey.ApplyMethod(fileType, "Sync", func(_ *os.File) error {
+				return fmt.Errorf("sync failed")
+			})
+		},
+		"rename": func() *gomonkey.Patches {
+			return gomonkey.ApplyFunc(os.Rename, func(_, _ string) error {
+				return fmt.Errorf("rename failed")
+			})
 		},
 	}
-	ctx, _ := context.WithCancel(context.Background())
-	err := initLogModule(ctx)
-	assert.Nil(t, err)
-	err = addManagerDevice(&spec)
-	assert.Nil(t, err)
+	for step, applyFailure := range failures {
+		dir, specPath := writeTestSpec(t)
+		stat, err := os.Stat(specPath)
+		assert.Nil(t, err)
+
+		stub := applyFailure()
+		err = writeSpecFile(specPath, []byte(`{"ociVersion": "1.0.3"}`), stat)
+		stub.Reset()
+		assert.NotNil(t, err, step)
+		assertSpecUntouched(t, dir, specPath)
+	}
 }
 
-func TestAddDevice(t *testing.T) {
-	devPath := "/dev/davinci1"
-	statStub := gomonkey.ApplyFunc(oci.DeviceFromPath, func(name string) (*specs.LinuxDevice, error) {
-		return &specs.LinuxDevice{
-			Path: devPath,
-		}, nil
+func TestModifySpecFileMarshalFailure(t *testing.T) {
+	dir, specPath := writeTestSpec(t)
+	stub := gomonkey.ApplyFunc(mindxcheckutils.RealFileChecker,
+		func(path string, checkParent, allowLink bool, size int) (string, error) {
+			return path, nil
+		})
+	defer stub.Reset()
+	stub.ApplyFunc(json.Marshal, func(_ interface{}) ([]byte, error) {
+		return nil, fmt.Errorf("marshal failed")
 	})
-	defer statStub.Reset()
 
-	manageDeviceStub := gomonkey.ApplyFunc(addManagerDevice, func(spec *specs.Spec) error {
+	assert.NotNil(t, modifySpecFile(specPath))
+	assertSpecUntouched(t, dir, specPath)
+}
+
+func TestModifySpecExportLdLibraryPath(t *testing.T) {
+	stub := gomonkey.ApplyFunc(injector.CheckVisibleDevice,
+		func(spec *specs.Spec, cfg *ascendconfig.Config, session *dcmi.Session) ([]int, error) {
+			return []int{0}, nil
+		})
+	defer stub.Reset()
+	stub.ApplyFunc(addHook, func(spec *specs.Spec, session *dcmi.Session) error {
 		return nil
 	})
-	defer manageDeviceStub.Reset()
+	stub.ApplyFunc(injector.AddDevice, func(spec *specs.Spec, deviceIDs []int, cfg *ascendconfig.Config,
+		session *dcmi.Session) error {
+		return nil
+	})
+	cfg := ascendconfig.Default()
+	cfg.ExportLdLibraryPath = false
+	stub.ApplyGlobalVar(&runtimeCfg, cfg)
 
-	spec := specs.Spec{
-		Linux: &specs.Linux{
-			Devices: []specs.LinuxDevice{},
-			Resources: &specs.LinuxResources{
-				Devices: []specs.LinuxDeviceCgroup{},
-			},
-		},
-		Process: &specs.Process{
-			Env: []string{"KUBE_DNS_PORT_53_UDP_PORT=53",
-				"ASCEND_VISIBLE_DEVICES=1",
-				"ASCEND_RUNTIME_OPTIONS=",
-				"KUBE_DNS_PORT_53_UDP_PROTO=udp"},
-		},
-	}
+	spec := &specs.Spec{Process: &specs.Process{Env: []string{"ASCEND_VISIBLE_DEVICES=0"}}}
+	assert.Nil(t, modifySpec(spec))
+	assert.EqualValues(t, []string{"ASCEND_VISIBLE_DEVICES=0"}, spec.Process.Env)
 
-	ctx, _ := context.WithCancel(context.Background())
-	err := initLogModule(ctx)
-	assert.Nil(t, err)
-	err = addDevice(&spec)
-	assert.Nil(t, err)
-	assert.Contains(t, spec.Linux.Devices[0].Path, devPath)
+	cfg.ExportLdLibraryPath = true
+	assert.Nil(t, modifySpec(spec))
+	assert.Contains(t, spec.Process.Env, "LD_LIBRARY_PATH="+ascendconfig.DefaultLdLibraryPath)
 }
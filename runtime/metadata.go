@@ -0,0 +1,76 @@
+/* Copyright(C) 2023. Huawei Technologies Co.,Ltd. All rights reserved.
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package main
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"huawei.com/npu-exporter/v5/common-utils/hwlog"
+
+	"main/dcmi"
+	"main/injector"
+)
+
+// injectedFileMode the files injected are read by any user of the container
+const injectedFileMode = 0644
+
+var metadataDir = "/run/ascend-docker-runtime/metadata"
+
+func getMetadataPath(id string) string {
+	return filepath.Join(metadataDir, id+".json")
+}
+
+// injectFile write content to hostPath and bind it read-only at containerPath of the container
+func injectFile(spec *specs.Spec, hostPath, containerPath string, content []byte) error {
+	if err := writeStateFile(hostPath, content); err != nil {
+		return err
+	}
+	if err := os.Chmod(hostPath, injectedFileMode); err != nil {
+		return fmt.Errorf("failed to set mode of %s: %v", hostPath, err)
+	}
+	spec.Mounts = append(spec.Mounts, specs.Mount{
+		Destination: containerPath,
+		Type:        "bind",
+		Source:      hostPath,
+		Options:     []string{"bind", "ro", "nosuid", "nodev", "noexec"},
+	})
+	return nil
+}
+
+// injectDeviceMetadata tell the container its devices by ENV and a read-only json file, the container is created
+// without them when they cannot be collected
+func injectDeviceMetadata(spec *specs.Spec, session *dcmi.Session) {
+	metadata, err := injector.GetDeviceMetadata(physicalIdList, vnpuIdList, runtimeCfg, session)
+	if err != nil {
+		hwlog.RunLog.Warnf("device metadata is not injected: %v", err)
+		return
+	}
+	injector.AddDeviceMetadataEnv(spec, metadata)
+	if dryRun || containerID == "" {
+		return
+	}
+	content, err := json.MarshalIndent(metadata, "", previewIndent)
+	if err == nil {
+		err = injectFile(spec, getMetadataPath(containerID), injector.DeviceMetadataPath, content)
+	}
+	if err != nil {
+		hwlog.RunLog.Warnf("%s is not injected: %v", injector.DeviceMetadataPath, err)
+	}
+}
@@ -0,0 +1,231 @@
+/* Copyright(C) 2023. Huawei Technologies Co.,Ltd. All rights reserved.
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package main NRI plugin injecting Ascend devices into containers created by containerd or CRI-O
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/containerd/nri/pkg/api"
+	"github.com/containerd/nri/pkg/stub"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"huawei.com/npu-exporter/v5/common-utils/hwlog"
+
+	"ascendconfig"
+	"main/dcmi"
+	"main/injector"
+	"mindxcheckutils"
+)
+
+const (
+	defaultPluginName = "ascend-docker-nri-plugin"
+	defaultPluginIdx  = "10"
+	runLogName        = "nri-plugin-run.log"
+	ldEnvKey          = "LD_LIBRARY_PATH"
+	kvPairSize        = 2
+	noDrvOption       = "NODRV"
+	virtualFlag       = "VIRTUAL"
+)
+
+var pluginCfg = ascendconfig.Default()
+
+type plugin struct{}
+
+func initLogModule(ctx context.Context) error {
+	const backups = 2
+	const logMaxAge = 365
+	runLogConfig := hwlog.LogConfig{
+		LogFileName: pluginCfg.LogFile(runLogName),
+		LogLevel:    pluginCfg.LogLevel,
+		MaxBackups:  backups,
+		MaxAge:      logMaxAge,
+		OnlyToFile:  true,
+		FileMaxSize: 2,
+	}
+	if err := hwlog.InitRunLogger(&runLogConfig, ctx); err != nil {
+		fmt.Printf("hwlog init failed, error is %v", err)
+		return err
+	}
+	return nil
+}
+
+// toSpec build a scratch spec from the container so that the runtime logic can be reused
+func toSpec(ctr *api.Container) *specs.Spec {
+	spec := &specs.Spec{
+		Process:     &specs.Process{Env: append([]string{}, ctr.GetEnv()...)},
+		Annotations: make(map[string]string, len(ctr.GetAnnotations())),
+		Linux: &specs.Linux{
+			Resources: &specs.LinuxResources{},
+		},
+	}
+	for key, value := range ctr.GetAnnotations() {
+		spec.Annotations[key] = value
+	}
+	for _, mount := range ctr.GetMounts() {
+		spec.Mounts = append(spec.Mounts, specs.Mount{
+			Destination: mount.Destination,
+			Type:        mount.Type,
+			Source:      mount.Source,
+			Options:     mount.Options,
+		})
+	}
+	return spec
+}
+
+func getEnvValue(env []string, name string) (string, bool) {
+	for _, line := range env {
+		words := strings.SplitN(line, "=", kvPairSize)
+		if len(words) == kvPairSize && words[0] == name {
+			return words[1], true
+		}
+	}
+	return "", false
+}
+
+func addMounts(adjust *api.ContainerAdjustment, spec *specs.Spec) error {
+	mountConfigs := ascendconfig.ParseMounts(injector.GetValueFromSpec(spec, injector.AscendRuntimeMounts))
+	fileMountList, dirMountList, err := ascendconfig.ReadMountConfigs(ascendconfig.MountConfigDir, mountConfigs,
+		pluginCfg.GetDriverRoot())
+	if err != nil {
+		return fmt.Errorf("failed to read configuration from config directory: %v", err)
+	}
+	for _, mountPath := range append(fileMountList, dirMountList...) {
+		adjust.AddMount(&api.Mount{
+			Destination: mountPath,
+			Type:        "bind",
+			Source:      ascendconfig.HostPath(pluginCfg.GetDriverRoot(), mountPath),
+			Options:     []string{"rbind", "ro", "nosuid", "nodev"},
+		})
+	}
+	return nil
+}
+
+// adjustContainer get the devices, mounts and ENV of the Ascend device request of the container
+func adjustContainer(ctr *api.Container) (*api.ContainerAdjustment, error) {
+	spec := toSpec(ctr)
+	injector.SetMountedDeviceRequest(spec, pluginCfg)
+	session := injector.NewSession(pluginCfg)
+	defer session.Close()
+	devices, err := injector.CheckVisibleDevice(spec, pluginCfg, session)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check ASCEND_VISIBLE_DEVICES parameter, err: %v", err)
+	}
+	if devices == nil {
+		return nil, nil
+	}
+	options := injector.GetValueFromSpec(spec, injector.AscendRuntimeOptions)
+	if _, ok := getEnvValue(spec.Process.Env, dcmi.VnpuSpecsEnv); ok && !strings.Contains(options, virtualFlag) {
+		return nil, fmt.Errorf("creating vnpu by %s is not supported by the nri plugin", dcmi.VnpuSpecsEnv)
+	}
+	if !strings.Contains(options, virtualFlag) {
+		if err = injector.CheckTopologyPolicy(spec, devices, session); err != nil {
+			return nil, fmt.Errorf("failed to check topology of devices: %v", err)
+		}
+	}
+
+	adjust := &api.ContainerAdjustment{}
+	if err = injector.AddDevice(spec, devices, pluginCfg, session); err != nil {
+		return nil, fmt.Errorf("failed to add device: %v", err)
+	}
+	for _, device := range spec.Linux.Devices {
+		adjust.AddDevice(&api.LinuxDevice{
+			Path:  device.Path,
+			Type:  device.Type,
+			Major: device.Major,
+			Minor: device.Minor,
+		})
+	}
+	// the request of volume mounts is exposed as the annotation, as ascend-docker-runtime does
+	if value, ok := spec.Annotations[injector.AscendVisibleDevicesAnnotation]; ok {
+		adjust.AddAnnotation(injector.AscendVisibleDevicesAnnotation, value)
+	}
+	if strings.Contains(options, noDrvOption) {
+		return adjust, nil
+	}
+
+	if err = injector.AddLDEnv(spec, pluginCfg.LdLibraryPath); err != nil {
+		return nil, fmt.Errorf("failed to add LD_LIBRARY_PATH to env: %v", err)
+	}
+	if value, ok := getEnvValue(spec.Process.Env[len(ctr.GetEnv()):], ldEnvKey); ok {
+		adjust.AddEnv(ldEnvKey, value)
+	}
+	if err = addMounts(adjust, spec); err != nil {
+		return nil, err
+	}
+	return adjust, nil
+}
+
+// CreateContainer inject the requested Ascend devices when the container is created
+func (p *plugin) CreateContainer(_ context.Context, pod *api.PodSandbox, ctr *api.Container) (
+	*api.ContainerAdjustment, []*api.ContainerUpdate, error) {
+	adjust, err := adjustContainer(ctr)
+	if err != nil {
+		hwlog.RunLog.Errorf("failed to adjust container %s of pod %s: %v", ctr.GetName(), pod.GetName(), err)
+		return nil, nil, err
+	}
+	if adjust != nil {
+		hwlog.RunLog.Infof("container %s of pod %s gets %d devices", ctr.GetName(), pod.GetName(),
+			len(adjust.GetLinux().GetDevices()))
+	}
+	return adjust, nil, nil
+}
+
+// newPluginStub create the NRI stub of the plugin, empty socketPath means the default socket of NRI
+func newPluginStub(name, idx, socketPath string) (stub.Stub, error) {
+	opts := []stub.Option{stub.WithPluginName(name), stub.WithPluginIdx(idx)}
+	if socketPath != "" {
+		opts = append(opts, stub.WithSocketPath(socketPath))
+	}
+	return stub.New(&plugin{}, opts...)
+}
+
+func main() {
+	pluginName := flag.String("name", defaultPluginName, "plugin name to register to NRI")
+	pluginIdx := flag.String("idx", defaultPluginIdx, "plugin index to register to NRI")
+	socketPath := flag.String("socket", "", "NRI socket path, the default one of NRI is used when it is empty")
+	flag.Parse()
+
+	cfg, err := ascendconfig.Load(ascendconfig.DefaultConfigPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	pluginCfg = cfg
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err = initLogModule(ctx); err != nil {
+		log.Fatal(err)
+	}
+	if !mindxcheckutils.StringChecker(strings.Join(os.Args, " "), 0, mindxcheckutils.DefaultPathSize,
+		mindxcheckutils.DefaultWhiteList+" ") {
+		hwlog.RunLog.Error("ascend nri plugin args check failed")
+		log.Fatal("command error")
+	}
+
+	s, err := newPluginStub(*pluginName, *pluginIdx, *socketPath)
+	if err != nil {
+		hwlog.RunLog.Errorf("failed to create nri stub: %v", err)
+		log.Fatal(err)
+	}
+	hwlog.RunLog.Infof("%s starting", *pluginName)
+	if err = s.Run(ctx); err != nil {
+		hwlog.RunLog.Errorf("nri plugin exited: %v", err)
+		log.Fatal(err)
+	}
+}
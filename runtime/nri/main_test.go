@@ -0,0 +1,167 @@
+/* Copyright(C) 2023. Huawei Technologies Co.,Ltd. All rights reserved.
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package main
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/agiledragon/gomonkey/v2"
+	"github.com/containerd/containerd/oci"
+	"github.com/containerd/nri/pkg/adaptation"
+	"github.com/containerd/nri/pkg/api"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/stretchr/testify/assert"
+
+	"ascendconfig"
+	"main/dcmi"
+	"main/injector"
+)
+
+func stubInjection() *gomonkey.Patches {
+	stub := gomonkey.ApplyFunc(oci.DeviceFromPath, func(dPath string) (*specs.LinuxDevice, error) {
+		return &specs.LinuxDevice{Path: dPath, Type: "c", Major: 236}, nil
+	})
+	stub.ApplyFunc(injector.AddManagerDevice, func(spec *specs.Spec, deviceIDs []int, devRoot string,
+		session *dcmi.Session) error {
+		return nil
+	})
+	stub.ApplyFunc(ascendconfig.ReadMountConfigs, func(dir string, configs []string, root string) ([]string, []string, error) {
+		return []string{"/usr/local/bin/npu-smi"}, []string{"/usr/local/Ascend/driver/lib64"}, nil
+	})
+	return stub
+}
+
+func TestAdjustContainer(t *testing.T) {
+	stub := stubInjection()
+	defer stub.Reset()
+
+	adjust, err := adjustContainer(&api.Container{Env: []string{"ASCEND_VISIBLE_DEVICES=0-1"}})
+	assert.Nil(t, err)
+	assert.Len(t, adjust.GetLinux().GetDevices(), 2)
+	assert.EqualValues(t, "/dev/davinci1", adjust.GetLinux().GetDevices()[1].Path)
+	assert.Len(t, adjust.GetMounts(), 2)
+	assert.EqualValues(t, ldEnvKey, adjust.GetEnv()[0].Key)
+}
+
+func TestAdjustContainerNoRequest(t *testing.T) {
+	adjust, err := adjustContainer(&api.Container{Env: []string{"PATH=/usr/bin"}})
+	assert.Nil(t, err)
+	assert.Nil(t, adjust)
+}
+
+func TestAdjustContainerNoDriver(t *testing.T) {
+	stub := stubInjection()
+	defer stub.Reset()
+
+	adjust, err := adjustContainer(&api.Container{
+		Annotations: map[string]string{injector.AscendVisibleDevicesAnnotation: "3",
+			injector.AscendRuntimeOptionsAnnotation: "NODRV"},
+	})
+	assert.Nil(t, err)
+	assert.Len(t, adjust.GetLinux().GetDevices(), 1)
+	assert.Empty(t, adjust.GetMounts())
+	assert.Empty(t, adjust.GetEnv())
+}
+
+func TestAdjustContainerWithVnpuSpecs(t *testing.T) {
+	_, err := adjustContainer(&api.Container{Env: []string{"ASCEND_VISIBLE_DEVICES=0", "ASCEND_VNPU_SPECS=vir04"}})
+	assert.NotNil(t, err)
+}
+
+func TestAdjustContainerWithVolumeMounts(t *testing.T) {
+	stub := stubInjection()
+	defer stub.Reset()
+	cfg := *pluginCfg
+	cfg.AcceptVolumeMounts = true
+	stub.ApplyGlobalVar(&pluginCfg, &cfg)
+
+	ctr := &api.Container{
+		Annotations: map[string]string{},
+		Mounts:      []*api.Mount{{Source: "/dev/null", Destination: "/var/run/ascend-container-devices/2"}},
+	}
+	adjust, err := adjustContainer(ctr)
+	assert.Nil(t, err)
+	assert.Len(t, adjust.GetLinux().GetDevices(), 1)
+	assert.EqualValues(t, "2", adjust.GetAnnotations()[injector.AscendVisibleDevicesAnnotation])
+	// the container of the request is not changed
+	assert.Empty(t, ctr.Annotations)
+}
+
+// startTestRuntime start the runtime side of NRI listening on socketPath, as containerd or CRI-O does
+func startTestRuntime(t *testing.T, dir, socketPath string) *adaptation.Adaptation {
+	syncFn := func(ctx context.Context, cb adaptation.SyncCB) error {
+		_, err := cb(ctx, nil, nil)
+		return err
+	}
+	updateFn := func(context.Context, []*adaptation.ContainerUpdate) ([]*adaptation.ContainerUpdate, error) {
+		return nil, nil
+	}
+	runtime, err := adaptation.New("ascend-test-runtime", "0.0.1", syncFn, updateFn,
+		adaptation.WithPluginPath(filepath.Join(dir, "plugins")),
+		adaptation.WithPluginConfigPath(filepath.Join(dir, "conf.d")),
+		adaptation.WithSocketPath(socketPath))
+	assert.Nil(t, err)
+	assert.Nil(t, runtime.Start())
+	return runtime
+}
+
+func TestPluginOverSocket(t *testing.T) {
+	stub := stubInjection()
+	defer stub.Reset()
+	// unix socket paths are limited to about 100 bytes, the test temp dir may be too long
+	dir, err := os.MkdirTemp("", "nri")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	socketPath := filepath.Join(dir, "nri.sock")
+	runtime := startTestRuntime(t, dir, socketPath)
+	defer runtime.Stop()
+
+	pluginStub, err := newPluginStub(defaultPluginName, defaultPluginIdx, socketPath)
+	assert.Nil(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	assert.Nil(t, pluginStub.Start(ctx))
+	defer pluginStub.Stop()
+
+	req := &adaptation.CreateContainerRequest{
+		Pod:       &api.PodSandbox{Id: "pod0", Name: "pod0"},
+		Container: &api.Container{Id: "ctr0", Name: "ctr0", Env: []string{"ASCEND_VISIBLE_DEVICES=0-1"}},
+	}
+	const retries = 50
+	var rsp *adaptation.CreateContainerResponse
+	// the runtime adds the plugin once its registration is synchronized
+	for i := 0; i < retries; i++ {
+		rsp, err = runtime.CreateContainer(ctx, req)
+		assert.Nil(t, err)
+		if len(rsp.GetAdjust().GetLinux().GetDevices()) != 0 {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	devices := rsp.GetAdjust().GetLinux().GetDevices()
+	assert.Len(t, devices, 2)
+	assert.EqualValues(t, "/dev/davinci0", devices[0].Path)
+	assert.Len(t, rsp.GetAdjust().GetMounts(), 2)
+
+	req.Container = &api.Container{Id: "ctr1", Name: "ctr1", Env: []string{"PATH=/usr/bin"}}
+	rsp, err = runtime.CreateContainer(ctx, req)
+	assert.Nil(t, err)
+	assert.Empty(t, rsp.GetAdjust().GetLinux().GetDevices())
+}
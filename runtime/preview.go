@@ -0,0 +1,172 @@
+/* Copyright(C) 2023. Huawei Technologies Co.,Ltd. All rights reserved.
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package main
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/pmezard/go-difflib/difflib"
+
+	"ascendconfig"
+	"main/injector"
+	"mindxcheckutils"
+)
+
+const (
+	previewCommand    = "spec-preview"
+	previewDiffOption = "--diff"
+	previewIndent     = "    "
+	diffContextLines  = 3
+	noDrvOption       = "NODRV"
+)
+
+var previewOutput io.Writer = os.Stdout
+
+type previewArgs struct {
+	bundleDirPath string
+	diff          bool
+}
+
+func getPreviewArgs(cmdArgs []string) (*previewArgs, error) {
+	args := &previewArgs{}
+	for i, param := range cmdArgs {
+		switch param {
+		case "--bundle", "-b":
+			if len(cmdArgs)-i <= 1 {
+				return nil, fmt.Errorf("bundle option needs an argument")
+			}
+			args.bundleDirPath = cmdArgs[i+1]
+		case previewDiffOption:
+			args.diff = true
+		default:
+		}
+	}
+	if args.bundleDirPath == "" {
+		return nil, fmt.Errorf("usage: ascend-docker-runtime spec-preview --bundle <dir> [--diff]")
+	}
+	return args, nil
+}
+
+func readSpecFile(specPath string) (*specs.Spec, error) {
+	if _, err := mindxcheckutils.RealFileChecker(specPath, true, true, mindxcheckutils.DefaultSize); err != nil {
+		return nil, err
+	}
+	jsonContent, err := ioutil.ReadFile(specPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read oci spec file %s: %v", specPath, err)
+	}
+	var spec specs.Spec
+	if err = json.Unmarshal(jsonContent, &spec); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal oci spec file %s: %v", specPath, err)
+	}
+	return &spec, nil
+}
+
+// getHookMounts get the files and dirs ascend-docker-hook would mount into the container
+func getHookMounts(spec *specs.Spec) ([]string, []string, error) {
+	if spec.Hooks == nil {
+		return nil, nil, nil
+	}
+	hookInjected := false
+	for _, hook := range spec.Hooks.Prestart {
+		if strings.Contains(hook.Path, hookCli) || hook.Path == hookCliPath {
+			hookInjected = true
+			break
+		}
+	}
+	if !hookInjected || strings.Contains(injector.GetValueFromSpec(spec, injector.AscendRuntimeOptions), noDrvOption) {
+		return nil, nil, nil
+	}
+	mountConfigs := ascendconfig.ParseMounts(injector.GetValueFromSpec(spec, injector.AscendRuntimeMounts))
+	return ascendconfig.ReadMountConfigs(ascendconfig.MountConfigDir, mountConfigs, runtimeCfg.GetDriverRoot())
+}
+
+func writePreview(original, modified *specs.Spec, diff bool) error {
+	originalContent, err := json.MarshalIndent(original, "", previewIndent)
+	if err != nil {
+		return fmt.Errorf("failed to marshal OCI spec: %v", err)
+	}
+	modifiedContent, err := json.MarshalIndent(modified, "", previewIndent)
+	if err != nil {
+		return fmt.Errorf("failed to marshal OCI spec: %v", err)
+	}
+	output := string(modifiedContent) + "\n"
+	if diff {
+		output, err = difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+			A:        difflib.SplitLines(string(originalContent) + "\n"),
+			B:        difflib.SplitLines(output),
+			FromFile: "config.json",
+			ToFile:   "config.json (" + previewCommand + ")",
+			Context:  diffContextLines,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to diff OCI spec: %v", err)
+		}
+	}
+
+	fileMountList, dirMountList, err := getHookMounts(modified)
+	if err != nil {
+		return fmt.Errorf("failed to get mounts of hook: %v", err)
+	}
+	for _, filePath := range fileMountList {
+		output += "# hook mount file " + getMountDescription(filePath) + "\n"
+	}
+	for _, dirPath := range dirMountList {
+		output += "# hook mount dir " + getMountDescription(dirPath) + "\n"
+	}
+	_, err = io.WriteString(previewOutput, output)
+	return err
+}
+
+// getMountDescription get the mount path, with its source on the host when the driver root is not /
+func getMountDescription(mountPath string) string {
+	hostPath := ascendconfig.HostPath(runtimeCfg.GetDriverRoot(), mountPath)
+	if hostPath == mountPath {
+		return mountPath
+	}
+	return mountPath + " from " + hostPath
+}
+
+// doPreviewProcess run the spec modification on a copy of config.json without exec'ing runc
+func doPreviewProcess(cmdArgs []string) error {
+	args, err := getPreviewArgs(cmdArgs)
+	if err != nil {
+		return err
+	}
+	specPath := filepath.Join(args.bundleDirPath, "config.json")
+	original, err := readSpecFile(specPath)
+	if err != nil {
+		return err
+	}
+	// read it twice to get a deep copy
+	modified, err := readSpecFile(specPath)
+	if err != nil {
+		return err
+	}
+
+	dryRun = true
+	if err = modifySpec(modified); err != nil {
+		return fmt.Errorf("failed to modify spec file %s: %v", specPath, err)
+	}
+	return writePreview(original, modified, args.diff)
+}
@@ -0,0 +1,66 @@
+/* Copyright(C) 2023. Huawei Technologies Co.,Ltd. All rights reserved.
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package main
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/agiledragon/gomonkey/v2"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/stretchr/testify/assert"
+
+	"ascendconfig"
+)
+
+func TestGetPreviewArgs(t *testing.T) {
+	args, err := getPreviewArgs([]string{"--bundle", "/run/bundle", "--diff"})
+	assert.Nil(t, err)
+	assert.EqualValues(t, "/run/bundle", args.bundleDirPath)
+	assert.True(t, args.diff)
+
+	_, err = getPreviewArgs([]string{"--diff"})
+	assert.NotNil(t, err)
+	_, err = getPreviewArgs([]string{"-b"})
+	assert.NotNil(t, err)
+}
+
+func TestWritePreview(t *testing.T) {
+	stub := gomonkey.ApplyFunc(ascendconfig.ReadMountConfigs,
+		func(dir string, configs []string, root string) ([]string, []string, error) {
+			return []string{"/usr/local/bin/npu-smi"}, []string{"/usr/local/Ascend/driver/lib64"}, nil
+		})
+	defer stub.Reset()
+	var output bytes.Buffer
+	stub.ApplyGlobalVar(&previewOutput, &output)
+
+	original := &specs.Spec{Process: &specs.Process{Env: []string{"ASCEND_VISIBLE_DEVICES=0"}}}
+	modified := &specs.Spec{
+		Process: &specs.Process{Env: []string{"ASCEND_VISIBLE_DEVICES=0", "LD_LIBRARY_PATH=/usr/local/lib"}},
+		Hooks:   &specs.Hooks{Prestart: []specs.Hook{{Path: "/usr/local/bin/ascend-docker-hook"}}},
+	}
+	assert.Nil(t, writePreview(original, modified, true))
+	assert.Contains(t, output.String(), "+++ config.json (spec-preview)")
+	assert.Contains(t, output.String(), `+            "LD_LIBRARY_PATH=/usr/local/lib"`)
+	assert.Contains(t, output.String(), "# hook mount file /usr/local/bin/npu-smi")
+	assert.Contains(t, output.String(), "# hook mount dir /usr/local/Ascend/driver/lib64")
+
+	output.Reset()
+	modified.Process.Env = append(modified.Process.Env, "ASCEND_RUNTIME_OPTIONS=NODRV")
+	assert.Nil(t, writePreview(original, modified, false))
+	assert.Contains(t, output.String(), `"LD_LIBRARY_PATH=/usr/local/lib"`)
+	assert.NotContains(t, output.String(), "# hook mount")
+}
@@ -0,0 +1,79 @@
+/* Copyright(C) 2023. Huawei Technologies Co.,Ltd. All rights reserved.
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package main
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"huawei.com/npu-exporter/v5/common-utils/hwlog"
+
+	"main/dcmi"
+	"main/injector"
+)
+
+var rankTableDir = "/run/ascend-docker-runtime/ranktables"
+
+func getRankTablePath(id string) string {
+	return filepath.Join(rankTableDir, id+".json")
+}
+
+// getServerID get the first global ipv4 address of the host as the server id of the rank table
+var getServerID = func() string {
+	addrs, err := net.InterfaceAddrs()
+	if err == nil {
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.To4() != nil && ipNet.IP.IsGlobalUnicast() {
+				return ipNet.IP.String()
+			}
+		}
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		return "localhost"
+	}
+	return hostname
+}
+
+// injectRankTable render the rank table of the physical devices of a multi-device container at rank-table-path
+// and tell its path by RANK_TABLE_FILE, a rank table given by the user is kept
+func injectRankTable(spec *specs.Spec, session *dcmi.Session) {
+	if runtimeCfg.RankTablePath == "" || dryRun || containerID == "" || len(physicalIdList) < 2 ||
+		len(vnpuIdList) != 0 {
+		return
+	}
+	if injector.GetValueByKey(spec.Process.Env, injector.RankTableFile) != "" {
+		hwlog.RunLog.Infof("%s is given by the user, the rank table is not injected", injector.RankTableFile)
+		return
+	}
+	rankTable, err := injector.GetRankTable(physicalIdList, getServerID(), session)
+	if err != nil {
+		hwlog.RunLog.Warnf("rank table is not injected: %v", err)
+		return
+	}
+	content, err := json.MarshalIndent(rankTable, "", previewIndent)
+	if err == nil {
+		err = injectFile(spec, getRankTablePath(containerID), runtimeCfg.RankTablePath, content)
+	}
+	if err != nil {
+		hwlog.RunLog.Warnf("rank table is not injected: %v", err)
+		return
+	}
+	spec.Process.Env = append(spec.Process.Env, injector.RankTableFile+"="+runtimeCfg.RankTablePath)
+}
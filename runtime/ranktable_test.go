@@ -0,0 +1,64 @@
+/* Copyright(C) 2023. Huawei Technologies Co.,Ltd. All rights reserved.
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package main
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/stretchr/testify/assert"
+
+	"main/dcmi"
+	"main/injector"
+)
+
+func TestInjectRankTable(t *testing.T) {
+	stub := stubLock(t, nil)
+	defer stub.Reset()
+	stub.ApplyGlobalVar(&rankTableDir, filepath.Join(t.TempDir(), "ranktables"))
+	stub.ApplyGlobalVar(&containerID, "abc")
+	stub.ApplyGlobalVar(&physicalIdList, []int{0, 1})
+	stub.ApplyGlobalVar(&getServerID, func() string { return "10.0.0.1" })
+	stub.ApplyFunc(injector.GetRankTable, func(deviceIDs []int, serverID string,
+		session *dcmi.Session) (*injector.RankTable, error) {
+		return &injector.RankTable{ServerCount: "1", ServerList: []injector.RankTableServer{{ServerID: serverID}}},
+			nil
+	})
+
+	spec := &specs.Spec{Process: &specs.Process{}}
+	injectRankTable(spec, nil)
+	assert.EqualValues(t, []string{injector.RankTableFile + "=" + runtimeCfg.RankTablePath}, spec.Process.Env)
+	assert.Len(t, spec.Mounts, 1)
+	assert.EqualValues(t, runtimeCfg.RankTablePath, spec.Mounts[0].Destination)
+	content, err := ioutil.ReadFile(spec.Mounts[0].Source)
+	assert.Nil(t, err)
+	var rankTable injector.RankTable
+	assert.Nil(t, json.Unmarshal(content, &rankTable))
+	assert.EqualValues(t, "10.0.0.1", rankTable.ServerList[0].ServerID)
+
+	// the rank table of the user is kept
+	spec = &specs.Spec{Process: &specs.Process{Env: []string{injector.RankTableFile + "=/job/hccl.json"}}}
+	injectRankTable(spec, nil)
+	assert.Empty(t, spec.Mounts)
+	// a single device needs no rank table
+	physicalIdList = []int{0}
+	spec = &specs.Spec{Process: &specs.Process{}}
+	injectRankTable(spec, nil)
+	assert.Empty(t, spec.Process.Env)
+}
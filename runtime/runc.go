@@ -0,0 +1,117 @@
+/* Copyright(C) 2023. Huawei Technologies Co.,Ltd. All rights reserved.
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package main
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// kinds of low level runtime, they differ in some global options
+const (
+	runcKind  = "runc"
+	crunKind  = "crun"
+	youkiKind = "youki"
+	runscKind = "runsc"
+
+	systemdCgroupOption  = "--systemd-cgroup"
+	cgroupManagerOption  = "--cgroup-manager"
+	cgroupManagerSystemd = "systemd"
+	cgroupManagerFs      = "cgroupfs"
+)
+
+// globalValueOptions global options of runc taking the next arg as the value
+var globalValueOptions = map[string]bool{
+	rootOption:     true,
+	"--log":        true,
+	"--log-format": true,
+	"--criu":       true,
+	"--rootless":   true,
+}
+
+// lookRuncPath find the low level runtime, runtime-path first and then runtime-names in PATH
+func lookRuncPath() (string, error) {
+	if runtimeCfg.RuntimePath != "" {
+		runcPath, err := exec.LookPath(runtimeCfg.RuntimePath)
+		if err != nil {
+			return "", fmt.Errorf("failed to find runtime %s: %v", runtimeCfg.RuntimePath, err)
+		}
+		return runcPath, nil
+	}
+	var err error
+	for _, runcName := range runtimeCfg.RuntimeNames {
+		var tempRuncPath string
+		if tempRuncPath, err = exec.LookPath(runcName); err == nil {
+			return tempRuncPath, nil
+		}
+	}
+	return "", fmt.Errorf("failed to find the path of runc: %v", err)
+}
+
+func getRuntimeKind(runcPath string) string {
+	name := filepath.Base(runcPath)
+	for _, kind := range []string{crunKind, youkiKind, runscKind} {
+		if strings.Contains(name, kind) {
+			return kind
+		}
+	}
+	return runcKind
+}
+
+// translateRuntimeArg convert a runc style global option to the one of the runtime, empty means dropping it
+func translateRuntimeArg(kind, arg string) string {
+	if kind == crunKind {
+		if arg == systemdCgroupOption {
+			return cgroupManagerOption + "=" + cgroupManagerSystemd
+		}
+		return arg
+	}
+	switch arg {
+	case cgroupManagerOption + "=" + cgroupManagerSystemd:
+		return systemdCgroupOption
+	case cgroupManagerOption + "=" + cgroupManagerFs:
+		return ""
+	default:
+		return arg
+	}
+}
+
+// getRuncArgs build argv of the runtime, runtime-args of config are put before the original args, only the global
+// options before the command are translated
+func getRuncArgs(runcPath string, args []string) []string {
+	kind := getRuntimeKind(runcPath)
+	runcArgs := []string{runcPath}
+	global, isValue := true, false
+	for _, arg := range append(append([]string{}, runtimeCfg.RuntimeArgs...), args...) {
+		if !global || isValue {
+			runcArgs = append(runcArgs, arg)
+			isValue = false
+			continue
+		}
+		if !strings.HasPrefix(arg, "-") {
+			global = false
+			runcArgs = append(runcArgs, arg)
+			continue
+		}
+		isValue = globalValueOptions[arg]
+		if arg = translateRuntimeArg(kind, arg); arg != "" {
+			runcArgs = append(runcArgs, arg)
+		}
+	}
+	return runcArgs
+}
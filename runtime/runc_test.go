@@ -0,0 +1,73 @@
+/* Copyright(C) 2023. Huawei Technologies Co.,Ltd. All rights reserved.
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package main
+package main
+
+import (
+	"testing"
+
+	"github.com/agiledragon/gomonkey/v2"
+	"github.com/stretchr/testify/assert"
+
+	"ascendconfig"
+)
+
+func TestGetRuncArgs(t *testing.T) {
+	cfg := ascendconfig.Default()
+	cfg.RuntimeArgs = []string{"--cgroup-manager=systemd"}
+	stub := gomonkey.ApplyGlobalVar(&runtimeCfg, cfg)
+	defer stub.Reset()
+
+	args := []string{"create", "--bundle", "/run/bundle", "id"}
+	assert.EqualValues(t, []string{"/usr/bin/runc", "--systemd-cgroup", "create", "--bundle", "/run/bundle", "id"},
+		getRuncArgs("/usr/bin/runc", args))
+	assert.EqualValues(t, []string{"/usr/bin/crun", "--cgroup-manager=systemd", "create", "--bundle", "/run/bundle",
+		"id"}, getRuncArgs("/usr/bin/crun", args))
+
+	cfg.RuntimeArgs = nil
+	args = []string{"--systemd-cgroup", "create", "id"}
+	assert.EqualValues(t, []string{"/usr/bin/crun", "--cgroup-manager=systemd", "create", "id"},
+		getRuncArgs("/usr/bin/crun", args))
+	assert.EqualValues(t, []string{"/usr/bin/youki", "--systemd-cgroup", "create", "id"},
+		getRuncArgs("/usr/bin/youki", args))
+
+	// the args after the command belong to the container
+	args = []string{"--root", "/run/runc", "--systemd-cgroup", "exec", "id", "sh", "-c", "--systemd-cgroup"}
+	assert.EqualValues(t, []string{"/usr/bin/crun", "--root", "/run/runc", "--cgroup-manager=systemd", "exec", "id",
+		"sh", "-c", "--systemd-cgroup"}, getRuncArgs("/usr/bin/crun", args))
+	args = []string{"--log", "--systemd-cgroup", "run", "--cgroup-manager=cgroupfs"}
+	assert.EqualValues(t, []string{"/usr/bin/runc", "--log", "--systemd-cgroup", "run", "--cgroup-manager=cgroupfs"},
+		getRuncArgs("/usr/bin/runc", args))
+}
+
+func TestLookRuncPath(t *testing.T) {
+	cfg := ascendconfig.Default()
+	cfg.RuntimePath = "/bin/sh"
+	cfg.RuntimeNames = []string{"runc123"}
+	stub := gomonkey.ApplyGlobalVar(&runtimeCfg, cfg)
+	defer stub.Reset()
+
+	runcPath, err := lookRuncPath()
+	assert.Nil(t, err)
+	assert.EqualValues(t, "/bin/sh", runcPath)
+
+	cfg.RuntimePath = ""
+	_, err = lookRuncPath()
+	assert.NotNil(t, err)
+
+	cfg.RuntimeNames = []string{"runc123", "sh"}
+	_, err = lookRuncPath()
+	assert.Nil(t, err)
+}
@@ -0,0 +1,57 @@
+/* Copyright(C) 2023. Huawei Technologies Co.,Ltd. All rights reserved.
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package main
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"huawei.com/npu-exporter/v5/common-utils/hwlog"
+
+	"main/injector"
+)
+
+const (
+	topologyCommand        = "topology"
+	topologyRefreshCommand = "refresh"
+)
+
+var topologyOutput io.Writer = os.Stdout
+
+// doTopologyProcess rebuild the topology cache from dcmi, used after hardware or driver changes without reboot
+func doTopologyProcess(topologyArgs []string) error {
+	if len(topologyArgs) == 0 || topologyArgs[0] != topologyRefreshCommand {
+		return fmt.Errorf("usage: ascend-docker-runtime topology refresh")
+	}
+	if runtimeCfg.TopologyCache == "" {
+		return fmt.Errorf("topology cache is disabled by topology-cache in %s", runtimeConfigFile)
+	}
+	session := injector.NewSession(runtimeCfg)
+	defer session.Close()
+	topology, err := session.RefreshTopology()
+	if err != nil {
+		return fmt.Errorf("failed to refresh topology cache: %v", err)
+	}
+	content, err := json.MarshalIndent(topology, "", previewIndent)
+	if err != nil {
+		return fmt.Errorf("failed to marshal topology: %v", err)
+	}
+	hwlog.RunLog.Infof("topology cache %s refreshed", runtimeCfg.TopologyCache)
+	_, err = fmt.Fprintf(topologyOutput, "%s\n", content)
+	return err
+}
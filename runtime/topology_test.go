@@ -0,0 +1,51 @@
+/* Copyright(C) 2023. Huawei Technologies Co.,Ltd. All rights reserved.
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package main
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/agiledragon/gomonkey/v2"
+	"github.com/stretchr/testify/assert"
+
+	"ascendconfig"
+	"main/dcmi"
+)
+
+func TestDoTopologyProcess(t *testing.T) {
+	assert.NotNil(t, doTopologyProcess([]string{}))
+	assert.NotNil(t, doTopologyProcess([]string{"show"}))
+
+	cfg := ascendconfig.Default()
+	stub := gomonkey.ApplyGlobalVar(&runtimeCfg, cfg)
+	defer stub.Reset()
+	var output bytes.Buffer
+	stub.ApplyGlobalVar(&topologyOutput, &output)
+	topology := &dcmi.Topology{ChipNames: map[int32]string{0: "910B"}, Devices: []dcmi.NpuDevice{{PhyID: 0}}}
+	stub.ApplyMethodSeq(reflect.TypeOf(&dcmi.Session{}), "RefreshTopology", []gomonkey.OutputCell{
+		{Values: gomonkey.Params{topology, nil}},
+		{Values: gomonkey.Params{(*dcmi.Topology)(nil), fmt.Errorf("no dcmi")}},
+	})
+	assert.Nil(t, doTopologyProcess([]string{"refresh"}))
+	assert.Contains(t, output.String(), `"0": "910B"`)
+	assert.NotNil(t, doTopologyProcess([]string{"refresh"}))
+
+	cfg.TopologyCache = ""
+	assert.NotNil(t, doTopologyProcess([]string{"refresh"}))
+}